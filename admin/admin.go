@@ -0,0 +1,388 @@
+// Package admin keeps the local administrator accounts that may log in to the
+// web application. Passwords are stored as bcrypt hashes in a JSON file that the
+// web application and the command line share.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MinPasswordLength = 12
+	// bcrypt ignores everything after the first 72 bytes
+	MaxPasswordLength = 72
+	maxUsernameLength = 64
+)
+
+var (
+	ErrNotFound = errors.New("admin account not found")
+	ErrExists   = errors.New("admin account already exists")
+	// ErrLastAccount is returned when removing the only account, which turns
+	// the web application's login off unless another sign-in is configured
+	ErrLastAccount = errors.New("this is the last admin account")
+)
+
+// Role is what an admin may do. Each role includes the ones before it: a viewer
+// reads users and access, an editor also changes them and an administrator also
+// changes the connection settings.
+type Role string
+
+const (
+	RoleViewer        Role = "viewer"
+	RoleEditor        Role = "editor"
+	RoleAdministrator Role = "administrator"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:        1,
+	RoleEditor:        2,
+	RoleAdministrator: 3,
+}
+
+// ParseRole checks a role name
+func ParseRole(name string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(name)))
+	if roleRanks[role] == 0 {
+		return "", fmt.Errorf("unknown role %q: use viewer, editor or administrator", name)
+	}
+	return role, nil
+}
+
+// Includes reports whether the role allows everything the required role allows
+func (r Role) Includes(required Role) bool {
+	return roleRanks[required] > 0 && roleRanks[r] >= roleRanks[required]
+}
+
+// reservedNames are actors the audit log already uses for changes not made by an admin
+var reservedNames = map[string]bool{
+	"anonymous": true,
+	"system":    true,
+}
+
+// Account is one administrator. Hash is the bcrypt hash of the password.
+// Level2Scope limits the groups the admin sees and changes to those below these
+// Level2 units; an empty scope covers everything.
+type Account struct {
+	Username        string    `json:"username"`
+	Hash            string    `json:"hash"`
+	Role            Role      `json:"role,omitempty"`
+	Level2Scope     []string  `json:"level2Scope,omitempty"`
+	Created         time.Time `json:"created"`
+	PasswordChanged time.Time `json:"passwordChanged"`
+}
+
+// EffectiveRole returns the account's role. Accounts created before roles
+// existed have none and keep full access.
+func (a Account) EffectiveRole() Role {
+	if a.Role == "" {
+		return RoleAdministrator
+	}
+	return a.Role
+}
+
+// Store reads and writes the accounts file. The file is read again on every
+// call so changes made from the command line apply to a running server.
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// dummyHash is compared against when the username is unknown, so a failed login
+// takes as long for a missing account as for a wrong password
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// NormalizeUsername trims and lowercases a username and checks its characters
+func NormalizeUsername(username string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(username))
+	if name == "" {
+		return "", fmt.Errorf("username is required")
+	}
+	if len(name) > maxUsernameLength {
+		return "", fmt.Errorf("username must be at most %d characters", maxUsernameLength)
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("._@-", c)) {
+			return "", fmt.Errorf("username may only contain letters, digits and . _ @ -")
+		}
+	}
+	if reservedNames[name] {
+		return "", fmt.Errorf("username %q is reserved", name)
+	}
+	return name, nil
+}
+
+// ValidatePassword checks the length limits of a new password
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", MaxPasswordLength)
+	}
+	return nil
+}
+
+// List returns the accounts sorted by username
+func (s *Store) List() ([]Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// Count returns the number of accounts; a missing file has none
+func (s *Store) Count() (int, error) {
+	accounts, err := s.List()
+	return len(accounts), err
+}
+
+// Get returns the account with the username, or nil when there is none
+func (s *Store) Get(username string) (*Account, error) {
+	accounts, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	i := find(accounts, strings.ToLower(strings.TrimSpace(username)))
+	if i < 0 {
+		return nil, nil
+	}
+	return &accounts[i], nil
+}
+
+// Add creates an account
+func (s *Store) Add(username, password string, role Role) (*Account, error) {
+	name, err := NormalizeUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if roleRanks[role] == 0 {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if find(accounts, name) >= 0 {
+		return nil, ErrExists
+	}
+
+	now := time.Now().UTC()
+	account := Account{Username: name, Hash: hash, Role: role, Created: now, PasswordChanged: now}
+	if err := s.save(append(accounts, account)); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// Remove deletes an account. The last account is only removed with allowLast.
+func (s *Store) Remove(username string, allowLast bool) error {
+	name := strings.ToLower(strings.TrimSpace(username))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts, err := s.load()
+	if err != nil {
+		return err
+	}
+	i := find(accounts, name)
+	if i < 0 {
+		return ErrNotFound
+	}
+	if len(accounts) == 1 && !allowLast {
+		return ErrLastAccount
+	}
+	return s.save(append(accounts[:i], accounts[i+1:]...))
+}
+
+// SetPassword replaces the password of an account
+func (s *Store) SetPassword(username, password string) error {
+	name := strings.ToLower(strings.TrimSpace(username))
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts, err := s.load()
+	if err != nil {
+		return err
+	}
+	i := find(accounts, name)
+	if i < 0 {
+		return ErrNotFound
+	}
+	accounts[i].Hash = hash
+	accounts[i].PasswordChanged = time.Now().UTC()
+	return s.save(accounts)
+}
+
+// SetRole changes the role of an account
+func (s *Store) SetRole(username string, role Role) error {
+	name := strings.ToLower(strings.TrimSpace(username))
+	if roleRanks[role] == 0 {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts, err := s.load()
+	if err != nil {
+		return err
+	}
+	i := find(accounts, name)
+	if i < 0 {
+		return ErrNotFound
+	}
+	accounts[i].Role = role
+	return s.save(accounts)
+}
+
+// SetScope replaces the Level2 units an account is limited to; none lifts the limit.
+// Names match dim.[Object] case-insensitively and are not checked against it here.
+func (s *Store) SetScope(username string, level2Names []string) error {
+	name := strings.ToLower(strings.TrimSpace(username))
+
+	var scope []string
+	seen := make(map[string]bool)
+	for _, level2 := range level2Names {
+		level2 = strings.TrimSpace(level2)
+		if level2 != "" && !seen[strings.ToLower(level2)] {
+			seen[strings.ToLower(level2)] = true
+			scope = append(scope, level2)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts, err := s.load()
+	if err != nil {
+		return err
+	}
+	i := find(accounts, name)
+	if i < 0 {
+		return ErrNotFound
+	}
+	accounts[i].Level2Scope = scope
+	return s.save(accounts)
+}
+
+// Authenticate checks a username and password and returns the normalized username
+func (s *Store) Authenticate(username, password string) (string, bool) {
+	name := strings.ToLower(strings.TrimSpace(username))
+
+	s.mu.Lock()
+	accounts, err := s.load()
+	s.mu.Unlock()
+
+	hash := dummyHash
+	i := -1
+	if err == nil {
+		i = find(accounts, name)
+	}
+	if i >= 0 {
+		hash = []byte(accounts[i].Hash)
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || i < 0 {
+		return "", false
+	}
+	return name, true
+}
+
+func hashPassword(password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func find(accounts []Account, name string) int {
+	for i, a := range accounts {
+		if a.Username == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Store) load() ([]Account, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin accounts: %w", err)
+	}
+
+	var accounts []Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to parse admin accounts: %w", err)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Username < accounts[j].Username })
+	return accounts, nil
+}
+
+// save writes the accounts to a temporary file and renames it over the old one,
+// so a concurrent reader never sees a partial file
+func (s *Store) save(accounts []Account) error {
+	if accounts == nil {
+		accounts = []Account{}
+	}
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal admin accounts: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create admin accounts directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".admins-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write admin accounts: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write admin accounts: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write admin accounts: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write admin accounts: %w", err)
+	}
+	return nil
+}
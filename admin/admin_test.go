@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPassword = "correct horse battery"
+
+// usernames lists the accounts in the store
+func usernames(t *testing.T, s *Store) string {
+	t.Helper()
+	accounts, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(accounts))
+	for i, a := range accounts {
+		names[i] = a.Username
+	}
+	return strings.Join(names, " ")
+}
+
+func TestStoreAddRemove(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "admins.json"))
+
+	// Each step runs against the accounts the earlier steps left
+	steps := []struct {
+		name      string
+		run       func() error
+		wantErr   error
+		wantMatch string
+		accounts  string
+	}{
+		{
+			name:    "remove from a missing file",
+			run:     func() error { return s.Remove("vera", false) },
+			wantErr: ErrNotFound,
+		},
+		{
+			name:     "add normalizes the username",
+			run:      func() error { _, err := s.Add("  Vera ", testPassword, RoleViewer); return err },
+			accounts: "vera",
+		},
+		{
+			name:     "add keeps the accounts sorted",
+			run:      func() error { _, err := s.Add("anna", testPassword, RoleEditor); return err },
+			accounts: "anna vera",
+		},
+		{
+			name:     "add an existing username",
+			run:      func() error { _, err := s.Add("VERA", testPassword, RoleViewer); return err },
+			wantErr:  ErrExists,
+			accounts: "anna vera",
+		},
+		{
+			name:      "add with an unknown role",
+			run:       func() error { _, err := s.Add("bert", testPassword, "owner"); return err },
+			wantMatch: `unknown role "owner"`,
+			accounts:  "anna vera",
+		},
+		{
+			name:      "add with a reserved name",
+			run:       func() error { _, err := s.Add("system", testPassword, RoleViewer); return err },
+			wantMatch: "reserved",
+			accounts:  "anna vera",
+		},
+		{
+			name:      "add with a short password",
+			run:       func() error { _, err := s.Add("bert", "kort", RoleViewer); return err },
+			wantMatch: "at least",
+			accounts:  "anna vera",
+		},
+		{
+			name:     "remove ignores case",
+			run:      func() error { return s.Remove("Anna", false) },
+			accounts: "vera",
+		},
+		{
+			name:     "remove the last account",
+			run:      func() error { return s.Remove("vera", false) },
+			wantErr:  ErrLastAccount,
+			accounts: "vera",
+		},
+		{
+			name: "remove the last account with allowLast",
+			run:  func() error { return s.Remove("vera", true) },
+		},
+	}
+	for _, step := range steps {
+		err := step.run()
+		switch {
+		case step.wantErr != nil:
+			if !errors.Is(err, step.wantErr) {
+				t.Fatalf("%s: %v, want %v", step.name, err, step.wantErr)
+			}
+		case step.wantMatch != "":
+			if err == nil || !strings.Contains(err.Error(), step.wantMatch) {
+				t.Fatalf("%s: %v, want an error containing %q", step.name, err, step.wantMatch)
+			}
+		case err != nil:
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if got := usernames(t, s); got != step.accounts {
+			t.Fatalf("%s: accounts %q, want %q", step.name, got, step.accounts)
+		}
+	}
+}
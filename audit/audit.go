@@ -0,0 +1,97 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"powerbi-access-tool/models"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionUserCreate   = "user.create"
+	ActionUserUpdate   = "user.update"
+	ActionUserDelete   = "user.delete"
+	ActionAccessGrant  = "access.grant"
+	ActionAccessRevoke = "access.revoke"
+	ActionAccessExpire = "access.expire"
+	ActionAccessClean  = "access.cleanup"
+	ActionRuleCreate   = "rule.create"
+	ActionRuleDelete   = "rule.delete"
+	ActionRoleCreate   = "role.create"
+	ActionRoleUpdate   = "role.update"
+	ActionRoleDelete   = "role.delete"
+	ActionRoleAssign   = "role.assign"
+	ActionRoleUnassign = "role.unassign"
+)
+
+// ActorSystem is recorded for changes made by background jobs
+const ActorSystem = "system"
+
+// GrantDescription describes an access record in the Before/After fields
+func GrantDescription(validUntil *time.Time) string {
+	if validUntil == nil {
+		return "granted"
+	}
+	return "granted until " + validUntil.UTC().Format(time.RFC3339)
+}
+
+// UserDeleteEntries describes deleting a user: one revoke per group they lost,
+// then the deletion itself
+func UserDeleteEntries(user models.User, accessList []models.UserAccess, accessRemoved int) []models.AuditEntry {
+	entries := make([]models.AuditEntry, 0, len(accessList)+1)
+	for _, a := range accessList {
+		entries = append(entries, models.AuditEntry{
+			Action:    ActionAccessRevoke,
+			UserID:    user.PowerBIUserID,
+			UserEmail: user.PowerBIUser,
+			GroupBkey: a.GroupBkey,
+			GroupName: a.GroupName,
+			Before:    GrantDescription(a.ValidUntil),
+			After:     "user deleted",
+		})
+	}
+	return append(entries, models.AuditEntry{
+		Action:    ActionUserDelete,
+		UserID:    user.PowerBIUserID,
+		UserEmail: user.PowerBIUser,
+		Before:    fmt.Sprintf("%s (%d groups)", user.PowerBIUser, accessRemoved),
+	})
+}
+
+// Filter narrows an audit log query. Zero values match everything.
+type Filter struct {
+	UserID    int
+	GroupBkey int
+	Actor     string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// Store persists audit entries
+type Store interface {
+	Record(ctx context.Context, entries ...models.AuditEntry) error
+	List(ctx context.Context, filter Filter) ([]models.AuditEntry, error)
+}
+
+// matches reports whether the entry passes the filter
+func (f Filter) matches(e models.AuditEntry) bool {
+	if f.UserID != 0 && e.UserID != f.UserID {
+		return false
+	}
+	if f.GroupBkey != 0 && e.GroupBkey != f.GroupBkey {
+		return false
+	}
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if !f.From.IsZero() && e.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.Timestamp.Before(f.To) {
+		return false
+	}
+	return true
+}
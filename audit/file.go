@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"powerbi-access-tool/models"
+)
+
+// FileStore appends audit entries as JSON lines to a local file
+type FileStore struct {
+	mu     sync.Mutex
+	path   string
+	nextID int
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	s := &FileStore{path: path, nextID: 1}
+
+	// Continue numbering after the last entry already in the file
+	entries, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		s.nextID = entries[len(entries)-1].ID + 1
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Record(ctx context.Context, entries ...models.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		e.ID = s.nextID
+		if e.Timestamp.IsZero() {
+			e.Timestamp = time.Now().UTC()
+		}
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to write audit entry: %w", err)
+		}
+		s.nextID++
+	}
+
+	return nil
+}
+
+// List returns matching entries, newest first
+func (s *FileStore) List(ctx context.Context, filter Filter) ([]models.AuditEntry, error) {
+	s.mu.Lock()
+	entries, err := s.readAll()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []models.AuditEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !filter.matches(entries[i]) {
+			continue
+		}
+		result = append(result, entries[i])
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func (s *FileStore) readAll() ([]models.AuditEntry, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []models.AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit file: %w", err)
+	}
+
+	return entries, nil
+}
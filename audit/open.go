@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"powerbi-access-tool/config"
+)
+
+// Open selects the audit store from the config. The SQL store needs a database
+// connection; without one the JSON-lines file is used instead. It returns nil
+// when no store can be opened, which disables the audit log.
+func Open(cfg *config.Config, database *sql.DB) Store {
+	if cfg.AuditStore != "file" && database != nil {
+		store, err := NewSQLStore(database, cfg.AuditSchema)
+		if err == nil {
+			err = store.EnsureTable(context.Background())
+		}
+		if err == nil {
+			return store
+		}
+		log.Printf("Warning: SQL audit log unavailable, falling back to file: %v", err)
+	}
+
+	path, err := cfg.AuditFilePath()
+	if err != nil {
+		log.Printf("Warning: audit log disabled: %v", err)
+		return nil
+	}
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		log.Printf("Warning: audit log disabled: %v", err)
+		return nil
+	}
+	return store
+}
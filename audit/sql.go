@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"powerbi-access-tool/models"
+)
+
+var validSchema = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLStore keeps audit entries in an AuditLog table in a configurable schema
+type SQLStore struct {
+	db    *sql.DB
+	table string
+}
+
+func NewSQLStore(db *sql.DB, schema string) (*SQLStore, error) {
+	// The schema name is part of the statement, so only plain identifiers are accepted
+	if !validSchema.MatchString(schema) {
+		return nil, fmt.Errorf("invalid audit schema: %s", schema)
+	}
+	return &SQLStore{db: db, table: fmt.Sprintf("[%s].AuditLog", schema)}, nil
+}
+
+// EnsureTable creates the audit table when it does not exist yet
+func (s *SQLStore) EnsureTable(ctx context.Context) error {
+	query := fmt.Sprintf(`
+		IF OBJECT_ID(N'%[1]s', N'U') IS NULL
+		CREATE TABLE %[1]s (
+			AuditID INT IDENTITY(1,1) PRIMARY KEY,
+			EventTime DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME(),
+			Actor NVARCHAR(256) NOT NULL,
+			Action NVARCHAR(64) NOT NULL,
+			UserID INT NULL,
+			UserEmail NVARCHAR(256) NULL,
+			Group_Bkey INT NULL,
+			GroupName NVARCHAR(256) NULL,
+			BeforeValue NVARCHAR(MAX) NULL,
+			AfterValue NVARCHAR(MAX) NULL,
+			ClientIP NVARCHAR(64) NULL
+		)`, s.table)
+
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create audit table: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLStore) Record(ctx context.Context, entries ...models.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (EventTime, Actor, Action, UserID, UserEmail, Group_Bkey, GroupName, BeforeValue, AfterValue, ClientIP)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10)`, s.table)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	for _, e := range entries {
+		if e.Timestamp.IsZero() {
+			e.Timestamp = time.Now().UTC()
+		}
+		_, err := tx.ExecContext(ctx, query,
+			e.Timestamp, e.Actor, e.Action,
+			nullInt(e.UserID), nullString(e.UserEmail),
+			nullInt(e.GroupBkey), nullString(e.GroupName),
+			nullString(e.Before), nullString(e.After), nullString(e.ClientIP))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to write audit entry: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit audit entries: %w", err)
+	}
+	return nil
+}
+
+// List returns matching entries, newest first
+func (s *SQLStore) List(ctx context.Context, filter Filter) ([]models.AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserID != 0 {
+		addCondition("UserID = @p%d", filter.UserID)
+	}
+	if filter.GroupBkey != 0 {
+		addCondition("Group_Bkey = @p%d", filter.GroupBkey)
+	}
+	if filter.Actor != "" {
+		addCondition("Actor = @p%d", filter.Actor)
+	}
+	if !filter.From.IsZero() {
+		addCondition("EventTime >= @p%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("EventTime < @p%d", filter.To)
+	}
+
+	top := ""
+	if filter.Limit > 0 {
+		top = fmt.Sprintf("TOP (%d) ", filter.Limit)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %sAuditID, EventTime, Actor, Action, UserID, UserEmail, Group_Bkey, GroupName, BeforeValue, AfterValue, ClientIP
+		FROM %s`, top, s.table)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY AuditID DESC"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		var userID, groupBkey sql.NullInt64
+		var userEmail, groupName, before, after, clientIP sql.NullString
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Actor, &e.Action, &userID, &userEmail,
+			&groupBkey, &groupName, &before, &after, &clientIP); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		e.UserID = int(userID.Int64)
+		e.UserEmail = userEmail.String
+		e.GroupBkey = int(groupBkey.Int64)
+		e.GroupName = groupName.String
+		e.Before = before.String
+		e.After = after.String
+		e.ClientIP = clientIP.String
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+func nullInt(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
+
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
@@ -0,0 +1,208 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+)
+
+func runAccess(env *env, args []string) error {
+	return dispatch(env, "access", args, map[string]subcommand{
+		"list":   accessList,
+		"grant":  persistent(accessGrant),
+		"revoke": persistent(accessRevoke),
+	})
+}
+
+func accessList(env *env, args []string) error {
+	fs := newFlagSet("access list")
+	orphaned := fs.Bool("orphaned", false, "include records whose group no longer exists")
+	asJSON := fs.Bool("json", false, "print JSON")
+	rest, err := parseArgs(fs, args, 1, 1, "access list [-orphaned] [-json] <id|email>")
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(env, rest[0])
+	if err != nil {
+		return err
+	}
+
+	access, err := env.stores.Access.ListByUser(env.ctx, user.PowerBIUserID, *orphaned)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if access == nil {
+			access = []models.UserAccess{}
+		}
+		return printJSON(env.stdout, access)
+	}
+
+	rows := make([][]string, len(access))
+	for i, a := range access {
+		name := a.GroupName
+		if a.Orphaned {
+			name = "(group no longer exists)"
+		}
+		validUntil := ""
+		if a.ValidUntil != nil {
+			validUntil = formatTime(*a.ValidUntil)
+		}
+		rows[i] = []string{strconv.Itoa(a.UserAccessID), strconv.Itoa(a.GroupBkey), name, formatTime(a.CreationDate), validUntil}
+	}
+	return printTable(env.stdout, []string{"ID", "GROUP", "NAME", "GRANTED", "VALID UNTIL"}, rows)
+}
+
+func accessGrant(env *env, args []string) error {
+	fs := newFlagSet("access grant")
+	until := fs.String("until", "", "revoke automatically after this date (YYYY-MM-DD)")
+	level2 := fs.String("level2", "", "grant every group below this Level2 unit")
+	level3 := fs.String("level3", "", "grant every group below this Level3 unit")
+	asJSON := fs.Bool("json", false, "print JSON")
+	usage := "access grant [-until date] [-level2 name] [-level3 name] [-json] <id|email> [group-key...]"
+	rest, err := parseArgs(fs, args, 1, -1, usage)
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(env, rest[0])
+	if err != nil {
+		return err
+	}
+
+	groupBkeys, err := parseGroupKeys(rest[1:])
+	if err != nil {
+		return err
+	}
+
+	if *level2 != "" || *level3 != "" {
+		under, err := env.stores.Groups.GroupsUnder(env.ctx, *level2, *level3)
+		if err != nil {
+			return err
+		}
+		if len(under) == 0 {
+			return fmt.Errorf("no groups found for this unit")
+		}
+		groupBkeys = append(groupBkeys, under...)
+	}
+
+	if len(groupBkeys) == 0 {
+		return fmt.Errorf("usage: %s", usage)
+	}
+
+	var validUntil *time.Time
+	if *until != "" {
+		day, err := time.ParseInLocation("2006-01-02", *until, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", *until)
+		}
+		end := day.Add(24*time.Hour - time.Second)
+		if !end.After(time.Now()) {
+			return fmt.Errorf("valid until must be in the future")
+		}
+		validUntil = &end
+	}
+
+	grants := models.PermanentGrants(groupBkeys)
+	for i := range grants {
+		grants[i].ValidUntil = validUntil
+	}
+
+	result, err := env.stores.Access.GrantWithExpiry(env.ctx, user.PowerBIUserID, grants)
+	if err != nil {
+		return err
+	}
+
+	if len(result.Added) > 0 {
+		access, err := env.stores.Access.ListByUser(env.ctx, user.PowerBIUserID, false)
+		if err != nil {
+			return err
+		}
+		names := make(map[int]string, len(access))
+		for _, a := range access {
+			names[a.GroupBkey] = a.GroupName
+		}
+
+		entries := make([]models.AuditEntry, 0, len(result.Added))
+		for _, groupBkey := range result.Added {
+			entries = append(entries, models.AuditEntry{
+				Action:    audit.ActionAccessGrant,
+				UserID:    user.PowerBIUserID,
+				UserEmail: user.PowerBIUser,
+				GroupBkey: groupBkey,
+				GroupName: names[groupBkey],
+				After:     audit.GrantDescription(validUntil),
+			})
+		}
+		env.record(entries...)
+	}
+
+	if *asJSON {
+		return printJSON(env.stdout, result)
+	}
+	fmt.Fprintf(env.stdout, "Granted %d groups to %s (%d already present, %d unknown)\n",
+		len(result.Added), user.PowerBIUser, len(result.Skipped), len(result.Unknown))
+	if len(result.Unknown) > 0 {
+		fmt.Fprintf(env.stdout, "Unknown group keys: %v\n", result.Unknown)
+	}
+	return nil
+}
+
+func accessRevoke(env *env, args []string) error {
+	fs := newFlagSet("access revoke")
+	rest, err := parseArgs(fs, args, 2, -1, "access revoke <id|email> <group-key...>")
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(env, rest[0])
+	if err != nil {
+		return err
+	}
+
+	groupBkeys, err := parseGroupKeys(rest[1:])
+	if err != nil {
+		return err
+	}
+	revoke := make(map[int]bool, len(groupBkeys))
+	for _, groupBkey := range groupBkeys {
+		revoke[groupBkey] = true
+	}
+
+	access, err := env.stores.Access.ListByUser(env.ctx, user.PowerBIUserID, true)
+	if err != nil {
+		return err
+	}
+
+	var entries []models.AuditEntry
+	for _, a := range access {
+		if !revoke[a.GroupBkey] {
+			continue
+		}
+		if err := env.stores.Access.Remove(env.ctx, a.UserAccessID); err != nil {
+			env.record(entries...)
+			return err
+		}
+		delete(revoke, a.GroupBkey)
+		entries = append(entries, models.AuditEntry{
+			Action:    audit.ActionAccessRevoke,
+			UserID:    user.PowerBIUserID,
+			UserEmail: user.PowerBIUser,
+			GroupBkey: a.GroupBkey,
+			GroupName: a.GroupName,
+			Before:    audit.GrantDescription(a.ValidUntil),
+		})
+	}
+	env.record(entries...)
+
+	fmt.Fprintf(env.stdout, "Revoked %d groups from %s\n", len(entries), user.PowerBIUser)
+	for groupBkey := range revoke {
+		fmt.Fprintf(env.stdout, "No access to group %d\n", groupBkey)
+	}
+	return nil
+}
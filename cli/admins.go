@@ -0,0 +1,284 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"powerbi-access-tool/admin"
+)
+
+// adminAccount is an account without its password hash, for -json output
+type adminAccount struct {
+	Username        string   `json:"username"`
+	Role            string   `json:"role"`
+	Level2Scope     []string `json:"level2Scope"`
+	Created         string   `json:"created"`
+	PasswordChanged string   `json:"passwordChanged"`
+}
+
+func runAdmins(env *env, args []string) error {
+	return dispatch(env, "admins", args, map[string]subcommand{
+		"list":   adminsList,
+		"add":    adminsAdd,
+		"remove": adminsRemove,
+		"reset":  adminsReset,
+		"role":   adminsRole,
+		"scope":  adminsScope,
+	})
+}
+
+func adminsList(env *env, args []string) error {
+	fs := newFlagSet("admins list")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseArgs(fs, args, 0, 0, "admins list [-json]"); err != nil {
+		return err
+	}
+
+	accounts, err := env.admins.List()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		out := make([]adminAccount, len(accounts))
+		for i, a := range accounts {
+			out[i] = adminAccount{
+				Username:        a.Username,
+				Role:            string(a.EffectiveRole()),
+				Level2Scope:     a.Level2Scope,
+				Created:         a.Created.Format(time.RFC3339),
+				PasswordChanged: a.PasswordChanged.Format(time.RFC3339),
+			}
+		}
+		return printJSON(env.stdout, out)
+	}
+
+	rows := make([][]string, len(accounts))
+	for i, a := range accounts {
+		scope := "(all)"
+		if len(a.Level2Scope) > 0 {
+			scope = strings.Join(a.Level2Scope, ", ")
+		}
+		rows[i] = []string{a.Username, string(a.EffectiveRole()), scope, formatTime(a.Created), formatTime(a.PasswordChanged)}
+	}
+	return printTable(env.stdout, []string{"USERNAME", "ROLE", "LEVEL2 SCOPE", "CREATED", "PASSWORD CHANGED"}, rows)
+}
+
+// adminsAdd creates an account. The first account defaults to administrator,
+// since a viewer could not manage the accounts that come after it.
+func adminsAdd(env *env, args []string) error {
+	fs := newFlagSet("admins add")
+	roleName := fs.String("role", "", "viewer, editor or administrator (default administrator for the first account, viewer after)")
+	rest, err := parseArgs(fs, args, 1, 1, "admins add [-role viewer|editor|administrator] <username>")
+	if err != nil {
+		return err
+	}
+	if *roleName == "" {
+		count, err := env.admins.Count()
+		if err != nil {
+			return err
+		}
+		*roleName = string(admin.RoleViewer)
+		if count == 0 {
+			*roleName = string(admin.RoleAdministrator)
+		}
+	}
+	role, err := admin.ParseRole(*roleName)
+	if err != nil {
+		return err
+	}
+	username, err := admin.NormalizeUsername(rest[0])
+	if err != nil {
+		return err
+	}
+	if account, err := env.admins.Get(username); err != nil {
+		return err
+	} else if account != nil {
+		return fmt.Errorf("admin %q already exists", username)
+	}
+
+	password, err := readNewPassword(env)
+	if err != nil {
+		return err
+	}
+	if _, err := env.admins.Add(username, password, role); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(env.stdout, "Created admin %s (%s)\n", username, role)
+	return nil
+}
+
+// adminsRemove deletes an account. Without accounts the web application falls
+// back to POWERBI_ADMIN_PASSWORD or OIDC, and with neither it needs no login at
+// all, so removing the last account takes -force.
+func adminsRemove(env *env, args []string) error {
+	fs := newFlagSet("admins remove")
+	force := fs.Bool("force", false, "also remove the last account")
+	rest, err := parseArgs(fs, args, 1, 1, "admins remove [-force] <username>")
+	if err != nil {
+		return err
+	}
+
+	err = env.admins.Remove(rest[0], *force)
+	if errors.Is(err, admin.ErrLastAccount) {
+		return fmt.Errorf("%s: %w; removing it turns authentication off unless POWERBI_ADMIN_PASSWORD or OIDC is configured, use -force to remove it anyway", rest[0], err)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", rest[0], err)
+	}
+
+	fmt.Fprintf(env.stdout, "Removed admin %s\n", rest[0])
+	if count, err := env.admins.Count(); err == nil && count == 0 {
+		fmt.Fprintln(os.Stderr, "Warning: no admin accounts are left; without POWERBI_ADMIN_PASSWORD or OIDC the web application now lets everyone in as administrator")
+	}
+	return nil
+}
+
+func adminsReset(env *env, args []string) error {
+	rest, err := parseArgs(newFlagSet("admins reset"), args, 1, 1, "admins reset <username>")
+	if err != nil {
+		return err
+	}
+	if account, err := env.admins.Get(rest[0]); err != nil {
+		return err
+	} else if account == nil {
+		return fmt.Errorf("%s: %w", rest[0], admin.ErrNotFound)
+	}
+
+	password, err := readNewPassword(env)
+	if err != nil {
+		return err
+	}
+	if err := env.admins.SetPassword(rest[0], password); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(env.stdout, "Password of admin %s reset\n", rest[0])
+	return nil
+}
+
+func adminsRole(env *env, args []string) error {
+	usage := "admins role <username> viewer|editor|administrator"
+	rest, err := parseArgs(newFlagSet("admins role"), args, 2, 2, usage)
+	if err != nil {
+		return err
+	}
+	role, err := admin.ParseRole(rest[1])
+	if err != nil {
+		return err
+	}
+
+	if err := env.admins.SetRole(rest[0], role); err != nil {
+		return fmt.Errorf("%s: %w", rest[0], err)
+	}
+
+	fmt.Fprintf(env.stdout, "Admin %s is now %s\n", rest[0], role)
+	return nil
+}
+
+// adminsScope limits an admin to groups below the given Level2 units. With -check
+// the names are looked up in dim.[Object] and stored as spelled there; without it
+// they are stored unchecked, and a misspelled unit hides every group.
+func adminsScope(env *env, args []string) error {
+	fs := newFlagSet("admins scope")
+	check := fs.Bool("check", false, "verify the names against dim.[Object]")
+	rest, err := parseArgs(fs, args, 1, -1, "admins scope [-check] <username> [level2-name...]")
+	if err != nil {
+		return err
+	}
+	level2Names := rest[1:]
+
+	if *check && len(level2Names) > 0 {
+		if err := env.connect(); err != nil {
+			return err
+		}
+		if level2Names, err = checkLevel2Names(env, level2Names); err != nil {
+			return err
+		}
+	}
+
+	if err := env.admins.SetScope(rest[0], level2Names); err != nil {
+		return fmt.Errorf("%s: %w", rest[0], err)
+	}
+
+	if len(level2Names) == 0 {
+		fmt.Fprintf(env.stdout, "Admin %s is no longer limited to Level2 units\n", rest[0])
+		return nil
+	}
+	fmt.Fprintf(env.stdout, "Admin %s is limited to %s\n", rest[0], strings.Join(level2Names, ", "))
+	if !*check {
+		fmt.Fprintln(os.Stderr, "Warning: the Level2 names were not checked against dim.[Object]; use -check to verify them")
+	}
+	return nil
+}
+
+// checkLevel2Names returns the names as spelled in dim.[Object], or an error
+// listing the ones that are not there
+func checkLevel2Names(env *env, names []string) ([]string, error) {
+	level1s, err := env.stores.Groups.Children(env.ctx, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]string)
+	for _, level1 := range level1s {
+		level2s, err := env.stores.Groups.Children(env.ctx, []string{level1.Name}, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, level2 := range level2s {
+			known[strings.ToLower(level2.Name)] = level2.Name
+		}
+	}
+
+	checked := make([]string, 0, len(names))
+	var unknown []string
+	for _, name := range names {
+		if spelled, ok := known[strings.ToLower(strings.TrimSpace(name))]; ok {
+			checked = append(checked, spelled)
+		} else {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown Level2 units: %s", strings.Join(unknown, ", "))
+	}
+	return checked, nil
+}
+
+// readNewPassword asks for a password twice on standard input. The input is
+// not hidden; pipe it in when others can see the terminal.
+func readNewPassword(env *env) (string, error) {
+	in := bufio.NewReader(env.stdin)
+
+	fmt.Fprint(env.stdout, "Password: ")
+	password, err := readLine(in)
+	if err != nil {
+		return "", err
+	}
+	if err := admin.ValidatePassword(password); err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(env.stdout, "Repeat password: ")
+	repeated, err := readLine(in)
+	if err != nil {
+		return "", err
+	}
+	if repeated != password {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return password, nil
+}
+
+func readLine(in *bufio.Reader) (string, error) {
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
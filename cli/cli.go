@@ -0,0 +1,247 @@
+// Package cli implements the command-line subcommands of the access tool.
+// They share the configuration and repositories with the web application.
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"powerbi-access-tool/admin"
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/config"
+	"powerbi-access-tool/db"
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+// command is a subcommand; args excludes the command name itself. Local
+// commands only use files next to the config and do not connect to the store.
+type command struct {
+	args    string
+	summary string
+	local   bool
+	run     func(env *env, args []string) error
+}
+
+var commands = map[string]command{
+	"admins": {args: "list|add|remove|reset|role|scope", summary: "manage the admin accounts of the web application", local: true, run: runAdmins},
+	"users":  {args: "list|add|rename|delete", summary: "manage users in powerbi.Users", run: runUsers},
+	"access": {args: "list|grant|revoke", summary: "manage a user's groups in powerbi.UserAccess", run: runAccess},
+	"groups": {args: "search", summary: "search dim.[Group]", run: runGroups},
+	"plan":   {args: "[-json] [-allow-delete-all] <file>", summary: "show the changes needed to match a desired-state file", run: runPlan},
+	"apply":  {args: "[-auto-approve] [-allow-delete-all] <file>", summary: "apply a desired-state file in one transaction", run: persistent(runApply)},
+}
+
+// IsCommand reports whether name is a known subcommand
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok || name == "help"
+}
+
+// Run executes the subcommand in args[0] and returns the process exit code
+func Run(args []string) int {
+	if len(args) == 0 || args[0] == "help" {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	env, err := openEnv(!cmd.local)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer env.close()
+
+	if err := cmd.run(env, args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// subcommand is one action of a command group, such as "list" in "users list"
+type subcommand func(env *env, args []string) error
+
+// errMemoryStore is returned by subcommands that change data when the in-memory store is selected
+var errMemoryStore = errors.New("POWERBI_STORE=memory gives every command a fresh in-memory store, so the change would be lost; unset it to change the database")
+
+// persistent marks a subcommand that changes data. Each invocation opens the
+// in-memory store afresh, so such subcommands are refused against it.
+func persistent(run subcommand) subcommand {
+	return func(env *env, args []string) error {
+		if env.memory {
+			return errMemoryStore
+		}
+		return run(env, args)
+	}
+}
+
+// dispatch runs the subcommand of a command group such as "users list"
+func dispatch(env *env, name string, args []string, subcommands map[string]subcommand) error {
+	if len(args) > 0 {
+		if run, ok := subcommands[args[0]]; ok {
+			return run(env, args[1:])
+		}
+	}
+
+	names := make([]string, 0, len(subcommands))
+	for sub := range subcommands {
+		names = append(names, sub)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("usage: %s %s", name, strings.Join(names, "|"))
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: powerbi-access-tool [command]")
+	fmt.Fprintln(w, "\nWithout a command the web application is started. Flags go before")
+	fmt.Fprintln(w, "arguments; list commands accept -json for machine-readable output.")
+	fmt.Fprintln(w, "\nCommands:")
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, name := range sortedCommands() {
+		cmd := commands[name]
+		fmt.Fprintf(tw, "  %s %s\t%s\n", name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
+}
+
+func sortedCommands() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// env holds what a command works with
+type env struct {
+	ctx      context.Context
+	stores   repository.Stores
+	auditLog audit.Store
+	actor    string
+	stdin    io.Reader
+	stdout   io.Writer
+	database *sql.DB
+	admins   *admin.Store
+	cfg      *config.Config
+	memory   bool
+}
+
+// openEnv connects to the configured store the same way the web application does:
+// POWERBI_STORE=memory selects the in-memory store, otherwise SQL Server is used.
+// Without connect only the local files are opened.
+func openEnv(connect bool) (*env, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	e := &env{
+		ctx:    context.Background(),
+		cfg:    cfg,
+		actor:  cliActor(),
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+	}
+
+	adminsPath, err := cfg.AdminsFilePath()
+	if err != nil {
+		return nil, err
+	}
+	e.admins = admin.NewStore(adminsPath)
+
+	if connect {
+		if err := e.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// connect opens the store; local commands call it when they need the database
+func (e *env) connect() error {
+	cfg := e.cfg
+
+	if os.Getenv("POWERBI_STORE") == "memory" {
+		memDB, err := repository.OpenMemoryDB(os.Getenv("POWERBI_MEMORY_SEED"))
+		if err != nil {
+			return err
+		}
+		e.stores = repository.NewMemoryStores(memDB)
+		e.auditLog = audit.Open(cfg, nil)
+		e.memory = true
+		return nil
+	}
+
+	if cfg.Username == "" || cfg.Password == "" {
+		return fmt.Errorf("no database credentials configured; set them in Settings first")
+	}
+
+	database, err := db.Open(db.Config{
+		Server:   cfg.Server,
+		Database: cfg.Database,
+		Username: cfg.Username,
+		Password: cfg.Password,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := repository.EnsureSchema(e.ctx, database); err != nil {
+		database.Close()
+		return err
+	}
+
+	e.database = database
+	e.stores = repository.NewSQLStores(database)
+	e.auditLog = audit.Open(cfg, database)
+	return nil
+}
+
+func (e *env) close() {
+	if e.database != nil {
+		e.database.Close()
+	}
+}
+
+// record writes audit entries under the command-line actor. Failures are reported
+// but do not fail the command; the change itself has already been made.
+func (e *env) record(entries ...models.AuditEntry) {
+	if e.auditLog == nil || len(entries) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	for i := range entries {
+		entries[i].Timestamp = now
+		entries[i].Actor = e.actor
+	}
+	if err := e.auditLog.Record(e.ctx, entries...); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", err)
+	}
+}
+
+// cliActor identifies changes made from the command line by the OS account
+func cliActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/admin"
+)
+
+func TestMemoryStoreRefusesChanges(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("POWERBI_STORE", "memory")
+	t.Setenv("POWERBI_MEMORY_SEED", "")
+
+	tests := []struct {
+		args    string
+		refused bool
+	}{
+		{"users list", false},
+		{"access list 1", false},
+		{"groups search Noord", false},
+		{"users add nieuw@voorbeeld.nl", true},
+		{"users rename 1 anne@voorbeeld.nl", true},
+		{"users delete 1", true},
+		{"access grant 1 1001", true},
+		{"access revoke 1 1001", true},
+		{"apply -auto-approve state.json", true},
+	}
+	for _, tt := range tests {
+		env, err := openEnv(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env.stdout = io.Discard
+
+		args := strings.Fields(tt.args)
+		err = commands[args[0]].run(env, args[1:])
+		if refused := errors.Is(err, errMemoryStore); refused != tt.refused {
+			t.Errorf("%s: %v, want refused %v", tt.args, err, tt.refused)
+		}
+		if !tt.refused && err != nil {
+			t.Errorf("%s: %v", tt.args, err)
+		}
+	}
+}
+
+func TestAdminsAddDefaultsFirstAccountToAdministrator(t *testing.T) {
+	store := admin.NewStore(filepath.Join(t.TempDir(), "admins.json"))
+
+	tests := []struct {
+		args string
+		want admin.Role
+	}{
+		{"add eerste", admin.RoleAdministrator},
+		{"add tweede", admin.RoleViewer},
+		{"add -role editor derde", admin.RoleEditor},
+	}
+	for _, tt := range tests {
+		env := &env{
+			stdin:  strings.NewReader("correct horse battery\ncorrect horse battery\n"),
+			stdout: io.Discard,
+			admins: store,
+		}
+		args := strings.Fields(tt.args)
+		if err := runAdmins(env, args); err != nil {
+			t.Fatalf("%s: %v", tt.args, err)
+		}
+		account, err := store.Get(args[len(args)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account == nil || account.EffectiveRole() != tt.want {
+			t.Errorf("%s: got %+v, want role %s", tt.args, account, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"strconv"
+	"strings"
+
+	"powerbi-access-tool/models"
+)
+
+func runGroups(env *env, args []string) error {
+	return dispatch(env, "groups", args, map[string]subcommand{
+		"search": groupsSearch,
+	})
+}
+
+func groupsSearch(env *env, args []string) error {
+	fs := newFlagSet("groups search")
+	asJSON := fs.Bool("json", false, "print JSON")
+	rest, err := parseArgs(fs, args, 1, -1, "groups search [-json] <term>")
+	if err != nil {
+		return err
+	}
+
+	results, err := env.stores.Groups.Search(env.ctx, strings.Join(rest, " "), nil)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if results == nil {
+			results = []models.SearchResult{}
+		}
+		return printJSON(env.stdout, results)
+	}
+
+	rows := make([][]string, len(results))
+	for i, res := range results {
+		rows[i] = []string{strconv.Itoa(res.GroupBkey), res.GroupName, res.Match, strings.Join(res.MatchedOn, ", ")}
+	}
+	return printTable(env.stdout, []string{"GROUP", "NAME", "MATCH", "MATCHED ON"}, rows)
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+// printJSON writes v as indented JSON
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable writes a header and rows as aligned columns
+func printTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// newFlagSet returns a flag set that reports errors instead of printing usage
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseArgs parses the flags and checks the number of positional arguments.
+// max < 0 allows any number above min.
+func parseArgs(fs *flag.FlagSet, args []string, min, max int, usage string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w\nusage: %s", err, usage)
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return nil, fmt.Errorf("usage: %s", usage)
+	}
+	return fs.Args(), nil
+}
+
+// findUser resolves a user ID or email address
+func findUser(env *env, ref string) (*models.User, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		user, err := env.stores.Users.GetByID(env.ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("user %d not found", id)
+		}
+		return user, nil
+	}
+
+	users, _, err := env.stores.Users.List(env.ctx, repository.UserListOptions{Filter: ref})
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		if strings.EqualFold(users[i].PowerBIUser, ref) {
+			return &users[i], nil
+		}
+	}
+	return nil, fmt.Errorf("user %s not found", ref)
+}
+
+// parseGroupKeys converts group key arguments to integers
+func parseGroupKeys(args []string) ([]int, error) {
+	groupBkeys := make([]int, 0, len(args))
+	for _, arg := range args {
+		groupBkey, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group key %q", arg)
+		}
+		groupBkeys = append(groupBkeys, groupBkey)
+	}
+	return groupBkeys, nil
+}
+
+// formatTime renders a timestamp for table output
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04")
+}
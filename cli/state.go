@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/state"
+)
+
+func runPlan(env *env, args []string) error {
+	fs := newFlagSet("plan")
+	asJSON := fs.Bool("json", false, "print the plan as JSON")
+	allowDeleteAll := fs.Bool("allow-delete-all", false, "allow a file without users, which deletes every user")
+	rest, err := parseArgs(fs, args, 1, 1, "plan [-json] [-allow-delete-all] <file>")
+	if err != nil {
+		return err
+	}
+
+	plan, err := loadPlan(env, rest[0], *allowDeleteAll)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(env.stdout, plan)
+	}
+	return state.Print(env.stdout, plan)
+}
+
+func runApply(env *env, args []string) error {
+	fs := newFlagSet("apply")
+	autoApprove := fs.Bool("auto-approve", false, "apply without asking for confirmation")
+	allowDeleteAll := fs.Bool("allow-delete-all", false, "allow a file without users, which deletes every user")
+	rest, err := parseArgs(fs, args, 1, 1, "apply [-auto-approve] [-allow-delete-all] <file>")
+	if err != nil {
+		return err
+	}
+
+	plan, err := loadPlan(env, rest[0], *allowDeleteAll)
+	if err != nil {
+		return err
+	}
+
+	if err := state.Print(env.stdout, plan); err != nil {
+		return err
+	}
+	if len(plan.Changes) == 0 {
+		return nil
+	}
+
+	if !*autoApprove && !confirm(env.stdin, env.stdout) {
+		fmt.Fprintln(env.stdout, "Apply cancelled.")
+		return nil
+	}
+
+	created, err := env.stores.Plans.Apply(env.ctx, plan)
+	if err != nil {
+		return err
+	}
+	env.record(planAuditEntries(plan, created)...)
+
+	counts := state.Summary(plan)
+	fmt.Fprintf(env.stdout, "\nApply complete! %d created, %d granted, %d revoked, %d deleted.\n",
+		counts[models.PlanCreateUser], counts[models.PlanGrant], counts[models.PlanRevoke], counts[models.PlanDeleteUser])
+	return nil
+}
+
+func loadPlan(env *env, path string, allowDeleteAll bool) (*models.Plan, error) {
+	f, err := state.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := state.Plan(env.ctx, env.stores, f, allowDeleteAll)
+	if errors.Is(err, state.ErrDeletesAllUsers) {
+		return nil, fmt.Errorf("%w; pass -allow-delete-all if that is intended", err)
+	}
+	return plan, err
+}
+
+// confirm asks the operator to type yes, like terraform apply
+func confirm(in io.Reader, out io.Writer) bool {
+	fmt.Fprint(out, "\nDo you want to apply these changes? Only 'yes' will be accepted: ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+func planAuditEntries(plan *models.Plan, created models.CreatedUsers) []models.AuditEntry {
+	entries := make([]models.AuditEntry, 0, len(plan.Changes))
+	for _, c := range plan.Changes {
+		entry := models.AuditEntry{
+			UserID:    created.UserID(c),
+			UserEmail: c.Email,
+			GroupBkey: c.GroupBkey,
+			GroupName: c.GroupName,
+		}
+		switch c.Action {
+		case models.PlanCreateUser:
+			entry.Action = audit.ActionUserCreate
+			entry.After = c.Email
+		case models.PlanGrant:
+			entry.Action = audit.ActionAccessGrant
+			entry.After = "granted by state file"
+		case models.PlanRevoke:
+			entry.Action = audit.ActionAccessRevoke
+			entry.Before = "granted"
+		case models.PlanDeleteUser:
+			entry.Action = audit.ActionUserDelete
+			entry.Before = c.Email
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+func runUsers(env *env, args []string) error {
+	return dispatch(env, "users", args, map[string]subcommand{
+		"list":   usersList,
+		"add":    persistent(usersAdd),
+		"rename": persistent(usersRename),
+		"delete": persistent(usersDelete),
+	})
+}
+
+func usersList(env *env, args []string) error {
+	fs := newFlagSet("users list")
+	filter := fs.String("filter", "", "only users whose email contains this text")
+	sortField := fs.String("sort", "email", "sort by id or email")
+	asJSON := fs.Bool("json", false, "print JSON")
+	if _, err := parseArgs(fs, args, 0, 0, "users list [-filter text] [-sort id|email] [-json]"); err != nil {
+		return err
+	}
+
+	users, _, err := env.stores.Users.List(env.ctx, repository.UserListOptions{
+		Filter:    *filter,
+		SortField: *sortField,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if users == nil {
+			users = []models.User{}
+		}
+		return printJSON(env.stdout, users)
+	}
+
+	rows := make([][]string, len(users))
+	for i, u := range users {
+		rows[i] = []string{strconv.Itoa(u.PowerBIUserID), u.PowerBIUser}
+	}
+	return printTable(env.stdout, []string{"ID", "EMAIL"}, rows)
+}
+
+func usersAdd(env *env, args []string) error {
+	fs := newFlagSet("users add")
+	asJSON := fs.Bool("json", false, "print JSON")
+	rest, err := parseArgs(fs, args, 1, 1, "users add [-json] <email>")
+	if err != nil {
+		return err
+	}
+	email := rest[0]
+
+	id, err := env.stores.Users.Create(env.ctx, email)
+	if err != nil {
+		return err
+	}
+
+	env.record(models.AuditEntry{
+		Action:    audit.ActionUserCreate,
+		UserID:    id,
+		UserEmail: email,
+		After:     email,
+	})
+
+	if *asJSON {
+		return printJSON(env.stdout, models.User{PowerBIUserID: id, PowerBIUser: email})
+	}
+	fmt.Fprintf(env.stdout, "Created user %d (%s)\n", id, email)
+	return nil
+}
+
+func usersRename(env *env, args []string) error {
+	fs := newFlagSet("users rename")
+	rest, err := parseArgs(fs, args, 2, 2, "users rename <id|email> <new-email>")
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(env, rest[0])
+	if err != nil {
+		return err
+	}
+	email := rest[1]
+
+	if err := env.stores.Users.Update(env.ctx, user.PowerBIUserID, email); err != nil {
+		return err
+	}
+
+	env.record(models.AuditEntry{
+		Action:    audit.ActionUserUpdate,
+		UserID:    user.PowerBIUserID,
+		UserEmail: email,
+		Before:    user.PowerBIUser,
+		After:     email,
+	})
+
+	fmt.Fprintf(env.stdout, "Renamed user %d from %s to %s\n", user.PowerBIUserID, user.PowerBIUser, email)
+	return nil
+}
+
+func usersDelete(env *env, args []string) error {
+	fs := newFlagSet("users delete")
+	rest, err := parseArgs(fs, args, 1, 1, "users delete <id|email>")
+	if err != nil {
+		return err
+	}
+
+	user, err := findUser(env, rest[0])
+	if err != nil {
+		return err
+	}
+
+	accessList, err := env.stores.Access.ListByUser(env.ctx, user.PowerBIUserID, true)
+	if err != nil {
+		return err
+	}
+
+	accessRemoved, err := env.stores.Users.Delete(env.ctx, user.PowerBIUserID)
+	if err != nil {
+		return err
+	}
+
+	env.record(audit.UserDeleteEntries(*user, accessList, accessRemoved)...)
+
+	fmt.Fprintf(env.stdout, "Deleted user %d (%s) and %d access records\n", user.PowerBIUserID, user.PowerBIUser, accessRemoved)
+	return nil
+}
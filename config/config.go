@@ -15,12 +15,22 @@ type Config struct {
 	Database string `json:"database"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// AuditStore selects where the audit log is kept: "sql" or "file"
+	AuditStore  string `json:"auditStore,omitempty"`
+	AuditSchema string `json:"auditSchema,omitempty"`
+	AuditFile   string `json:"auditFile,omitempty"`
+
+	// AdminsFile holds the admin accounts allowed to log in to the web application
+	AdminsFile string `json:"adminsFile,omitempty"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Server:   "tascon.database.windows.net",
-		Database: "dwh",
+		Server:      "tascon.database.windows.net",
+		Database:    "dwh",
+		AuditStore:  "sql",
+		AuditSchema: "powerbi",
 	}
 }
 
@@ -52,6 +62,14 @@ func Load() (*Config, error) {
 	if stored.Database != "" {
 		cfg.Database = stored.Database
 	}
+	if stored.AuditStore != "" {
+		cfg.AuditStore = stored.AuditStore
+	}
+	if stored.AuditSchema != "" {
+		cfg.AuditSchema = stored.AuditSchema
+	}
+	cfg.AuditFile = stored.AuditFile
+	cfg.AdminsFile = stored.AdminsFile
 
 	// Decrypt sensitive fields if master key is available
 	key, hasKey := GetMasterKey()
@@ -100,8 +118,12 @@ func (c *Config) Save() error {
 
 	// Prepare stored config with encrypted fields
 	stored := Config{
-		Server:   c.Server,
-		Database: c.Database,
+		Server:      c.Server,
+		Database:    c.Database,
+		AuditStore:  c.AuditStore,
+		AuditSchema: c.AuditSchema,
+		AuditFile:   c.AuditFile,
+		AdminsFile:  c.AdminsFile,
 	}
 
 	// Encrypt sensitive fields if master key is available
@@ -139,6 +161,32 @@ func (c *Config) Save() error {
 	return nil
 }
 
+// AuditFilePath returns the JSON-lines audit file, defaulting to the config directory
+func (c *Config) AuditFilePath() (string, error) {
+	if c.AuditFile != "" {
+		return c.AuditFile, nil
+	}
+
+	configPath, err := getConfigPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config path: %w", err)
+	}
+	return filepath.Join(filepath.Dir(configPath), "audit.jsonl"), nil
+}
+
+// AdminsFilePath returns the admin accounts file, defaulting to the config directory
+func (c *Config) AdminsFilePath() (string, error) {
+	if c.AdminsFile != "" {
+		return c.AdminsFile, nil
+	}
+
+	configPath, err := getConfigPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config path: %w", err)
+	}
+	return filepath.Join(filepath.Dir(configPath), "admins.json"), nil
+}
+
 func getConfigPath() (string, error) {
 	configDir, err := os.UserConfigDir()
 	if err != nil {
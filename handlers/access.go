@@ -4,18 +4,29 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
 )
 
+// AddAccessRequest grants GroupBkeys until ValidUntil (permanent when empty)
+// plus any individually specified Grants
 type AddAccessRequest struct {
-	GroupBkeys []int `json:"groupBkeys"`
+	GroupBkeys []int          `json:"groupBkeys"`
+	ValidUntil *time.Time     `json:"validUntil,omitempty"`
+	Grants     []models.Grant `json:"grants,omitempty"`
 }
 
+// ListUserAccess lists a user's groups; admins limited to Level2 units only
+// see the groups below them
 func (h *Handler) ListUserAccess(w http.ResponseWriter, r *http.Request) {
 	h.mu.RLock()
 	accessRepo := h.accessRepo
+	groupRepo := h.groupRepo
 	h.mu.RUnlock()
 
-	if accessRepo == nil {
+	if accessRepo == nil || groupRepo == nil {
 		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
 		return
 	}
@@ -27,22 +38,47 @@ func (h *Handler) ListUserAccess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessList, err := accessRepo.ListByUser(r.Context(), userID)
+	includeOrphaned := r.URL.Query().Get("orphaned") == "1"
+
+	accessList, err := accessRepo.ListByUser(r.Context(), userID, includeOrphaned)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if scope := ScopeFromContext(r.Context()); len(scope) > 0 {
+		groupBkeys := make([]int, len(accessList))
+		for i, a := range accessList {
+			groupBkeys[i] = a.GroupBkey
+		}
+		inScope, err := groupsInScope(r.Context(), groupRepo, scope, groupBkeys)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		visible := make([]models.UserAccess, 0, len(accessList))
+		for _, a := range accessList {
+			if inScope[a.GroupBkey] {
+				visible = append(visible, a)
+			}
+		}
+		accessList = visible
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(accessList)
 }
 
+// AddUserAccess grants groups to a user. Admins limited to Level2 units can
+// only grant groups below them.
 func (h *Handler) AddUserAccess(w http.ResponseWriter, r *http.Request) {
 	h.mu.RLock()
 	accessRepo := h.accessRepo
+	groupRepo := h.groupRepo
 	h.mu.RUnlock()
 
-	if accessRepo == nil {
+	if accessRepo == nil || groupRepo == nil {
 		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
 		return
 	}
@@ -60,40 +96,151 @@ func (h *Handler) AddUserAccess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(req.GroupBkeys) == 0 {
+	grants := req.Grants
+	for _, groupBkey := range req.GroupBkeys {
+		grants = append(grants, models.Grant{GroupBkey: groupBkey, ValidUntil: req.ValidUntil})
+	}
+
+	if len(grants) == 0 {
 		http.Error(w, "At least one group is required", http.StatusBadRequest)
 		return
 	}
 
-	// Filter out groups the user already has access to
-	var newGroupBkeys []int
-	for _, groupBkey := range req.GroupBkeys {
-		exists, err := accessRepo.Exists(r.Context(), userID, groupBkey)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	now := time.Now()
+	for _, g := range grants {
+		if g.ValidUntil != nil && !g.ValidUntil.After(now) {
+			http.Error(w, "Valid until must be in the future", http.StatusBadRequest)
 			return
 		}
-		if !exists {
-			newGroupBkeys = append(newGroupBkeys, groupBkey)
-		}
 	}
 
-	if len(newGroupBkeys) > 0 {
-		if err := accessRepo.AddGroups(r.Context(), userID, newGroupBkeys); err != nil {
+	if scope := ScopeFromContext(r.Context()); len(scope) > 0 {
+		groupBkeys := make([]int, len(grants))
+		for i, g := range grants {
+			groupBkeys[i] = g.GroupBkey
+		}
+		inScope, err := groupsInScope(r.Context(), groupRepo, scope, groupBkeys)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+
+		var outside []int
+		for _, groupBkey := range groupBkeys {
+			if !inScope[groupBkey] {
+				outside = append(outside, groupBkey)
+			}
+		}
+		if len(outside) > 0 {
+			http.Error(w, outOfScopeError(outside), http.StatusForbidden)
+			return
+		}
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	result, err := accessRepo.GrantWithExpiry(r.Context(), userID, grants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.auditGrants(r, userID, result.Added)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GrantSubtreeRequest names an organisational unit; both names narrow a Level3
+// unit down to one Level2 unit
+type GrantSubtreeRequest struct {
+	Level2Name string     `json:"level2Name"`
+	Level3Name string     `json:"level3Name"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
 }
 
+type GrantSubtreeResponse struct {
+	*models.GrantResult
+	GroupCount    int `json:"groupCount"`
+	AddedCount    int `json:"addedCount"`
+	ExistingCount int `json:"existingCount"`
+}
+
+// GrantSubtree grants every group below a Level2 or Level3 unit in one step
+func (h *Handler) GrantSubtree(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	accessRepo := h.accessRepo
+	groupRepo := h.groupRepo
+	h.mu.RUnlock()
+
+	if accessRepo == nil || groupRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	idStr := r.PathValue("id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req GrantSubtreeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Level2Name == "" && req.Level3Name == "" {
+		http.Error(w, "A Level2 or Level3 name is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.ValidUntil != nil && !req.ValidUntil.After(time.Now()) {
+		http.Error(w, "Valid until must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	groupBkeys, err := groupRepo.GroupsUnder(r.Context(), req.Level2Name, req.Level3Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(groupBkeys) == 0 {
+		http.Error(w, "No groups found for this unit", http.StatusNotFound)
+		return
+	}
+
+	grants := models.PermanentGrants(groupBkeys)
+	for i := range grants {
+		grants[i].ValidUntil = req.ValidUntil
+	}
+
+	result, err := accessRepo.GrantWithExpiry(r.Context(), userID, grants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.auditGrants(r, userID, result.Added)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GrantSubtreeResponse{
+		GrantResult:   result,
+		GroupCount:    len(groupBkeys),
+		AddedCount:    len(result.Added),
+		ExistingCount: len(result.Skipped),
+	})
+}
+
+// RemoveAccess revokes one access record. Admins limited to Level2 units can
+// only revoke groups below them.
 func (h *Handler) RemoveAccess(w http.ResponseWriter, r *http.Request) {
 	h.mu.RLock()
 	accessRepo := h.accessRepo
+	groupRepo := h.groupRepo
 	h.mu.RUnlock()
 
-	if accessRepo == nil {
+	if accessRepo == nil || groupRepo == nil {
 		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
 		return
 	}
@@ -105,10 +252,91 @@ func (h *Handler) RemoveAccess(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	access, err := accessRepo.GetByID(r.Context(), accessID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if access == nil {
+		http.Error(w, "access record not found", http.StatusNotFound)
+		return
+	}
+
+	if scope := ScopeFromContext(r.Context()); len(scope) > 0 {
+		inScope, err := groupsInScope(r.Context(), groupRepo, scope, []int{access.GroupBkey})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !inScope[access.GroupBkey] {
+			http.Error(w, outOfScopeError([]int{access.GroupBkey}), http.StatusForbidden)
+			return
+		}
+	}
+
 	if err := accessRepo.Remove(r.Context(), accessID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	h.recordAudit(r, models.AuditEntry{
+		Action:    audit.ActionAccessRevoke,
+		UserID:    access.UserID,
+		UserEmail: h.userEmail(r, access.UserID),
+		GroupBkey: access.GroupBkey,
+		GroupName: access.GroupName,
+		Before:    audit.GrantDescription(access.ValidUntil),
+	})
+
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// auditGrants records one audit entry per newly granted group
+func (h *Handler) auditGrants(r *http.Request, userID int, groupBkeys []int) {
+	if len(groupBkeys) == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	accessRepo := h.accessRepo
+	h.mu.RUnlock()
+
+	// One query for all group names instead of a lookup per group
+	granted := make(map[int]models.UserAccess)
+	if accessList, err := accessRepo.ListByUser(r.Context(), userID, false); err == nil {
+		for _, a := range accessList {
+			granted[a.GroupBkey] = a
+		}
+	}
+
+	email := h.userEmail(r, userID)
+	entries := make([]models.AuditEntry, 0, len(groupBkeys))
+	for _, groupBkey := range groupBkeys {
+		access := granted[groupBkey]
+		entries = append(entries, models.AuditEntry{
+			Action:    audit.ActionAccessGrant,
+			UserID:    userID,
+			UserEmail: email,
+			GroupBkey: groupBkey,
+			GroupName: access.GroupName,
+			After:     audit.GrantDescription(access.ValidUntil),
+		})
+	}
+	h.recordAudit(r, entries...)
+}
+
+// userEmail looks up a user's email for audit entries, returning "" when unknown
+func (h *Handler) userEmail(r *http.Request, userID int) string {
+	h.mu.RLock()
+	userRepo := h.userRepo
+	h.mu.RUnlock()
+
+	if userRepo == nil {
+		return ""
+	}
+	user, err := userRepo.GetByID(r.Context(), userID)
+	if err != nil || user == nil {
+		return ""
+	}
+	return user.PowerBIUser
+}
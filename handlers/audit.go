@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+)
+
+const defaultAuditLimit = 500
+
+// recordAudit stamps the entries with the request's actor and client address and stores them
+func (h *Handler) recordAudit(r *http.Request, entries ...models.AuditEntry) {
+	h.writeAudit(r.Context(), requestActor(r), h.clientIP(r), entries...)
+}
+
+// writeAudit stores the entries under the given actor. Failures are logged;
+// the change itself has already been made.
+func (h *Handler) writeAudit(ctx context.Context, actor string, ip string, entries ...models.AuditEntry) {
+	h.mu.RLock()
+	auditLog := h.auditLog
+	h.mu.RUnlock()
+
+	if auditLog == nil || len(entries) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	for i := range entries {
+		entries[i].Timestamp = now
+		entries[i].Actor = actor
+		entries[i].ClientIP = ip
+	}
+
+	if err := auditLog.Record(ctx, entries...); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// requestActor identifies who made the request: the logged-in admin, or
+// "anonymous" when authentication is disabled
+func requestActor(r *http.Request) string {
+	if admin, ok := AdminFromContext(r.Context()); ok {
+		return admin
+	}
+	return "anonymous"
+}
+
+// ParseTrustedProxies reads a comma-separated list of proxy addresses and
+// CIDR ranges, as in POWERBI_TRUSTED_PROXIES
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(field); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", field)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return proxies, nil
+}
+
+// TrustProxies lets X-Forwarded-For set the audited client address on requests
+// that come through one of the proxies
+func (h *Handler) TrustProxies(proxies []netip.Prefix) {
+	h.trustedProxies = proxies
+}
+
+func (h *Handler) isTrustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+	if err != nil {
+		return false
+	}
+	for _, prefix := range h.trustedProxies {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the caller's address. X-Forwarded-For is only read when the
+// connection comes from a trusted proxy; the hops are then followed from the
+// right up to the first address that is not a trusted proxy, as anything left
+// of that was written by the client.
+func (h *Handler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !h.isTrustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !h.isTrustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	auditLog := h.auditLog
+	h.mu.RUnlock()
+
+	if auditLog == nil {
+		http.Error(w, "Audit log not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := audit.Filter{
+		Actor: q.Get("actor"),
+		Limit: defaultAuditLimit,
+	}
+
+	var err error
+	if s := q.Get("userId"); s != "" {
+		if filter.UserID, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("groupBkey"); s != "" {
+		if filter.GroupBkey, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "Invalid group key", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("from"); s != "" {
+		if filter.From, err = parseAuditTime(s, false); err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		if filter.To, err = parseAuditTime(s, true); err != nil {
+			http.Error(w, "Invalid to date", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		if filter.Limit, err = strconv.Atoi(s); err != nil || filter.Limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := auditLog.List(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// parseAuditTime accepts RFC 3339 timestamps or plain dates. A plain "to" date
+// includes the whole day.
+func parseAuditTime(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/base64"
+	"log"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
 	"time"
+
+	"powerbi-access-tool/admin"
 )
 
 const (
@@ -16,47 +20,71 @@ const (
 	sessionDuration   = 8 * time.Hour
 )
 
+// How a session was signed in
+const (
+	sessionLocal = "local"
+	sessionOIDC  = "oidc"
+)
+
+// session is a logged-in browser; admin is empty when authentication is disabled.
+// OIDC sessions keep the role and Level2 scope granted at sign-in and the ID
+// token as a hint for signing out at the issuer. Local accounts are looked up
+// on every request.
+// The CSRF token must accompany the session's state-changing requests.
+type session struct {
+	admin     string
+	source    string
+	role      admin.Role
+	scope     []string
+	idToken   string
+	csrfToken string
+	expiry    time.Time
+}
+
 type sessionStore struct {
 	mu       sync.RWMutex
-	sessions map[string]time.Time
+	sessions map[string]session
 }
 
 var sessions = &sessionStore{
-	sessions: make(map[string]time.Time),
+	sessions: make(map[string]session),
 }
 
-func (s *sessionStore) create() (string, error) {
-	token := make([]byte, 32)
-	if _, err := rand.Read(token); err != nil {
+func (s *sessionStore) create(sess session) (string, error) {
+	tokenStr, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	sess.csrfToken, err = randomToken()
+	if err != nil {
 		return "", err
 	}
-
-	tokenStr := base64.URLEncoding.EncodeToString(token)
 
 	s.mu.Lock()
-	s.sessions[tokenStr] = time.Now().Add(sessionDuration)
+	sess.expiry = time.Now().Add(sessionDuration)
+	s.sessions[tokenStr] = sess
 	s.mu.Unlock()
 
 	return tokenStr, nil
 }
 
-func (s *sessionStore) valid(token string) bool {
+func (s *sessionStore) get(token string) (session, bool) {
 	s.mu.RLock()
-	expiry, exists := s.sessions[token]
+	sess, exists := s.sessions[token]
 	s.mu.RUnlock()
 
 	if !exists {
-		return false
+		return session{}, false
 	}
 
-	if time.Now().After(expiry) {
+	if time.Now().After(sess.expiry) {
 		s.mu.Lock()
 		delete(s.sessions, token)
 		s.mu.Unlock()
-		return false
+		return session{}, false
 	}
 
-	return true
+	return sess, true
 }
 
 func (s *sessionStore) delete(token string) {
@@ -65,26 +93,142 @@ func (s *sessionStore) delete(token string) {
 	s.mu.Unlock()
 }
 
-// GetAdminPassword retrieves the admin password from environment
+func randomToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(token), nil
+}
+
+// principal is the admin making a request and what they may do. The name is
+// empty when authentication is disabled; an empty scope covers all Level2 units.
+// Local accounts are scoped in the accounts file and OIDC sessions through
+// POWERBI_OIDC_LEVEL2_SCOPES. The POWERBI_ADMIN_PASSWORD admin, only usable
+// while no accounts exist, always sees everything.
+type principal struct {
+	name  string
+	role  admin.Role
+	scope []string
+}
+
+type contextKey int
+
+const principalContextKey contextKey = iota
+
+// AdminFromContext returns the name of the logged-in admin making the request
+func AdminFromContext(ctx context.Context) (string, bool) {
+	p, ok := ctx.Value(principalContextKey).(principal)
+	return p.name, ok && p.name != ""
+}
+
+// ScopeFromContext returns the Level2 units the admin making the request is
+// limited to, or nil when they may see everything
+func ScopeFromContext(ctx context.Context) []string {
+	p, _ := ctx.Value(principalContextKey).(principal)
+	return p.scope
+}
+
+// RoleFromContext returns the role of the admin making the request. Requests
+// that did not pass AuthMiddleware only get the viewer role.
+func RoleFromContext(ctx context.Context) admin.Role {
+	if p, ok := ctx.Value(principalContextKey).(principal); ok {
+		return p.role
+	}
+	return admin.RoleViewer
+}
+
+// legacyAdminName is the account the POWERBI_ADMIN_PASSWORD login acts as
+const legacyAdminName = "admin"
+
+// GetAdminPassword retrieves the admin password from environment. It is only
+// used while no admin accounts exist.
 func GetAdminPassword() (string, bool) {
 	pw := os.Getenv("POWERBI_ADMIN_PASSWORD")
 	return pw, pw != ""
 }
 
+// hasAdminAccounts reports whether logins are checked against the accounts file.
+// An unreadable file counts as having accounts so authentication stays on.
+func (h *Handler) hasAdminAccounts() bool {
+	count, err := h.admins.Count()
+	if err != nil {
+		log.Printf("Failed to read admin accounts: %v", err)
+		return true
+	}
+	return count > 0
+}
+
+// authEnabled reports whether requests need a logged-in session
+func (h *Handler) authEnabled() bool {
+	if h.oidcProvider != nil || h.hasAdminAccounts() {
+		return true
+	}
+	_, hasPassword := GetAdminPassword()
+	return hasPassword
+}
+
+// sessionAdmin returns the admin logged in with the request's session cookie.
+// Sessions of removed accounts, and sessions of the built-in admin once accounts
+// exist, are no longer accepted. OIDC sessions were checked against the
+// allowlist when they were created.
+func (h *Handler) sessionAdmin(r *http.Request) (principal, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return principal{}, false
+	}
+	sess, ok := sessions.get(cookie.Value)
+	if !ok || sess.admin == "" {
+		return principal{}, false
+	}
+
+	if sess.source == sessionOIDC {
+		return principal{name: sess.admin, role: sess.role, scope: sess.scope}, h.oidcProvider != nil
+	}
+	if h.hasAdminAccounts() {
+		account, err := h.admins.Get(sess.admin)
+		if err != nil {
+			log.Printf("Failed to read admin accounts: %v", err)
+		}
+		if account == nil {
+			return principal{}, false
+		}
+		return principal{
+			name:  account.Username,
+			role:  account.EffectiveRole(),
+			scope: account.Level2Scope,
+		}, true
+	}
+
+	// The built-in admin of POWERBI_ADMIN_PASSWORD has full access
+	return principal{name: sess.admin, role: admin.RoleAdministrator}, sess.admin == legacyAdminName
+}
+
 // LoginPage displays the login page
 func (h *Handler) LoginPage(w http.ResponseWriter, r *http.Request) {
 	// If already logged in, redirect to home
-	if cookie, err := r.Cookie(sessionCookieName); err == nil {
-		if sessions.valid(cookie.Value) {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
-		}
+	if _, ok := h.sessionAdmin(r); ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
+	// The password form is only shown when there is something to log in with
+	_, hasPassword := GetAdminPassword()
+	token, err := csrfToken(w, r)
+	if err != nil {
+		http.Error(w, "Failed to create CSRF token", http.StatusInternalServerError)
+		return
+	}
 	data := struct {
-		Error string
+		Error     string
+		OIDC      bool
+		Local     bool
+		CSRFToken string
 	}{
-		Error: r.URL.Query().Get("error"),
+		Error:     r.URL.Query().Get("error"),
+		OIDC:      h.oidcProvider != nil,
+		Local:     h.oidcProvider == nil || hasPassword || h.hasAdminAccounts(),
+		CSRFToken: token,
 	}
 
 	if err := h.templates.ExecuteTemplate(w, "login.html", data); err != nil {
@@ -99,26 +243,40 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	username := r.FormValue("username")
 	password := r.FormValue("password")
-	adminPassword, hasPassword := GetAdminPassword()
 
-	// If no admin password configured, allow login
-	if !hasPassword {
-		h.createSessionAndRedirect(w, r)
+	// If no admin accounts or password configured, allow login
+	if !h.authEnabled() {
+		h.createSessionAndRedirect(w, r, session{source: sessionLocal})
 		return
 	}
 
-	// Constant-time comparison to prevent timing attacks
-	if subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) != 1 {
+	if h.hasAdminAccounts() {
+		name, ok := h.admins.Authenticate(username, password)
+		if !ok {
+			http.Redirect(w, r, "/login?error=invalid", http.StatusSeeOther)
+			return
+		}
+		h.createSessionAndRedirect(w, r, session{admin: name, source: sessionLocal})
+		return
+	}
+
+	// Without accounts the environment password logs in as the built-in admin.
+	// Constant-time comparison to prevent timing attacks.
+	adminPassword, hasPassword := GetAdminPassword()
+	if !hasPassword ||
+		!strings.EqualFold(strings.TrimSpace(username), legacyAdminName) ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) != 1 {
 		http.Redirect(w, r, "/login?error=invalid", http.StatusSeeOther)
 		return
 	}
 
-	h.createSessionAndRedirect(w, r)
+	h.createSessionAndRedirect(w, r, session{admin: legacyAdminName, source: sessionLocal})
 }
 
-func (h *Handler) createSessionAndRedirect(w http.ResponseWriter, r *http.Request) {
-	token, err := sessions.create()
+func (h *Handler) createSessionAndRedirect(w http.ResponseWriter, r *http.Request, sess session) {
+	token, err := sessions.create(sess)
 	if err != nil {
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
@@ -137,9 +295,12 @@ func (h *Handler) createSessionAndRedirect(w http.ResponseWriter, r *http.Reques
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// Logout logs the user out
+// Logout logs the user out. OIDC sessions are also ended at the issuer, which
+// sends the browser back to the login page.
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	var sess session
 	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		sess, _ = sessions.get(cookie.Value)
 		sessions.delete(cookie.Value)
 	}
 
@@ -151,33 +312,59 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   -1,
 	})
 
+	if sess.source == sessionOIDC && h.oidcProvider != nil {
+		if logoutURL := h.oidcProvider.LogoutURL(r.Context(), sess.idToken); logoutURL != "" {
+			http.Redirect(w, r, logoutURL, http.StatusSeeOther)
+			return
+		}
+	}
+
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
-// AuthMiddleware protects routes that require authentication
-func AuthMiddleware(next http.Handler) http.Handler {
+// requireRole returns a wrapper that refuses requests from admins whose role
+// does not include the given role
+func requireRole(role admin.Role) func(http.HandlerFunc) http.Handler {
+	return func(next http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !RoleFromContext(r.Context()).Includes(role) {
+				http.Error(w, "Forbidden: this requires the "+string(role)+" role", http.StatusForbidden)
+				return
+			}
+			next(w, r)
+		})
+	}
+}
+
+// AuthMiddleware protects routes that require authentication and puts the
+// logged-in admin in the request context
+func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip auth for login page and static files
+		// Skip auth for login pages and static files
 		if r.URL.Path == "/login" ||
 			r.URL.Path == "/logout" ||
+			r.URL.Path == "/login/oidc" ||
+			r.URL.Path == "/login/oidc/callback" ||
 			strings.HasPrefix(r.URL.Path, "/static/") {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// If no admin password configured, skip auth
-		if _, hasPassword := GetAdminPassword(); !hasPassword {
-			next.ServeHTTP(w, r)
+		// If no admin accounts or password configured, skip auth; everyone
+		// has full access then
+		if !h.authEnabled() {
+			p := principal{role: admin.RoleAdministrator}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, p)))
 			return
 		}
 
 		// Check session cookie
-		cookie, err := r.Cookie(sessionCookieName)
-		if err != nil || !sessions.valid(cookie.Value) {
+		p, ok := h.sessionAdmin(r)
+		if !ok {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey, p)))
 	})
 }
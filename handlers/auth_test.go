@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"powerbi-access-tool/admin"
+)
+
+func TestLoginChecksPassword(t *testing.T) {
+	s := newTestServer(t)
+	s.addAdmin(t, "vera", admin.RoleViewer)
+	c := s.newClient(t)
+
+	c.get("/login")
+	form := url.Values{"csrf_token": {c.csrf}, "username": {"vera"}, "password": {"wrong password!"}}
+	status, location := c.do(http.MethodPost, "/login", "application/x-www-form-urlencoded", form.Encode())
+	if status != http.StatusSeeOther || location != "/login?error=invalid" {
+		t.Fatalf("wrong password: %d %s, want /login?error=invalid", status, location)
+	}
+	if status, location := c.get("/api/users"); status != http.StatusSeeOther || location != "/login" {
+		t.Fatalf("GET /api/users without session: %d %s, want redirect to /login", status, location)
+	}
+
+	c.login("Vera")
+	if status, _ := c.get("/api/users"); status != http.StatusOK {
+		t.Fatalf("GET /api/users after login: %d", status)
+	}
+}
+
+func TestRemovedAccountLosesSession(t *testing.T) {
+	s := newTestServer(t)
+	s.addAdmin(t, "vera", admin.RoleViewer)
+	s.addAdmin(t, "otto", admin.RoleAdministrator)
+	c := s.newClient(t)
+	c.login("vera")
+
+	if err := s.h.admins.Remove("vera", false); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := c.get("/api/users"); status != http.StatusSeeOther {
+		t.Fatalf("GET /api/users after removal: %d, want redirect to login", status)
+	}
+}
+
+func TestRoutesRequireRole(t *testing.T) {
+	s := newTestServer(t)
+	s.addAdmin(t, "vera", admin.RoleViewer)
+	s.addAdmin(t, "eddy", admin.RoleEditor)
+	s.addAdmin(t, "ada", admin.RoleAdministrator)
+
+	type request struct {
+		method, path, body string
+	}
+	readUsers := request{http.MethodGet, "/api/users", ""}
+	grant := request{http.MethodPost, "/api/users/1/access", `{"groupBkeys":[1001]}`}
+	settings := request{http.MethodGet, "/settings", ""}
+
+	tests := []struct {
+		admin string
+		req   request
+		want  int
+	}{
+		{"vera", readUsers, http.StatusOK},
+		{"vera", grant, http.StatusForbidden},
+		{"vera", settings, http.StatusForbidden},
+		{"eddy", readUsers, http.StatusOK},
+		{"eddy", grant, http.StatusOK},
+		{"eddy", settings, http.StatusForbidden},
+		{"ada", grant, http.StatusOK},
+		{"ada", settings, http.StatusOK},
+	}
+	for _, tt := range tests {
+		c := s.newClient(t)
+		c.login(tt.admin)
+
+		contentType := ""
+		if tt.req.body != "" {
+			contentType = "application/json"
+		}
+		if status, body := c.do(tt.req.method, tt.req.path, contentType, tt.req.body); status != tt.want {
+			t.Errorf("%s %s %s: %d %s, want %d", tt.admin, tt.req.method, tt.req.path, status, body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"powerbi-access-tool/models"
+)
+
+// CompareUsers returns the groups only user ?a has, only user ?b has and both
+// have, each with its Level2/Level3 units
+func (h *Handler) CompareUsers(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	userRepo := h.userRepo
+	accessRepo := h.accessRepo
+	groupRepo := h.groupRepo
+	h.mu.RUnlock()
+
+	if userRepo == nil || accessRepo == nil || groupRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	var users [2]models.User
+	var access [2][]models.UserAccess
+	for i, param := range []string{"a", "b"} {
+		id, err := strconv.Atoi(r.URL.Query().Get(param))
+		if err != nil {
+			http.Error(w, "Invalid user ID for "+param, http.StatusBadRequest)
+			return
+		}
+
+		user, err := userRepo.GetByID(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		users[i] = *user
+
+		access[i], err = accessRepo.ListByUser(r.Context(), id, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result := compareAccess(access[0], access[1])
+	result.A = users[0]
+	result.B = users[1]
+
+	var groupBkeys []int
+	for _, list := range [][]models.ComparedGroup{result.OnlyA, result.OnlyB, result.Both} {
+		for _, g := range list {
+			groupBkeys = append(groupBkeys, g.GroupBkey)
+		}
+	}
+	units, err := groupRepo.Units(r.Context(), groupBkeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, list := range [][]models.ComparedGroup{result.OnlyA, result.OnlyB, result.Both} {
+		for i := range list {
+			if u := units[list[i].GroupBkey]; u != nil {
+				list[i].Units = u
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// compareAccess splits two access lists by group, each part sorted by group name
+func compareAccess(a, b []models.UserAccess) *models.AccessComparison {
+	byGroupA := make(map[int]models.UserAccess, len(a))
+	for _, access := range a {
+		byGroupA[access.GroupBkey] = access
+	}
+	byGroupB := make(map[int]models.UserAccess, len(b))
+	for _, access := range b {
+		byGroupB[access.GroupBkey] = access
+	}
+
+	result := &models.AccessComparison{
+		OnlyA: []models.ComparedGroup{},
+		OnlyB: []models.ComparedGroup{},
+		Both:  []models.ComparedGroup{},
+	}
+	for groupBkey, accessA := range byGroupA {
+		g := models.ComparedGroup{
+			GroupBkey:   groupBkey,
+			GroupName:   accessA.GroupName,
+			Units:       []models.OrgUnit{},
+			ValidUntilA: accessA.ValidUntil,
+		}
+		if accessB, ok := byGroupB[groupBkey]; ok {
+			g.ValidUntilB = accessB.ValidUntil
+			result.Both = append(result.Both, g)
+		} else {
+			result.OnlyA = append(result.OnlyA, g)
+		}
+	}
+	for groupBkey, accessB := range byGroupB {
+		if _, ok := byGroupA[groupBkey]; ok {
+			continue
+		}
+		result.OnlyB = append(result.OnlyB, models.ComparedGroup{
+			GroupBkey:   groupBkey,
+			GroupName:   accessB.GroupName,
+			Units:       []models.OrgUnit{},
+			ValidUntilB: accessB.ValidUntil,
+		})
+	}
+
+	for _, list := range [][]models.ComparedGroup{result.OnlyA, result.OnlyB, result.Both} {
+		sort.Slice(list, func(i, j int) bool {
+			x, y := strings.ToLower(list[i].GroupName), strings.ToLower(list[j].GroupName)
+			if x != y {
+				return x < y
+			}
+			return list[i].GroupBkey < list[j].GroupBkey
+		})
+	}
+	return result
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"powerbi-access-tool/models"
+)
+
+// comparedGroups formats compared groups in list order, marking time-limited
+// access of user A or B with a or b
+func comparedGroups(groups []models.ComparedGroup) string {
+	parts := make([]string, len(groups))
+	for i, g := range groups {
+		parts[i] = fmt.Sprint(g.GroupBkey)
+		if g.ValidUntilA != nil {
+			parts[i] += "a"
+		}
+		if g.ValidUntilB != nil {
+			parts[i] += "b"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestCompareAccess(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	group := func(groupBkey int, name string) models.UserAccess {
+		return models.UserAccess{GroupBkey: groupBkey, GroupName: name}
+	}
+	limited := func(a models.UserAccess) models.UserAccess {
+		a.ValidUntil = &until
+		return a
+	}
+
+	tests := []struct {
+		name               string
+		a, b               []models.UserAccess
+		onlyA, onlyB, both string
+	}{
+		{
+			name: "no access",
+		},
+		{
+			name:  "disjoint",
+			a:     []models.UserAccess{group(1001, "Noord Verkoop")},
+			b:     []models.UserAccess{group(1003, "Zuid Verkoop")},
+			onlyA: "1001",
+			onlyB: "1003",
+		},
+		{
+			name:  "sorted by name without case, then key",
+			a:     []models.UserAccess{group(1003, "zuid"), group(1002, "Noord"), group(1001, "noord")},
+			onlyA: "1001 1002 1003",
+		},
+		{
+			name:  "shared groups keep both expiries",
+			a:     []models.UserAccess{limited(group(1001, "Noord")), group(1002, "Noord Inkoop")},
+			b:     []models.UserAccess{group(1001, "Noord"), limited(group(1002, "Noord Inkoop")), group(1005, "Holding")},
+			onlyB: "1005",
+			both:  "1001a 1002b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := compareAccess(tt.a, tt.b)
+			if got := comparedGroups(result.OnlyA); got != tt.onlyA {
+				t.Errorf("only A %q, want %q", got, tt.onlyA)
+			}
+			if got := comparedGroups(result.OnlyB); got != tt.onlyB {
+				t.Errorf("only B %q, want %q", got, tt.onlyB)
+			}
+			if got := comparedGroups(result.Both); got != tt.both {
+				t.Errorf("both %q, want %q", got, tt.both)
+			}
+		})
+	}
+}
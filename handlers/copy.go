@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+)
+
+// CopyAccess gives user {id} the groups of user {sourceId}, keeping their
+// expiry. With ?mode=replace the target's other groups are revoked as well;
+// ?dryRun=1 returns the difference without changing anything. The grants and
+// revokes are applied as one plan, so a failure leaves the target unchanged.
+func (h *Handler) CopyAccess(w http.ResponseWriter, r *http.Request) {
+	stores := h.currentStores()
+	userRepo, accessRepo := stores.Users, stores.Access
+	if userRepo == nil || accessRepo == nil || stores.Plans == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	sourceID, err := strconv.Atoi(r.PathValue("sourceId"))
+	if err != nil {
+		http.Error(w, "Invalid source user ID", http.StatusBadRequest)
+		return
+	}
+	if sourceID == targetID {
+		http.Error(w, "Source and target user must differ", http.StatusBadRequest)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = models.CopyMerge
+	}
+	if mode != models.CopyMerge && mode != models.CopyReplace {
+		http.Error(w, "Invalid mode: use merge or replace", http.StatusBadRequest)
+		return
+	}
+
+	for _, id := range []int{targetID, sourceID} {
+		user, err := userRepo.GetByID(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	sourceAccess, err := accessRepo.ListByUser(r.Context(), sourceID, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	targetAccess, err := accessRepo.ListByUser(r.Context(), targetID, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	diff := diffAccess(sourceAccess, targetAccess, mode)
+	diff.SourceID = sourceID
+	diff.TargetID = targetID
+	diff.DryRun = r.URL.Query().Get("dryRun") == "1"
+
+	if !diff.DryRun && len(diff.Add)+len(diff.Remove) > 0 {
+		email := h.userEmail(r, targetID)
+
+		plan := &models.Plan{}
+		for _, a := range diff.Add {
+			plan.Changes = append(plan.Changes, models.PlanChange{
+				Action:     models.PlanGrant,
+				UserID:     targetID,
+				Email:      email,
+				GroupBkey:  a.GroupBkey,
+				GroupName:  a.GroupName,
+				ValidUntil: a.ValidUntil,
+			})
+		}
+		for _, a := range diff.Remove {
+			plan.Changes = append(plan.Changes, models.PlanChange{
+				Action:    models.PlanRevoke,
+				UserID:    targetID,
+				Email:     email,
+				AccessID:  a.UserAccessID,
+				GroupBkey: a.GroupBkey,
+				GroupName: a.GroupName,
+			})
+		}
+
+		if _, err := stores.Plans.Apply(r.Context(), plan); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]models.AuditEntry, 0, len(diff.Add)+len(diff.Remove))
+		for _, a := range diff.Add {
+			entries = append(entries, models.AuditEntry{
+				Action:    audit.ActionAccessGrant,
+				UserID:    targetID,
+				UserEmail: email,
+				GroupBkey: a.GroupBkey,
+				GroupName: a.GroupName,
+				After:     audit.GrantDescription(a.ValidUntil),
+			})
+		}
+		for _, a := range diff.Remove {
+			entries = append(entries, models.AuditEntry{
+				Action:    audit.ActionAccessRevoke,
+				UserID:    targetID,
+				UserEmail: email,
+				GroupBkey: a.GroupBkey,
+				GroupName: a.GroupName,
+				Before:    audit.GrantDescription(a.ValidUntil),
+			})
+		}
+		h.recordAudit(r, entries...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// diffAccess works out which of the source's groups the target lacks and, in
+// replace mode, which of the target's groups the source does not have
+func diffAccess(source, target []models.UserAccess, mode string) *models.AccessCopy {
+	diff := &models.AccessCopy{
+		Mode:      mode,
+		Add:       []models.UserAccess{},
+		Remove:    []models.UserAccess{},
+		Unchanged: []models.UserAccess{},
+	}
+
+	inSource := make(map[int]bool, len(source))
+	for _, a := range source {
+		inSource[a.GroupBkey] = true
+	}
+	inTarget := make(map[int]bool, len(target))
+	for _, a := range target {
+		switch {
+		case inSource[a.GroupBkey]:
+			if !inTarget[a.GroupBkey] {
+				diff.Unchanged = append(diff.Unchanged, a)
+			}
+		case mode == models.CopyReplace:
+			diff.Remove = append(diff.Remove, a)
+		}
+		inTarget[a.GroupBkey] = true
+	}
+
+	added := make(map[int]bool)
+	for _, a := range source {
+		if inTarget[a.GroupBkey] || added[a.GroupBkey] {
+			continue
+		}
+		added[a.GroupBkey] = true
+		diff.Add = append(diff.Add, a)
+	}
+
+	return diff
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/models"
+)
+
+// groupKeys formats the groups of access records in list order
+func groupKeys(accessList []models.UserAccess) string {
+	parts := make([]string, len(accessList))
+	for i, a := range accessList {
+		parts[i] = fmt.Sprint(a.GroupBkey)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestDiffAccess(t *testing.T) {
+	records := func(groupBkeys ...int) []models.UserAccess {
+		var accessList []models.UserAccess
+		for _, g := range groupBkeys {
+			accessList = append(accessList, models.UserAccess{GroupBkey: g})
+		}
+		return accessList
+	}
+
+	tests := []struct {
+		name                   string
+		source, target         []models.UserAccess
+		mode                   string
+		add, remove, unchanged string
+	}{
+		{
+			name:   "merge into an empty user",
+			source: records(1001, 1002),
+			mode:   models.CopyMerge,
+			add:    "1001 1002",
+		},
+		{
+			name:      "merge keeps the target's other groups",
+			source:    records(1001, 1002),
+			target:    records(1002, 1005),
+			mode:      models.CopyMerge,
+			add:       "1001",
+			unchanged: "1002",
+		},
+		{
+			name:      "replace removes the target's other groups",
+			source:    records(1001, 1002),
+			target:    records(1002, 1005),
+			mode:      models.CopyReplace,
+			add:       "1001",
+			remove:    "1005",
+			unchanged: "1002",
+		},
+		{
+			name:   "replace with an empty source removes everything",
+			target: records(1001, 1005),
+			mode:   models.CopyReplace,
+			remove: "1001 1005",
+		},
+		{
+			name:      "duplicate records count once",
+			source:    records(1001, 1001, 1003),
+			target:    records(1003, 1003),
+			mode:      models.CopyMerge,
+			add:       "1001",
+			unchanged: "1003",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := diffAccess(tt.source, tt.target, tt.mode)
+			if diff.Mode != tt.mode {
+				t.Errorf("mode %q, want %q", diff.Mode, tt.mode)
+			}
+			if got := groupKeys(diff.Add); got != tt.add {
+				t.Errorf("add %q, want %q", got, tt.add)
+			}
+			if got := groupKeys(diff.Remove); got != tt.remove {
+				t.Errorf("remove %q, want %q", got, tt.remove)
+			}
+			if got := groupKeys(diff.Unchanged); got != tt.unchanged {
+				t.Errorf("unchanged %q, want %q", got, tt.unchanged)
+			}
+		})
+	}
+}
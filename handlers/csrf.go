@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"mime"
+	"net/http"
+)
+
+const (
+	// csrfCookieName holds the token of browsers without a session, such as on
+	// the login page or when authentication is disabled
+	csrfCookieName = "powerbi_csrf"
+	// csrfHeaderName is how api() in app.js sends the token; forms use csrfFieldName
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFieldName  = "csrf_token"
+)
+
+// csrfToken returns the token pages must send back with state-changing
+// requests. Sessions carry their own token; browsers without one get a
+// cookie to submit twice, once as the cookie and once in the form or header.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if token, ok := expectedCSRFToken(r); ok {
+		return token, nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+// expectedCSRFToken returns the token issued to the browser making the request
+func expectedCSRFToken(r *http.Request) (string, bool) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if sess, ok := sessions.get(cookie.Value); ok {
+			return sess.csrfToken, true
+		}
+	}
+	if cookie, err := r.Cookie(csrfCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, true
+	}
+	return "", false
+}
+
+// validCSRFToken reports whether the request sent back the token issued to its
+// browser. Only URL-encoded forms may send it as a field: reading it from a
+// multipart upload would parse the whole body before the handler limits its size.
+func validCSRFToken(r *http.Request) bool {
+	expected, ok := expectedCSRFToken(r)
+	if !ok {
+		return false
+	}
+
+	sent := r.Header.Get(csrfHeaderName)
+	if sent == "" && isURLEncodedForm(r) {
+		sent = r.PostFormValue(csrfFieldName)
+	}
+	return sent != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) == 1
+}
+
+// isURLEncodedForm reports whether the request body is a URL-encoded form
+func isURLEncodedForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// CSRFMiddleware refuses POST, PUT and DELETE requests without the browser's
+// CSRF token. A failed login is sent back to the login page to try again.
+func (h *Handler) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !validCSRFToken(r) {
+			if r.URL.Path == "/login" {
+				http.Redirect(w, r, "/login?error=expired", http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "Forbidden: missing or invalid CSRF token", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
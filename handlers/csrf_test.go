@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"powerbi-access-tool/admin"
+)
+
+func TestCSRFTokenRequired(t *testing.T) {
+	s := newTestServer(t)
+	s.addAdmin(t, "eddy", admin.RoleEditor)
+	c := s.newClient(t)
+	c.login("eddy")
+	token := c.csrf
+
+	c.csrf = ""
+	if status, _ := c.postJSON("/api/users", `{"email":"nieuw@voorbeeld.nl"}`); status != http.StatusForbidden {
+		t.Errorf("POST without token: %d, want 403", status)
+	}
+	c.csrf = "not-the-token"
+	if status, _ := c.do(http.MethodDelete, "/api/users/2", "", ""); status != http.StatusForbidden {
+		t.Errorf("DELETE with a wrong token: %d, want 403", status)
+	}
+
+	// A token only works with the session it was issued to
+	other := s.newClient(t)
+	other.login("eddy")
+	other.csrf = token
+	if status, _ := other.postJSON("/api/users", `{"email":"nieuw@voorbeeld.nl"}`); status != http.StatusForbidden {
+		t.Errorf("POST with another session's token: %d, want 403", status)
+	}
+
+	c.csrf = token
+	if status, body := c.postJSON("/api/users", `{"email":"nieuw@voorbeeld.nl"}`); status != http.StatusCreated {
+		t.Errorf("POST with token: %d %s, want 201", status, body)
+	}
+}
+
+func TestCSRFTokenRequiredWithoutAuthentication(t *testing.T) {
+	s := newTestServer(t)
+	c := s.newClient(t)
+
+	// Without accounts the token comes from a cookie instead of a session
+	if status, _ := c.get("/"); status != http.StatusOK || c.csrf == "" {
+		t.Fatalf("index: %d without token", status)
+	}
+	token := c.csrf
+
+	c.csrf = ""
+	if status, _ := c.postJSON("/api/users", `{"email":"nieuw@voorbeeld.nl"}`); status != http.StatusForbidden {
+		t.Errorf("POST without token: %d, want 403", status)
+	}
+
+	// A browser without the cookie cannot borrow the token
+	other := s.newClient(t)
+	other.csrf = token
+	if status, _ := other.postJSON("/api/users", `{"email":"nieuw@voorbeeld.nl"}`); status != http.StatusForbidden {
+		t.Errorf("POST from another browser: %d, want 403", status)
+	}
+
+	c.csrf = token
+	if status, body := c.postJSON("/api/users", `{"email":"nieuw@voorbeeld.nl"}`); status != http.StatusCreated {
+		t.Errorf("POST with token: %d %s, want 201", status, body)
+	}
+}
+
+func TestLoginRequiresCSRFToken(t *testing.T) {
+	s := newTestServer(t)
+	s.addAdmin(t, "eddy", admin.RoleEditor)
+	c := s.newClient(t)
+	c.get("/login")
+
+	form := url.Values{"username": {"eddy"}, "password": {"correct horse battery"}}
+	status, location := c.do(http.MethodPost, "/login", "application/x-www-form-urlencoded", form.Encode())
+	if status != http.StatusSeeOther || location != "/login?error=expired" {
+		t.Fatalf("login without token: %d %s, want /login?error=expired", status, location)
+	}
+	if status, _ := c.get("/api/users"); status != http.StatusSeeOther {
+		t.Errorf("GET /api/users after refused login: %d, want redirect to login", status)
+	}
+}
+
+func TestMultipartUploadNeedsCSRFHeader(t *testing.T) {
+	s := newTestServer(t)
+	c := s.newClient(t)
+	c.get("/")
+	token := c.csrf
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField(csrfFieldName, token)
+	file, err := form.CreateFormFile("file", "import.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("email;group\nnieuw@voorbeeld.nl;1001\n"))
+	form.Close()
+
+	// The middleware does not parse an upload to find the field
+	c.csrf = ""
+	if status, _ := c.do(http.MethodPost, "/api/import?dryRun=1", form.FormDataContentType(), body.String()); status != http.StatusForbidden {
+		t.Errorf("upload with the token as a form field: %d, want 403", status)
+	}
+
+	c.csrf = token
+	if status, resp := c.do(http.MethodPost, "/api/import?dryRun=1", form.FormDataContentType(), body.String()); status != http.StatusOK {
+		t.Errorf("upload with the token header: %d %s, want 200", status, resp)
+	}
+}
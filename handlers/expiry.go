@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+)
+
+// RunExpirySweeper revokes expired access grants every interval until ctx is done
+func (h *Handler) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	h.sweepExpiredAccess(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.sweepExpiredAccess(ctx)
+		}
+	}
+}
+
+func (h *Handler) sweepExpiredAccess(ctx context.Context) {
+	h.mu.RLock()
+	accessRepo := h.accessRepo
+	h.mu.RUnlock()
+
+	if accessRepo == nil {
+		return
+	}
+
+	removed, err := accessRepo.RemoveExpired(ctx, time.Now())
+	if err != nil {
+		log.Printf("Failed to revoke expired access: %v", err)
+		return
+	}
+
+	entries := make([]models.AuditEntry, 0, len(removed))
+	for _, a := range removed {
+		log.Printf("Revoked expired access: user %d, group %d (%s), valid until %s",
+			a.UserID, a.GroupBkey, a.GroupName, a.ValidUntil.Format(time.RFC3339))
+		entries = append(entries, models.AuditEntry{
+			Action:    audit.ActionAccessExpire,
+			UserID:    a.UserID,
+			GroupBkey: a.GroupBkey,
+			GroupName: a.GroupName,
+			Before:    audit.GrantDescription(a.ValidUntil),
+		})
+	}
+	h.writeAudit(ctx, audit.ActorSystem, "", entries...)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+	"powerbi-access-tool/xlsx"
+)
+
+// noLevel2Sheet names the workbook sheet for groups without a Level2 unit
+const noLevel2Sheet = "(geen Level2)"
+
+var exportColumns = []string{"user_id", "email", "group_bkey", "group_name", "level2", "level3", "granted", "valid_until"}
+
+// ExportAccess streams the access matrix of the users matching the same filter
+// and sort parameters as ListUsers, as CSV (default) or as an XLSX workbook with
+// one sheet per Level2 unit (?format=xlsx)
+func (h *Handler) ExportAccess(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	accessRepo := h.accessRepo
+	h.mu.RUnlock()
+
+	if accessRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	opts := repository.AccessExportOptions{Users: userListOptions(r)}
+	filename := "toegang-" + time.Now().Format("20060102")
+
+	var err error
+	switch format {
+	case "csv":
+		sep := r.URL.Query().Get("sep")
+		if len([]rune(sep)) > 1 || sep == "\"" || sep == "\n" || sep == "\r" {
+			http.Error(w, "Invalid separator", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+
+		cw := csv.NewWriter(w)
+		if sep != "" {
+			cw.Comma = []rune(sep)[0]
+		}
+		cw.Write(exportColumns)
+		err = accessRepo.Export(r.Context(), opts, func(row models.AccessExportRow) error {
+			return cw.Write([]string{
+				strconv.Itoa(row.UserID),
+				csvText(row.Email),
+				strconv.Itoa(row.GroupBkey),
+				csvText(row.GroupName),
+				csvText(row.Level2Name),
+				csvText(row.Level3Name),
+				formatExportTime(&row.CreationDate),
+				formatExportTime(row.ValidUntil),
+			})
+		})
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+
+	case "xlsx":
+		opts.ByLevel2 = true
+
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.xlsx"`)
+
+		xw := xlsx.NewWriter(w)
+		started := false
+		level2 := ""
+		err = accessRepo.Export(r.Context(), opts, func(row models.AccessExportRow) error {
+			// The database compares Level2 names without regard to case, so
+			// "Regio Noord" and "regio noord" are one unit and one sheet
+			if !started || !strings.EqualFold(row.Level2Name, level2) {
+				started = true
+				level2 = row.Level2Name
+				name := level2
+				if name == "" {
+					name = noLevel2Sheet
+				}
+				if err := xw.AddSheet(name); err != nil {
+					return err
+				}
+				if err := xw.WriteHeader(exportColumns...); err != nil {
+					return err
+				}
+			}
+			return xw.WriteRow(row.UserID, row.Email, row.GroupBkey, row.GroupName,
+				row.Level2Name, row.Level3Name, row.CreationDate, row.ValidUntil)
+		})
+		// An export without rows still gets a sheet with the column titles
+		if err == nil && !started {
+			if err = xw.AddSheet("Toegang"); err == nil {
+				err = xw.WriteHeader(exportColumns...)
+			}
+		}
+		if err == nil {
+			err = xw.Close()
+		}
+
+	default:
+		http.Error(w, "Invalid format: use csv or xlsx", http.StatusBadRequest)
+		return
+	}
+
+	// The response has already started, so the status can no longer be changed
+	if err != nil {
+		log.Printf("Access export failed: %v", err)
+	}
+}
+
+// csvText keeps a spreadsheet opening the CSV from reading a value as a formula
+// by prefixing values that start with =, +, -, @, tab or carriage return with a
+// single quote. XLSX cells need no prefix: inline strings are never evaluated.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+// seedFormulas gives a user groups whose names a spreadsheet would read as
+// formulas, in a Level2 unit spelled differently from the demo data. Anna gets
+// access as well, so the export filter has a row to leave out.
+func seedFormulas(t *testing.T, s *testServer, c *testClient) {
+	t.Helper()
+	s.db.Seed(repository.MemorySeed{
+		Groups: []models.Group{
+			{GroupBkey: 1006, GroupName: `=HYPERLINK("http://example.com")`},
+			{GroupBkey: 1007, GroupName: "\tVerborgen"},
+		},
+		Objects: []models.Object{
+			{ObjectName: "Noord extern", Level1Name: "Tascon", Level2Name: "REGIO NOORD", Level3Name: "@extern", GroupBkey: 1006},
+			{ObjectName: "Noord verborgen", Level1Name: "Tascon", Level2Name: "regio noord", Level3Name: "-Extern", GroupBkey: 1007},
+		},
+		Users: []models.User{{PowerBIUserID: 10, PowerBIUser: "+31@voorbeeld.nl"}},
+	})
+	for path, body := range map[string]string{
+		"/api/users/10/access": `{"groupBkeys":[1006,1007]}`,
+		"/api/users/1/access":  `{"groupBkeys":[1001]}`,
+	} {
+		if status, resp := c.postJSON(path, body); status != http.StatusOK {
+			t.Fatalf("POST %s: %d %s", path, status, resp)
+		}
+	}
+}
+
+// exportedCells maps the group key of each exported row to its email, group,
+// Level2 and Level3 cells
+type exportedCells map[string][4]string
+
+func checkExportedCells(t *testing.T, got, want exportedCells) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("export has groups %q, want only the rows of +31@voorbeeld.nl", got)
+	}
+	for bkey, cells := range want {
+		if got[bkey] != cells {
+			t.Errorf("group %s: cells %q, want %q", bkey, got[bkey], cells)
+		}
+	}
+}
+
+func TestExportCSVEscapesFormulas(t *testing.T) {
+	s := newTestServer(t)
+	c := s.newClient(t)
+	c.get("/")
+	seedFormulas(t, s, c)
+
+	status, body := c.get("/api/export/access?filter=31")
+	if status != http.StatusOK {
+		t.Fatalf("export: %d %s", status, body)
+	}
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(exportedCells)
+	for _, record := range records[1:] {
+		got[record[2]] = [4]string{record[1], record[3], record[4], record[5]}
+	}
+	checkExportedCells(t, got, exportedCells{
+		"1006": {"'+31@voorbeeld.nl", `'=HYPERLINK("http://example.com")`, "REGIO NOORD", "'@extern"},
+		"1007": {"'+31@voorbeeld.nl", "'\tVerborgen", "regio noord", "'-Extern"},
+	})
+}
+
+// xlsxSheet is the part of a worksheet the export test reads
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Value string `xml:"v"`
+			Text  string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func TestExportXLSXKeepsRawValues(t *testing.T) {
+	s := newTestServer(t)
+	c := s.newClient(t)
+	c.get("/")
+	seedFormulas(t, s, c)
+
+	status, body := c.get("/api/export/access?format=xlsx&filter=31")
+	if status != http.StatusOK {
+		t.Fatalf("export: %d", status)
+	}
+	zr, err := zip.NewReader(bytes.NewReader([]byte(body)), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sheets []xlsxSheet
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "xl/worksheets/") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sheet xlsxSheet
+		if err := xml.Unmarshal(data, &sheet); err != nil {
+			t.Fatal(err)
+		}
+		sheets = append(sheets, sheet)
+	}
+
+	// "REGIO NOORD" and "regio noord" are the same unit, so they share a sheet
+	if len(sheets) != 1 {
+		t.Fatalf("workbook has %d sheets, want one for Regio Noord", len(sheets))
+	}
+	got := make(exportedCells)
+	for _, row := range sheets[0].Rows[1:] {
+		cells := row.Cells
+		got[cells[2].Value] = [4]string{cells[1].Text, cells[3].Text, cells[4].Text, cells[5].Text}
+	}
+	checkExportedCells(t, got, exportedCells{
+		"1006": {"+31@voorbeeld.nl", `=HYPERLINK("http://example.com")`, "REGIO NOORD", "@extern"},
+		"1007": {"+31@voorbeeld.nl", "\tVerborgen", "regio noord", "-Extern"},
+	})
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	groupRepo := h.groupRepo
+	h.mu.RUnlock()
+
+	if groupRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	page, limit, err := parsePaging(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := repository.GroupListOptions{
+		Filter:    r.URL.Query().Get("filter"),
+		SortField: r.URL.Query().Get("sort"),
+		SortDir:   r.URL.Query().Get("dir"),
+		Offset:    (page - 1) * limit,
+		Limit:     limit,
+	}
+
+	groups, total, err := groupRepo.List(r.Context(), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if groups == nil {
+		groups = []models.GroupSummary{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.GroupPage{
+		Items:   groups,
+		Total:   total,
+		Page:    page,
+		Limit:   limit,
+		HasMore: opts.Offset+len(groups) < total,
+	})
+}
+
+// GroupTree returns the children of one node in the Level1 → Level2 → Level3 → Group
+// hierarchy. The node is given as repeated path parameters, top level first.
+func (h *Handler) GroupTree(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	groupRepo := h.groupRepo
+	h.mu.RUnlock()
+
+	if groupRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	path := r.URL.Query()["path"]
+	if len(path) > 3 {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	if s := r.URL.Query().Get("userId"); s != "" {
+		var err error
+		if userID, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	nodes, err := groupRepo.Children(r.Context(), path, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if nodes == nil {
+		nodes = []models.TreeNode{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes)
+}
+
+func (h *Handler) ListGroupUsers(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	accessRepo := h.accessRepo
+	h.mu.RUnlock()
+
+	if accessRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	bkeyStr := r.PathValue("bkey")
+	groupBkey, err := strconv.Atoi(bkeyStr)
+	if err != nil {
+		http.Error(w, "Invalid group key", http.StatusBadRequest)
+		return
+	}
+
+	members, err := accessRepo.ListByGroup(r.Context(), groupBkey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if members == nil {
+		members = []models.GroupMember{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
@@ -1,48 +1,105 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"html/template"
 	"log"
 	"net/http"
+	"net/netip"
 	"path/filepath"
 	"sync"
 
+	"powerbi-access-tool/admin"
+	"powerbi-access-tool/audit"
 	"powerbi-access-tool/config"
 	"powerbi-access-tool/db"
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/oidc"
 	"powerbi-access-tool/repository"
 )
 
 type Handler struct {
 	mu         sync.RWMutex
 	database   *sql.DB
-	userRepo   *repository.UserRepository
-	accessRepo *repository.AccessRepository
-	groupRepo  *repository.GroupRepository
+	userRepo   repository.UserStore
+	accessRepo repository.AccessStore
+	groupRepo  repository.GroupCatalog
+	ruleRepo   repository.RuleStore
+	roleRepo   repository.RoleStore
+	planRepo   repository.PlanApplier
+	auditLog   audit.Store
+	admins     *admin.Store
 	templates  *template.Template
 	config     *config.Config
+	memory     bool
+
+	// oidcProvider is set when admins can sign in with OpenID Connect
+	oidcProvider *oidc.Provider
+
+	// trustedProxies may set X-Forwarded-For for the audit log
+	trustedProxies []netip.Prefix
+
+	// lastReconcile is the report of the latest rule reconciler run
+	lastReconcile *models.ReconcileReport
 }
 
-func NewHandler(
-	database *sql.DB,
-	userRepo *repository.UserRepository,
-	accessRepo *repository.AccessRepository,
-	groupRepo *repository.GroupRepository,
-	cfg *config.Config,
-) (*Handler, error) {
+func NewHandler(database *sql.DB, stores repository.Stores, cfg *config.Config) (*Handler, error) {
 	tmpl, err := template.ParseGlob(filepath.Join("templates", "*.html"))
 	if err != nil {
 		return nil, err
 	}
 
-	return &Handler{
-		database:   database,
-		userRepo:   userRepo,
-		accessRepo: accessRepo,
-		groupRepo:  groupRepo,
-		templates:  tmpl,
-		config:     cfg,
-	}, nil
+	adminsPath, err := cfg.AdminsFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Handler{
+		database:  database,
+		templates: tmpl,
+		config:    cfg,
+		admins:    admin.NewStore(adminsPath),
+	}
+	h.setStores(stores)
+	h.auditLog = audit.Open(h.config, database)
+
+	return h, nil
+}
+
+// NewMemoryHandler creates a handler backed by an in-memory store instead of SQL Server
+func NewMemoryHandler(memDB *repository.MemoryDB, cfg *config.Config) (*Handler, error) {
+	h, err := NewHandler(nil, repository.NewMemoryStores(memDB), cfg)
+	if err != nil {
+		return nil, err
+	}
+	h.memory = true
+	return h, nil
+}
+
+// setStores replaces the repositories; the caller must hold the write lock once serving
+func (h *Handler) setStores(stores repository.Stores) {
+	h.userRepo = stores.Users
+	h.accessRepo = stores.Access
+	h.groupRepo = stores.Groups
+	h.ruleRepo = stores.Rules
+	h.roleRepo = stores.Roles
+	h.planRepo = stores.Plans
+}
+
+// currentStores returns the repositories in use, for code that works on the whole bundle
+func (h *Handler) currentStores() repository.Stores {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return repository.Stores{
+		Users:  h.userRepo,
+		Access: h.accessRepo,
+		Groups: h.groupRepo,
+		Rules:  h.ruleRepo,
+		Roles:  h.roleRepo,
+		Plans:  h.planRepo,
+	}
 }
 
 // reconnectDatabase closes the old connection and creates a new one
@@ -50,13 +107,17 @@ func (h *Handler) reconnectDatabase() error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	// The in-memory store is never replaced by a database connection
+	if h.memory {
+		return nil
+	}
+
 	// Close existing connection if any
 	if h.database != nil {
 		h.database.Close()
 		h.database = nil
-		h.userRepo = nil
-		h.accessRepo = nil
-		h.groupRepo = nil
+		h.setStores(repository.Stores{})
+		h.auditLog = audit.Open(h.config, nil)
 	}
 
 	// Only connect if credentials are configured
@@ -77,17 +138,42 @@ func (h *Handler) reconnectDatabase() error {
 		return err
 	}
 
+	// The access queries join the side tables, so without them the database is unusable
+	if err := repository.EnsureSchema(context.Background(), database); err != nil {
+		database.Close()
+		log.Printf("Failed to prepare database: %v", err)
+		return err
+	}
+
 	h.database = database
-	h.userRepo = repository.NewUserRepository(database)
-	h.accessRepo = repository.NewAccessRepository(database)
-	h.groupRepo = repository.NewGroupRepository(database)
+	h.setStores(repository.NewSQLStores(database))
+	h.auditLog = audit.Open(h.config, database)
 
 	log.Println("Connected to database successfully")
 	return nil
 }
 
 func (h *Handler) IndexPage(w http.ResponseWriter, r *http.Request) {
-	if err := h.templates.ExecuteTemplate(w, "index.html", nil); err != nil {
+	// The role hides actions the admin may not take; the routes refuse them anyway
+	name, _ := AdminFromContext(r.Context())
+	token, err := csrfToken(w, r)
+	if err != nil {
+		http.Error(w, "Failed to create CSRF token", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Admin     string
+		Role      string
+		Scoped    bool
+		CSRFToken string
+	}{
+		Admin:     name,
+		Role:      string(RoleFromContext(r.Context())),
+		Scoped:    len(ScopeFromContext(r.Context())) > 0,
+		CSRFToken: token,
+	}
+
+	if err := h.templates.ExecuteTemplate(w, "index.html", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -100,13 +186,20 @@ type SettingsPageData struct {
 	Saved       bool
 	Connected   bool
 	Error       string
+	CSRFToken   string
 }
 
 func (h *Handler) SettingsPage(w http.ResponseWriter, r *http.Request) {
 	h.mu.RLock()
-	connected := h.database != nil
+	connected := h.userRepo != nil
 	h.mu.RUnlock()
 
+	token, err := csrfToken(w, r)
+	if err != nil {
+		http.Error(w, "Failed to create CSRF token", http.StatusInternalServerError)
+		return
+	}
+
 	data := SettingsPageData{
 		Server:      h.config.Server,
 		Database:    h.config.Database,
@@ -115,6 +208,7 @@ func (h *Handler) SettingsPage(w http.ResponseWriter, r *http.Request) {
 		Saved:       r.URL.Query().Get("saved") == "1",
 		Connected:   connected,
 		Error:       r.URL.Query().Get("error"),
+		CSRFToken:   token,
 	}
 	if err := h.templates.ExecuteTemplate(w, "settings.html", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -157,30 +251,76 @@ func SetupRoutes(h *Handler) http.Handler {
 	// Auth routes
 	mux.HandleFunc("GET /login", h.LoginPage)
 	mux.HandleFunc("POST /login", h.Login)
+	mux.HandleFunc("GET /login/oidc", h.OIDCLogin)
+	mux.HandleFunc("GET /login/oidc/callback", h.OIDCCallback)
 	mux.HandleFunc("GET /logout", h.Logout)
 
+	// Viewers read users and access, editors also change them and only
+	// administrators change the connection settings
+	viewer := requireRole(admin.RoleViewer)
+	editor := requireRole(admin.RoleEditor)
+	administrator := requireRole(admin.RoleAdministrator)
+
+	// Admins limited to Level2 units work on one user's access at a time;
+	// routes spanning all groups are wrapped in unscoped
+
 	// Pages
-	mux.HandleFunc("GET /", h.IndexPage)
-	mux.HandleFunc("GET /settings", h.SettingsPage)
-	mux.HandleFunc("POST /settings", h.SaveSettings)
+	mux.Handle("GET /", viewer(h.IndexPage))
+	mux.Handle("GET /settings", administrator(h.SettingsPage))
+	mux.Handle("POST /settings", administrator(h.SaveSettings))
 
 	// User API
-	mux.HandleFunc("GET /api/users", h.ListUsers)
-	mux.HandleFunc("POST /api/users", h.CreateUser)
-	mux.HandleFunc("PUT /api/users/{id}", h.UpdateUser)
-	mux.HandleFunc("DELETE /api/users/{id}", h.DeleteUser)
+	mux.Handle("GET /api/users", viewer(h.ListUsers))
+	mux.Handle("GET /api/users/compare", viewer(unscoped(h.CompareUsers)))
+	mux.Handle("POST /api/users", editor(h.CreateUser))
+	mux.Handle("PUT /api/users/{id}", editor(unscoped(h.UpdateUser)))
+	mux.Handle("DELETE /api/users/{id}", editor(unscoped(h.DeleteUser)))
 
 	// Access API
-	mux.HandleFunc("GET /api/users/{id}/access", h.ListUserAccess)
-	mux.HandleFunc("POST /api/users/{id}/access", h.AddUserAccess)
-	mux.HandleFunc("DELETE /api/access/{id}", h.RemoveAccess)
+	mux.Handle("GET /api/users/{id}/access", viewer(h.ListUserAccess))
+	mux.Handle("POST /api/users/{id}/access", editor(h.AddUserAccess))
+	mux.Handle("POST /api/users/{id}/access/subtree", editor(unscoped(h.GrantSubtree)))
+	mux.Handle("POST /api/users/{id}/access/copy-from/{sourceId}", editor(unscoped(h.CopyAccess)))
+	mux.Handle("DELETE /api/access/{id}", editor(h.RemoveAccess))
+	mux.Handle("GET /api/access/orphaned", viewer(unscoped(h.ListOrphanedAccess)))
+	mux.Handle("POST /api/access/orphaned/cleanup", editor(unscoped(h.CleanupOrphanedAccess)))
+
+	// Import and export API
+	mux.Handle("POST /api/import", editor(unscoped(h.ImportCSV)))
+	mux.Handle("GET /api/export/access", viewer(unscoped(h.ExportAccess)))
 
 	// Search API
-	mux.HandleFunc("GET /api/groups/search", h.SearchGroups)
+	mux.Handle("GET /api/groups/search", viewer(h.SearchGroups))
+
+	// Group API
+	mux.Handle("GET /api/groups", viewer(unscoped(h.ListGroups)))
+	mux.Handle("GET /api/groups/tree", viewer(unscoped(h.GroupTree)))
+	mux.Handle("GET /api/groups/{bkey}/users", viewer(unscoped(h.ListGroupUsers)))
+
+	// Rule API
+	mux.Handle("GET /api/rules", viewer(unscoped(h.ListRules)))
+	mux.Handle("POST /api/rules", editor(unscoped(h.CreateRule)))
+	mux.Handle("DELETE /api/rules/{id}", editor(unscoped(h.DeleteRule)))
+	mux.Handle("GET /api/rules/reconcile", viewer(unscoped(h.LastReconcile)))
+	mux.Handle("POST /api/rules/reconcile", editor(unscoped(h.ReconcileRules)))
+
+	// Roles API
+	mux.Handle("GET /api/roles", viewer(unscoped(h.ListRoles)))
+	mux.Handle("POST /api/roles", editor(unscoped(h.CreateRole)))
+	mux.Handle("GET /api/roles/{id}", viewer(unscoped(h.GetRole)))
+	mux.Handle("PUT /api/roles/{id}", editor(unscoped(h.UpdateRole)))
+	mux.Handle("DELETE /api/roles/{id}", editor(unscoped(h.DeleteRole)))
+	mux.Handle("GET /api/roles/{id}/members", viewer(unscoped(h.ListRoleMembers)))
+	mux.Handle("POST /api/roles/{id}/members", editor(unscoped(h.AssignRole)))
+	mux.Handle("DELETE /api/roles/{id}/members/{userId}", editor(unscoped(h.UnassignRole)))
+	mux.Handle("GET /api/users/{id}/roles", viewer(unscoped(h.ListUserRoles)))
+
+	// Audit API
+	mux.Handle("GET /api/audit", viewer(unscoped(h.ListAudit)))
 
 	// Static files
 	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
-	// Wrap with auth middleware
-	return AuthMiddleware(mux)
+	// Wrap with auth middleware; CSRF tokens are checked once the session is known
+	return h.AuthMiddleware(h.CSRFMiddleware(mux))
 }
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/admin"
+	"powerbi-access-tool/config"
+	"powerbi-access-tool/repository"
+)
+
+func TestMain(m *testing.M) {
+	// Templates are loaded relative to the module root, and the config directory
+	// must not be the developer's own
+	if err := os.Chdir(".."); err != nil {
+		log.Fatal(err)
+	}
+	home, err := os.MkdirTemp("", "handlers-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv("HOME", home)
+	os.Unsetenv("POWERBI_ADMIN_PASSWORD")
+	log.SetOutput(io.Discard)
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// testServer runs the routes of a handler on the demo data of the in-memory store
+type testServer struct {
+	*httptest.Server
+	h  *Handler
+	db *repository.MemoryDB
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	cfg := config.DefaultConfig()
+	cfg.AuditStore = "file"
+	cfg.AuditFile = filepath.Join(dir, "audit.jsonl")
+	cfg.AdminsFile = filepath.Join(dir, "admins.json")
+
+	memDB, err := repository.OpenMemoryDB("")
+	if err != nil {
+		t.Fatalf("OpenMemoryDB: %v", err)
+	}
+	h, err := NewMemoryHandler(memDB, cfg)
+	if err != nil {
+		t.Fatalf("NewMemoryHandler: %v", err)
+	}
+
+	srv := httptest.NewServer(SetupRoutes(h))
+	t.Cleanup(srv.Close)
+	return &testServer{Server: srv, h: h, db: memDB}
+}
+
+// addAdmin creates a local account
+func (s *testServer) addAdmin(t *testing.T, username string, role admin.Role, scope ...string) {
+	t.Helper()
+	if _, err := s.h.admins.Add(username, "correct horse battery", role); err != nil {
+		t.Fatalf("add admin %s: %v", username, err)
+	}
+	if err := s.h.admins.SetScope(username, scope); err != nil {
+		t.Fatalf("scope admin %s: %v", username, err)
+	}
+}
+
+// testClient is a browser: it keeps cookies, does not follow redirects and
+// sends the CSRF token of the last page it loaded
+type testClient struct {
+	t      *testing.T
+	srv    *testServer
+	client *http.Client
+	csrf   string
+}
+
+func (s *testServer) newClient(t *testing.T) *testClient {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t: t, srv: s, client: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+var csrfTokenPattern = regexp.MustCompile(`name="csrf[-_]token" (?:content|value)="([^"]+)"`)
+
+// do sends a request and returns the status and body. Pages update the CSRF
+// token the client sends along.
+func (c *testClient) do(method, path, contentType, body string) (int, string) {
+	c.t.Helper()
+	target := path
+	if strings.HasPrefix(path, "/") {
+		target = c.srv.URL + path
+	}
+
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.csrf != "" && contentType != "application/x-www-form-urlencoded" {
+		req.Header.Set(csrfHeaderName, c.csrf)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	if m := csrfTokenPattern.FindSubmatch(data); m != nil {
+		c.csrf = string(m[1])
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		return resp.StatusCode, location
+	}
+	return resp.StatusCode, string(data)
+}
+
+func (c *testClient) get(path string) (int, string) {
+	c.t.Helper()
+	return c.do(http.MethodGet, path, "", "")
+}
+
+func (c *testClient) postJSON(path, body string) (int, string) {
+	c.t.Helper()
+	return c.do(http.MethodPost, path, "application/json", body)
+}
+
+// login signs in with the login form and loads the index page for its CSRF token
+func (c *testClient) login(username string) {
+	c.t.Helper()
+	c.get("/login")
+	form := url.Values{"csrf_token": {c.csrf}, "username": {username}, "password": {"correct horse battery"}}
+	status, location := c.do(http.MethodPost, "/login", "application/x-www-form-urlencoded", form.Encode())
+	if status != http.StatusSeeOther || location != "/" {
+		c.t.Fatalf("login %s: %d %s", username, status, location)
+	}
+	if status, _ := c.get("/"); status != http.StatusOK {
+		c.t.Fatalf("index after login %s: %d", username, status)
+	}
+}
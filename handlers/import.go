@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/importer"
+	"powerbi-access-tool/models"
+)
+
+// maxImportSize limits the size of an uploaded CSV file
+const maxImportSize = 5 << 20
+
+// ImportCSV validates a CSV of users and group assignments, sent as the request
+// body or as the "file" field of a multipart form. With ?dryRun=1 only the preview
+// is returned; otherwise the import is applied in one transaction, unless any row
+// has errors, in which case nothing is changed and the preview is returned with 422.
+func (h *Handler) ImportCSV(w http.ResponseWriter, r *http.Request) {
+	stores := h.currentStores()
+	if stores.Users == nil || stores.Plans == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := importBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	records, err := importer.Parse(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "CSV has no rows", http.StatusBadRequest)
+		return
+	}
+
+	preview, plan, err := importer.Preview(r.Context(), stores, records)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Query().Get("dryRun") == "1" {
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
+	if preview.ErrorCount > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(preview)
+		return
+	}
+
+	created, err := stores.Plans.Apply(r.Context(), plan)
+	if err != nil {
+		w.Header().Del("Content-Type")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	preview.Applied = true
+
+	entries := make([]models.AuditEntry, 0, len(plan.Changes))
+	for _, c := range plan.Changes {
+		entry := models.AuditEntry{UserID: created.UserID(c), UserEmail: c.Email}
+		if c.Action == models.PlanCreateUser {
+			entry.Action = audit.ActionUserCreate
+			entry.After = fmt.Sprintf("%s (CSV import)", c.Email)
+		} else {
+			entry.Action = audit.ActionAccessGrant
+			entry.GroupBkey = c.GroupBkey
+			entry.GroupName = c.GroupName
+			entry.After = "granted by CSV import"
+		}
+		entries = append(entries, entry)
+	}
+	h.recordAudit(r, entries...)
+
+	json.NewEncoder(w).Encode(preview)
+}
+
+// importBody returns the uploaded CSV from a multipart form or the raw body
+func importBody(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.Body, nil
+	}
+
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return nil, fmt.Errorf("invalid upload: %w", err)
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("upload has no file field")
+	}
+	return file, nil
+}
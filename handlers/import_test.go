@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+)
+
+func importCSV(t *testing.T, c *testClient, query, csv string) (int, models.ImportPreview) {
+	t.Helper()
+	status, body := c.do(http.MethodPost, "/api/import"+query, "text/csv", csv)
+	var preview models.ImportPreview
+	if status == http.StatusOK || status == http.StatusUnprocessableEntity {
+		if err := json.Unmarshal([]byte(body), &preview); err != nil {
+			t.Fatalf("import response %q: %v", body, err)
+		}
+	}
+	return status, preview
+}
+
+func TestImportRejectsInvalidRows(t *testing.T) {
+	s := newTestServer(t)
+	c := s.newClient(t)
+	c.get("/")
+
+	csv := "email;group\n" +
+		"nieuw@voorbeeld.nl;1001\n" +
+		"geen-email;1001\n" +
+		"anna@voorbeeld.nl;9999\n" +
+		"bert@voorbeeld.nl;abc\n"
+	status, preview := importCSV(t, c, "", csv)
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("import with errors: %d, want 422", status)
+	}
+	if preview.Applied || preview.ErrorCount != 3 {
+		t.Fatalf("preview: applied %v with %d errors, want 3 errors and nothing applied", preview.Applied, preview.ErrorCount)
+	}
+	wantErrors := map[int]string{3: "not an email address", 4: "does not exist", 5: "not a group key"}
+	for _, row := range preview.Rows {
+		want, ok := wantErrors[row.Line]
+		if got := strings.Join(row.Errors, "; "); ok != (got != "") || !strings.Contains(got, want) {
+			t.Errorf("line %d: errors %q, want %q", row.Line, got, want)
+		}
+	}
+
+	if status, body := c.get("/api/users?q=nieuw"); status != http.StatusOK || strings.Contains(body, "nieuw@voorbeeld.nl") {
+		t.Errorf("rejected import created a user: %d %s", status, body)
+	}
+}
+
+func TestImportApplies(t *testing.T) {
+	s := newTestServer(t)
+	c := s.newClient(t)
+	c.get("/")
+
+	csv := "email,level2\nnieuw@voorbeeld.nl,Regio Noord\nanna@voorbeeld.nl,Holding\n"
+
+	status, preview := importCSV(t, c, "?dryRun=1", csv)
+	if status != http.StatusOK || preview.Applied || preview.Creates != 1 || preview.Grants != 3 {
+		t.Fatalf("dry run: %d %+v, want 1 create and 3 grants, not applied", status, preview)
+	}
+	if groups := userGroups(t, c, 1); len(groups) != 0 {
+		t.Fatalf("dry run granted %v", groups)
+	}
+
+	status, preview = importCSV(t, c, "", csv)
+	if status != http.StatusOK || !preview.Applied {
+		t.Fatalf("import: %d %+v", status, preview)
+	}
+	if groups := userGroups(t, c, 1); len(groups) != 1 || groups[1005] == 0 {
+		t.Errorf("anna has %v after import, want group 1005", groups)
+	}
+	if groups := userGroups(t, c, 3); len(groups) != 2 || groups[1001] == 0 || groups[1002] == 0 {
+		t.Errorf("new user has %v after import, want groups 1001 and 1002", groups)
+	}
+
+	// The audit entries of the new user carry the ID it was given
+	status, body := c.get("/api/audit?userId=3")
+	if status != http.StatusOK {
+		t.Fatalf("audit: %d %s", status, body)
+	}
+	var entries []models.AuditEntry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal(err)
+	}
+	actions := make(map[string]int)
+	for _, e := range entries {
+		actions[e.Action]++
+	}
+	if actions[audit.ActionUserCreate] != 1 || actions[audit.ActionAccessGrant] != 2 {
+		t.Errorf("audit of the new user has %v, want 1 create and 2 grants", actions)
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"powerbi-access-tool/oidc"
+)
+
+const (
+	oidcStateCookieName = "powerbi_oidc_state"
+	// oidcLoginTimeout is how long a sign-in at the issuer may take
+	oidcLoginTimeout = 10 * time.Minute
+	// maxPendingLogins bounds the sign-ins anyone can start without logging in
+	maxPendingLogins = 1000
+)
+
+// pendingLogin is a sign-in started at the issuer that has not come back yet
+type pendingLogin struct {
+	request *oidc.AuthRequest
+	expiry  time.Time
+}
+
+type pendingLoginStore struct {
+	mu     sync.Mutex
+	logins map[string]pendingLogin
+}
+
+var pendingLogins = &pendingLoginStore{
+	logins: make(map[string]pendingLogin),
+}
+
+// add remembers a sign-in until it comes back or times out. It refuses new
+// sign-ins while maxPendingLogins are in progress.
+func (s *pendingLoginStore) add(req *oidc.AuthRequest) bool {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop sign-ins that were abandoned
+	for state, p := range s.logins {
+		if now.After(p.expiry) {
+			delete(s.logins, state)
+		}
+	}
+	if len(s.logins) >= maxPendingLogins {
+		return false
+	}
+	s.logins[req.State] = pendingLogin{request: req, expiry: now.Add(oidcLoginTimeout)}
+	return true
+}
+
+// take removes and returns the sign-in with the given state; each can be completed once
+func (s *pendingLoginStore) take(state string) (*oidc.AuthRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.logins[state]
+	delete(s.logins, state)
+	if !ok || time.Now().After(p.expiry) {
+		return nil, false
+	}
+	return p.request, true
+}
+
+// EnableOIDC lets admins sign in at an OpenID Connect issuer next to the local accounts
+func (h *Handler) EnableOIDC(provider *oidc.Provider) {
+	h.oidcProvider = provider
+}
+
+// OIDCLogin sends the browser to the issuer to sign in
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidcProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	authURL, err := h.oidcProvider.AuthCodeURL(r.Context(), req)
+	if err != nil {
+		log.Printf("OIDC sign-in failed: %v", err)
+		http.Redirect(w, r, "/login?error=oidc", http.StatusSeeOther)
+		return
+	}
+
+	if !pendingLogins.add(req) {
+		log.Printf("OIDC sign-in refused: %d sign-ins in progress", maxPendingLogins)
+		http.Error(w, "Too many sign-ins in progress, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The state cookie ties the callback to the browser that started the sign-in
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    req.State,
+		Path:     "/login/oidc",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+	})
+
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// OIDCCallback completes a sign-in: it redeems the authorization code, validates
+// the ID token and creates a session for allowed accounts
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidcProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    "",
+		Path:     "/login/oidc",
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
+
+	q := r.URL.Query()
+	if errCode := q.Get("error"); errCode != "" {
+		log.Printf("OIDC sign-in failed: %s: %s", errCode, q.Get("error_description"))
+		http.Redirect(w, r, "/login?error=oidc", http.StatusSeeOther)
+		return
+	}
+
+	state := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil || state == "" || cookie.Value != state {
+		log.Printf("OIDC sign-in failed: state does not match this browser")
+		http.Redirect(w, r, "/login?error=oidc", http.StatusSeeOther)
+		return
+	}
+	req, ok := pendingLogins.take(state)
+	if !ok {
+		log.Printf("OIDC sign-in failed: unknown or expired state")
+		http.Redirect(w, r, "/login?error=oidc", http.StatusSeeOther)
+		return
+	}
+
+	identity, err := h.oidcProvider.Exchange(r.Context(), req, q.Get("code"))
+	if errors.Is(err, oidc.ErrNotAllowed) {
+		log.Printf("OIDC sign-in refused for %s: not on the allowlist", identity.Name)
+		http.Redirect(w, r, "/login?error=forbidden", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC sign-in failed: %v", err)
+		http.Redirect(w, r, "/login?error=oidc", http.StatusSeeOther)
+		return
+	}
+
+	h.createSessionAndRedirect(w, r, session{
+		admin:   identity.Name,
+		source:  sessionOIDC,
+		role:    identity.Role,
+		scope:   identity.Level2Scope,
+		idToken: identity.IDToken,
+	})
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/oidc"
+)
+
+// newOIDCTestServer enables sign-in at the mock issuer with its default groups
+func newOIDCTestServer(t *testing.T) *testServer {
+	t.Helper()
+	m, err := oidc.StartMockIssuer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("StartMockIssuer: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	s := newTestServer(t)
+	provider, err := oidc.NewProvider(oidc.Config{
+		Issuer:              m.URL,
+		ClientID:            "powerbi-access-tool",
+		RedirectURL:         s.URL + "/login/oidc/callback",
+		AllowValues:         []string{oidc.MockViewerGroup},
+		EditorValues:        []string{oidc.MockEditorGroup, oidc.MockScopedGroup},
+		AdministratorValues: []string{oidc.MockAdminGroup},
+		Level2Scopes:        map[string][]string{oidc.MockScopedGroup: {oidc.MockScopedLevel2}},
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	s.h.EnableOIDC(provider)
+	return s
+}
+
+// signInAtIssuer starts a sign-in and submits the mock issuer's page, returning
+// the callback URL the issuer sends the browser to
+func (c *testClient) signInAtIssuer(username, groups string) string {
+	c.t.Helper()
+	status, authURL := c.get("/login/oidc")
+	if status != http.StatusSeeOther {
+		c.t.Fatalf("GET /login/oidc: %d %s", status, authURL)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if u.Query().Get("code_challenge_method") != "S256" || u.Query().Get("code_challenge") == "" {
+		c.t.Fatalf("sign-in without PKCE: %s", authURL)
+	}
+	form := u.Query()
+	form.Set("username", username)
+	form.Set("groups", groups)
+
+	u.RawQuery = ""
+	status, callback := c.do(http.MethodPost, u.String(), "application/x-www-form-urlencoded", form.Encode())
+	if status != http.StatusSeeOther {
+		c.t.Fatalf("POST %s: %d %s", u, status, callback)
+	}
+	return callback
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	s := newOIDCTestServer(t)
+	c := s.newClient(t)
+
+	status, location := c.get(c.signInAtIssuer("Kees@Voorbeeld.nl", oidc.MockEditorGroup))
+	if status != http.StatusSeeOther || location != "/" {
+		t.Fatalf("callback: %d %s, want redirect to /", status, location)
+	}
+
+	status, body := c.get("/")
+	if status != http.StatusOK || !strings.Contains(body, `class="role-editor"`) {
+		t.Fatalf("index: %d, want the editor page", status)
+	}
+	if !strings.Contains(body, "kees@voorbeeld.nl") {
+		t.Error("index does not name the signed-in account")
+	}
+}
+
+func TestOIDCSignInWithLevel2Scope(t *testing.T) {
+	s := newOIDCTestServer(t)
+	c := s.newClient(t)
+
+	c.get(c.signInAtIssuer("kees@voorbeeld.nl", oidc.MockScopedGroup))
+	if _, body := c.get("/"); !strings.Contains(body, `class="role-editor scoped"`) {
+		t.Fatal("scoped sign-in does not get the scoped page")
+	}
+	if status, _ := c.get("/api/groups"); status != http.StatusForbidden {
+		t.Errorf("GET /api/groups: %d, want 403 for a scoped session", status)
+	}
+}
+
+func TestOIDCCallbackRefusesAccountsNotOnAllowlist(t *testing.T) {
+	s := newOIDCTestServer(t)
+	c := s.newClient(t)
+
+	status, location := c.get(c.signInAtIssuer("gast@voorbeeld.nl", "everyone"))
+	if status != http.StatusSeeOther || location != "/login?error=forbidden" {
+		t.Fatalf("callback: %d %s, want redirect to /login?error=forbidden", status, location)
+	}
+	if status, _ := c.get("/"); status != http.StatusSeeOther {
+		t.Errorf("index after refusal: %d, want redirect to login", status)
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	s := newOIDCTestServer(t)
+	callback := s.newClient(t).signInAtIssuer(oidc.MockDefaultUser, oidc.MockAdminGroup)
+
+	// Another browser without the state cookie cannot complete the sign-in
+	status, location := s.newClient(t).get(callback)
+	if status != http.StatusSeeOther || location != "/login?error=oidc" {
+		t.Fatalf("callback in another browser: %d %s, want /login?error=oidc", status, location)
+	}
+}
+
+func TestOIDCCallbackCompletesOnce(t *testing.T) {
+	s := newOIDCTestServer(t)
+	c := s.newClient(t)
+	callback := c.signInAtIssuer(oidc.MockDefaultUser, oidc.MockAdminGroup)
+
+	if status, location := c.get(callback); location != "/" {
+		t.Fatalf("first callback: %d %s", status, location)
+	}
+	if status, location := c.get(callback); location != "/login?error=oidc" {
+		t.Fatalf("replayed callback: %d %s, want /login?error=oidc", status, location)
+	}
+}
+
+func TestOIDCLoginLimitsPendingSignIns(t *testing.T) {
+	s := newOIDCTestServer(t)
+
+	// The store is shared with the other tests
+	clearPending := func() {
+		pendingLogins.mu.Lock()
+		clear(pendingLogins.logins)
+		pendingLogins.mu.Unlock()
+	}
+	clearPending()
+	t.Cleanup(clearPending)
+
+	c := s.newClient(t)
+	for i := 0; i < maxPendingLogins; i++ {
+		if status, _ := c.get("/login/oidc"); status != http.StatusSeeOther {
+			t.Fatalf("sign-in %d: %d", i, status)
+		}
+	}
+	if status, _ := c.get("/login/oidc"); status != http.StatusServiceUnavailable {
+		t.Fatalf("sign-in beyond the limit: %d, want 503", status)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+)
+
+type CleanupOrphanedRequest struct {
+	AccessIDs []int `json:"accessIds"`
+}
+
+type CleanupOrphanedResponse struct {
+	Removed []models.OrphanedAccess `json:"removed"`
+}
+
+func (h *Handler) ListOrphanedAccess(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	accessRepo := h.accessRepo
+	h.mu.RUnlock()
+
+	if accessRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	orphaned, err := accessRepo.ListOrphaned(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if orphaned == nil {
+		orphaned = []models.OrphanedAccess{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orphaned)
+}
+
+// CleanupOrphanedAccess removes the selected orphaned records, or all of them
+// when no IDs are given
+func (h *Handler) CleanupOrphanedAccess(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	accessRepo := h.accessRepo
+	h.mu.RUnlock()
+
+	if accessRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req CleanupOrphanedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := accessRepo.RemoveOrphaned(r.Context(), req.AccessIDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]models.AuditEntry, 0, len(removed))
+	for _, o := range removed {
+		entries = append(entries, models.AuditEntry{
+			Action:    audit.ActionAccessClean,
+			UserID:    o.UserID,
+			UserEmail: o.UserEmail,
+			GroupBkey: o.GroupBkey,
+			Before:    "orphaned",
+		})
+	}
+	h.recordAudit(r, entries...)
+
+	if removed == nil {
+		removed = []models.OrphanedAccess{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CleanupOrphanedResponse{Removed: removed})
+}
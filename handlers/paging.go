@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePaging reads the 1-based page and limit query parameters
+func parsePaging(r *http.Request) (int, int, error) {
+	page := 1
+	if s := r.URL.Query().Get("page"); s != "" {
+		p, err := strconv.Atoi(s)
+		if err != nil || p < 1 {
+			return 0, 0, fmt.Errorf("Invalid page")
+		}
+		page = p
+	}
+
+	limit := defaultPageLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil || l < 1 {
+			return 0, 0, fmt.Errorf("Invalid limit")
+		}
+		limit = min(l, maxPageLimit)
+	}
+
+	return page, limit, nil
+}
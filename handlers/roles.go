@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+// maxRoleName matches the width of powerbi.AccessRole.Name
+const maxRoleName = 255
+
+// RoleRequest creates a role or replaces its name, description and groups
+type RoleRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	GroupBkeys  []int  `json:"groupBkeys"`
+}
+
+type AssignRoleRequest struct {
+	UserID int `json:"userId"`
+}
+
+func (h *Handler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	roles, err := roleRepo.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if roles == nil {
+		roles = []models.Role{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+func (h *Handler) GetRole(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	role, ok := lookupRole(w, r, roleRepo)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	groupRepo := h.groupRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil || groupRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if status, err := validateRoleRequest(r.Context(), roleRepo, groupRepo, &req, 0); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	id, err := roleRepo.Create(r.Context(), req.Name, req.Description, req.GroupBkeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, models.AuditEntry{
+		Action: audit.ActionRoleCreate,
+		After:  roleDescription(req.Name, req.GroupBkeys),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"id": id})
+}
+
+// UpdateRole replaces a role's name, description and groups. Every member is
+// granted the added groups and loses the dropped groups the role had granted.
+func (h *Handler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	groupRepo := h.groupRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil || groupRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	role, ok := lookupRole(w, r, roleRepo)
+	if !ok {
+		return
+	}
+
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if status, err := validateRoleRequest(r.Context(), roleRepo, groupRepo, &req, role.ID); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	sync, err := roleRepo.Update(r.Context(), role.ID, req.Name, req.Description, req.GroupBkeys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, models.AuditEntry{
+		Action: audit.ActionRoleUpdate,
+		Before: roleDescription(role.Name, role.GroupBkeys()),
+		After:  roleDescription(req.Name, req.GroupBkeys),
+	})
+	h.auditRoleSync(r, map[int]string{role.ID: req.Name}, sync)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sync)
+}
+
+// DeleteRole removes a role and revokes the access it granted to its members
+func (h *Handler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	role, ok := lookupRole(w, r, roleRepo)
+	if !ok {
+		return
+	}
+
+	sync, err := roleRepo.Delete(r.Context(), role.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, models.AuditEntry{
+		Action: audit.ActionRoleDelete,
+		Before: roleDescription(role.Name, role.GroupBkeys()),
+	})
+	h.auditRoleSync(r, map[int]string{role.ID: role.Name}, sync)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sync)
+}
+
+func (h *Handler) ListRoleMembers(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	role, ok := lookupRole(w, r, roleRepo)
+	if !ok {
+		return
+	}
+
+	members, err := roleRepo.ListMembers(r.Context(), role.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if members == nil {
+		members = []models.RoleMember{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// AssignRole makes a user a member of the role, granting all of its groups
+func (h *Handler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	userRepo := h.userRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil || userRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	role, ok := lookupRole(w, r, roleRepo)
+	if !ok {
+		return
+	}
+
+	var req AssignRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userRepo.GetByID(r.Context(), req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	sync, err := roleRepo.Assign(r.Context(), role.ID, user.PowerBIUserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, models.AuditEntry{
+		Action:    audit.ActionRoleAssign,
+		UserID:    user.PowerBIUserID,
+		UserEmail: user.PowerBIUser,
+		After:     "role " + role.Name,
+	})
+	h.auditRoleSync(r, map[int]string{role.ID: role.Name}, sync)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sync)
+}
+
+// UnassignRole removes a user from the role, revoking only what the role granted
+func (h *Handler) UnassignRole(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	role, ok := lookupRole(w, r, roleRepo)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	userRoles, err := roleRepo.ListByUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !slices.ContainsFunc(userRoles, func(ur models.Role) bool { return ur.ID == role.ID }) {
+		http.Error(w, "role member not found", http.StatusNotFound)
+		return
+	}
+
+	sync, err := roleRepo.Unassign(r.Context(), role.ID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, models.AuditEntry{
+		Action:    audit.ActionRoleUnassign,
+		UserID:    userID,
+		UserEmail: h.userEmail(r, userID),
+		Before:    "role " + role.Name,
+	})
+	h.auditRoleSync(r, map[int]string{role.ID: role.Name}, sync)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sync)
+}
+
+func (h *Handler) ListUserRoles(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	roleRepo := h.roleRepo
+	h.mu.RUnlock()
+
+	if roleRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	roles, err := roleRepo.ListByUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if roles == nil {
+		roles = []models.Role{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+// lookupRole loads the role named by the {id} path value, writing the error
+// response and returning false when that fails
+func lookupRole(w http.ResponseWriter, r *http.Request, roleRepo repository.RoleStore) (*models.Role, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	role, err := roleRepo.GetByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if role == nil {
+		http.Error(w, "role not found", http.StatusNotFound)
+		return nil, false
+	}
+	return role, true
+}
+
+// validateRoleRequest trims the name and checks that it is set and unused by
+// another role than exceptID, and that every group exists. It returns the status
+// code to respond with when the request is rejected.
+func validateRoleRequest(ctx context.Context, roleRepo repository.RoleStore, groupRepo repository.GroupCatalog, req *RoleRequest, exceptID int) (int, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Description = strings.TrimSpace(req.Description)
+
+	if req.Name == "" {
+		return http.StatusBadRequest, fmt.Errorf("Name is required")
+	}
+	if len([]rune(req.Name)) > maxRoleName {
+		return http.StatusBadRequest, fmt.Errorf("Name is longer than %d characters", maxRoleName)
+	}
+	if len(req.GroupBkeys) == 0 {
+		return http.StatusBadRequest, fmt.Errorf("At least one group is required")
+	}
+
+	roles, err := roleRepo.List(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, role := range roles {
+		if role.ID != exceptID && strings.EqualFold(role.Name, req.Name) {
+			return http.StatusConflict, fmt.Errorf("A role named %q already exists", req.Name)
+		}
+	}
+
+	groups, err := groupRepo.GetByBkeys(ctx, req.GroupBkeys)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, groupBkey := range req.GroupBkeys {
+		if _, ok := groups[groupBkey]; !ok {
+			return http.StatusBadRequest, fmt.Errorf("Unknown group %d", groupBkey)
+		}
+	}
+
+	return 0, nil
+}
+
+// auditRoleSync records the access a role change granted and revoked. names maps
+// role IDs to names for the description; other roles are referred to by ID.
+func (h *Handler) auditRoleSync(r *http.Request, names map[int]string, sync *models.RoleSync) {
+	describe := func(roleID int) string {
+		if name, ok := names[roleID]; ok {
+			return "granted by role " + name
+		}
+		return fmt.Sprintf("granted by role %d", roleID)
+	}
+
+	entries := make([]models.AuditEntry, 0, len(sync.Added)+len(sync.Removed))
+	for _, c := range sync.Added {
+		entries = append(entries, models.AuditEntry{
+			Action:    audit.ActionAccessGrant,
+			UserID:    c.UserID,
+			UserEmail: c.UserEmail,
+			GroupBkey: c.GroupBkey,
+			GroupName: c.GroupName,
+			After:     describe(c.RoleID),
+		})
+	}
+	for _, c := range sync.Removed {
+		entries = append(entries, models.AuditEntry{
+			Action:    audit.ActionAccessRevoke,
+			UserID:    c.UserID,
+			UserEmail: c.UserEmail,
+			GroupBkey: c.GroupBkey,
+			GroupName: c.GroupName,
+			Before:    describe(c.RoleID),
+		})
+	}
+	h.recordAudit(r, entries...)
+}
+
+// roleDescription summarises a role for the audit log
+func roleDescription(name string, groupBkeys []int) string {
+	keys := make([]string, len(groupBkeys))
+	for i, groupBkey := range groupBkeys {
+		keys[i] = strconv.Itoa(groupBkey)
+	}
+	return fmt.Sprintf("role %s: groups %s", name, strings.Join(keys, ", "))
+}
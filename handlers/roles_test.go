@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateRoleChecksGroups(t *testing.T) {
+	s := newTestServer(t)
+	c := s.newClient(t)
+	c.get("/")
+
+	tests := []struct {
+		body   string
+		status int
+		want   string
+	}{
+		{`{"name":"Verkoop","groupBkeys":[]}`, http.StatusBadRequest, "At least one group"},
+		{`{"name":"Verkoop","groupBkeys":[1001,9999,1003]}`, http.StatusBadRequest, "Unknown group 9999"},
+		{`{"name":"Verkoop","groupBkeys":[1001,1003]}`, http.StatusCreated, `"id"`},
+		{`{"name":"verkoop","groupBkeys":[1002]}`, http.StatusConflict, "already exists"},
+	}
+	for _, tt := range tests {
+		status, body := c.postJSON("/api/roles", tt.body)
+		if status != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("POST /api/roles %s: %d %q, want %d %q", tt.body, status, body, tt.status, tt.want)
+		}
+	}
+}
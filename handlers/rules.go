@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+)
+
+type CreateRuleRequest struct {
+	UserID      int    `json:"userId"`
+	Level2Name  string `json:"level2Name"`
+	Level3Name  string `json:"level3Name"`
+	RemoveStale bool   `json:"removeStale"`
+}
+
+func (h *Handler) ListRules(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	ruleRepo := h.ruleRepo
+	h.mu.RUnlock()
+
+	if ruleRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	var userID int
+	if s := r.URL.Query().Get("userId"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		userID = id
+	}
+
+	rules, err := ruleRepo.List(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if rules == nil {
+		rules = []models.AccessRule{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (h *Handler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	ruleRepo := h.ruleRepo
+	userRepo := h.userRepo
+	h.mu.RUnlock()
+
+	if ruleRepo == nil || userRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req CreateRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Level2Name == "" && req.Level3Name == "" {
+		http.Error(w, "A Level2 or Level3 name is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := userRepo.GetByID(r.Context(), req.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	rule := models.AccessRule{
+		UserID:      req.UserID,
+		Level2Name:  req.Level2Name,
+		Level3Name:  req.Level3Name,
+		RemoveStale: req.RemoveStale,
+	}
+	id, err := ruleRepo.Create(r.Context(), rule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rule.ID = id
+
+	h.recordAudit(r, models.AuditEntry{
+		Action:    audit.ActionRuleCreate,
+		UserID:    user.PowerBIUserID,
+		UserEmail: user.PowerBIUser,
+		After:     ruleDescription(rule),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"id": id})
+}
+
+// DeleteRule removes a rule. The access it granted is kept; it is no longer
+// managed by the reconciler.
+func (h *Handler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	ruleRepo := h.ruleRepo
+	h.mu.RUnlock()
+
+	if ruleRepo == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := ruleRepo.List(r.Context(), 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var rule *models.AccessRule
+	for i := range rules {
+		if rules[i].ID == id {
+			rule = &rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		http.Error(w, "rule not found", http.StatusNotFound)
+		return
+	}
+
+	if err := ruleRepo.Delete(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, models.AuditEntry{
+		Action:    audit.ActionRuleDelete,
+		UserID:    rule.UserID,
+		UserEmail: rule.UserEmail,
+		Before:    ruleDescription(*rule),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReconcileRules runs the reconciler now. With ?dryRun=1 it only reports what would change.
+func (h *Handler) ReconcileRules(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dryRun") == "1"
+
+	report, err := h.reconcileRules(r.Context(), dryRun, requestActor(r), h.clientIP(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// LastReconcile returns the report of the latest reconciler run, or null before the first run
+func (h *Handler) LastReconcile(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	report := h.lastReconcile
+	h.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// RunRuleReconciler materialises the access rules every interval until ctx is done
+func (h *Handler) RunRuleReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	h.reconcileScheduled(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.reconcileScheduled(ctx)
+		}
+	}
+}
+
+func (h *Handler) reconcileScheduled(ctx context.Context) {
+	if _, err := h.reconcileRules(ctx, false, audit.ActorSystem, ""); err != nil {
+		log.Printf("Failed to reconcile access rules: %v", err)
+	}
+}
+
+// reconcileRules runs the reconciler, logs and audits the changes and keeps the
+// report of real runs. It returns a nil report when there is no store.
+func (h *Handler) reconcileRules(ctx context.Context, dryRun bool, actor string, ip string) (*models.ReconcileReport, error) {
+	h.mu.RLock()
+	ruleRepo := h.ruleRepo
+	h.mu.RUnlock()
+
+	if ruleRepo == nil {
+		return nil, nil
+	}
+
+	report, err := ruleRepo.Reconcile(ctx, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	h.mu.Lock()
+	h.lastReconcile = report
+	h.mu.Unlock()
+
+	if len(report.Added) > 0 || len(report.Removed) > 0 {
+		log.Printf("Reconciled %d access rules: %d groups granted, %d revoked",
+			report.Rules, len(report.Added), len(report.Removed))
+	}
+
+	entries := make([]models.AuditEntry, 0, len(report.Added)+len(report.Removed))
+	for _, c := range report.Added {
+		log.Printf("Rule %d granted group %d (%s) to user %d", c.RuleID, c.GroupBkey, c.GroupName, c.UserID)
+		entries = append(entries, models.AuditEntry{
+			Action:    audit.ActionAccessGrant,
+			UserID:    c.UserID,
+			UserEmail: c.UserEmail,
+			GroupBkey: c.GroupBkey,
+			GroupName: c.GroupName,
+			After:     fmt.Sprintf("granted by rule %d", c.RuleID),
+		})
+	}
+	for _, c := range report.Removed {
+		log.Printf("Rule %d revoked group %d (%s) from user %d", c.RuleID, c.GroupBkey, c.GroupName, c.UserID)
+		entries = append(entries, models.AuditEntry{
+			Action:    audit.ActionAccessRevoke,
+			UserID:    c.UserID,
+			UserEmail: c.UserEmail,
+			GroupBkey: c.GroupBkey,
+			GroupName: c.GroupName,
+			Before:    fmt.Sprintf("granted by rule %d", c.RuleID),
+		})
+	}
+	h.writeAudit(ctx, actor, ip, entries...)
+
+	return report, nil
+}
+
+// ruleDescription summarises a rule for the audit log
+func ruleDescription(rule models.AccessRule) string {
+	var scope string
+	switch {
+	case rule.Level2Name != "" && rule.Level3Name != "":
+		scope = rule.Level2Name + " / " + rule.Level3Name
+	case rule.Level2Name != "":
+		scope = rule.Level2Name
+	default:
+		scope = rule.Level3Name
+	}
+
+	if rule.RemoveStale {
+		return fmt.Sprintf("rule %d: %s (removes stale access)", rule.ID, scope)
+	}
+	return fmt.Sprintf("rule %d: %s", rule.ID, scope)
+}
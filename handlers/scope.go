@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"powerbi-access-tool/repository"
+)
+
+// groupsInScope reports which of the groups have an object below one of the
+// scope's Level2 units. Without a scope every group is in scope.
+func groupsInScope(ctx context.Context, groupRepo repository.GroupCatalog, scope []string, groupBkeys []int) (map[int]bool, error) {
+	inScope := make(map[int]bool, len(groupBkeys))
+	if len(scope) == 0 {
+		for _, groupBkey := range groupBkeys {
+			inScope[groupBkey] = true
+		}
+		return inScope, nil
+	}
+
+	units, err := groupRepo.Units(ctx, groupBkeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Names are compared case-insensitively, as Search does in SQL Server
+	level2s := make(map[string]bool, len(scope))
+	for _, name := range scope {
+		level2s[strings.ToLower(name)] = true
+	}
+	for groupBkey, groupUnits := range units {
+		for _, u := range groupUnits {
+			if level2s[strings.ToLower(u.Level2Name)] {
+				inScope[groupBkey] = true
+				break
+			}
+		}
+	}
+	return inScope, nil
+}
+
+// outOfScopeError lists the groups an admin may not change
+func outOfScopeError(groupBkeys []int) string {
+	sort.Ints(groupBkeys)
+	keys := make([]string, len(groupBkeys))
+	for i, groupBkey := range groupBkeys {
+		keys[i] = strconv.Itoa(groupBkey)
+	}
+	return "Forbidden: groups outside your Level2 scope: " + strings.Join(keys, ", ")
+}
+
+// unscoped refuses requests from admins limited to Level2 units, for routes
+// that cannot be limited to those units
+func unscoped(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(ScopeFromContext(r.Context())) > 0 {
+			http.Error(w, "Forbidden: not available to admins limited to Level2 units", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
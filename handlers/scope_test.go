@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+
+	"powerbi-access-tool/admin"
+	"powerbi-access-tool/models"
+)
+
+// userGroups returns the group keys the client sees for a user, with their access IDs
+func userGroups(t *testing.T, c *testClient, userID int) map[int]int {
+	t.Helper()
+	status, body := c.get(fmt.Sprintf("/api/users/%d/access", userID))
+	if status != http.StatusOK {
+		t.Fatalf("list access of user %d: %d %s", userID, status, body)
+	}
+	var accessList []models.UserAccess
+	if err := json.Unmarshal([]byte(body), &accessList); err != nil {
+		t.Fatal(err)
+	}
+	groups := make(map[int]int)
+	for _, a := range accessList {
+		groups[a.GroupBkey] = a.UserAccessID
+	}
+	return groups
+}
+
+func TestScopedAdminOnlySearchesGroupsInScope(t *testing.T) {
+	s := newTestServer(t)
+	// Level2 names match regardless of case
+	s.addAdmin(t, "vera", admin.RoleEditor, "regio noord")
+	c := s.newClient(t)
+	c.login("vera")
+
+	status, body := c.get("/api/groups/search?q=Regio")
+	if status != http.StatusOK {
+		t.Fatalf("search: %d %s", status, body)
+	}
+	var results []models.SearchResult
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatal(err)
+	}
+	var found []int
+	for _, r := range results {
+		found = append(found, r.GroupBkey)
+	}
+	sort.Ints(found)
+	if fmt.Sprint(found) != "[1001 1002]" {
+		t.Fatalf("search found %v, want the Regio Noord groups [1001 1002]", found)
+	}
+}
+
+func TestScopedAdminOnlyChangesGroupsInScope(t *testing.T) {
+	s := newTestServer(t)
+	s.addAdmin(t, "ada", admin.RoleAdministrator)
+	s.addAdmin(t, "vera", admin.RoleEditor, "Regio Noord")
+
+	full := s.newClient(t)
+	full.login("ada")
+	if status, body := full.postJSON("/api/users/1/access", `{"groupBkeys":[1003,1005]}`); status != http.StatusOK {
+		t.Fatalf("grant outside the scope as unscoped admin: %d %s", status, body)
+	}
+
+	c := s.newClient(t)
+	c.login("vera")
+
+	if status, body := c.postJSON("/api/users/1/access", `{"groupBkeys":[1001,1004]}`); status != http.StatusForbidden {
+		t.Fatalf("grant including group 1004: %d %s, want 403", status, body)
+	}
+	if status, body := c.postJSON("/api/users/1/access", `{"groupBkeys":[1001]}`); status != http.StatusOK {
+		t.Fatalf("grant of group 1001: %d %s", status, body)
+	}
+
+	// Only the group in scope is listed
+	visible := userGroups(t, c, 1)
+	if len(visible) != 1 || visible[1001] == 0 {
+		t.Fatalf("scoped admin sees %v, want only group 1001", visible)
+	}
+
+	all := userGroups(t, full, 1)
+	if status, _ := c.do(http.MethodDelete, fmt.Sprintf("/api/access/%d", all[1003]), "", ""); status != http.StatusForbidden {
+		t.Errorf("revoke of group 1003: %d, want 403", status)
+	}
+	if status, _ := c.do(http.MethodDelete, fmt.Sprintf("/api/access/%d", all[1001]), "", ""); status != http.StatusNoContent {
+		t.Errorf("revoke of group 1001: %d, want 204", status)
+	}
+	if got := userGroups(t, full, 1); len(got) != 2 || got[1003] == 0 || got[1005] == 0 {
+		t.Errorf("after the scoped changes user 1 has %v, want groups 1003 and 1005", got)
+	}
+}
+
+func TestScopedAdminCannotUseUnscopedRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.addAdmin(t, "vera", admin.RoleEditor, "Regio Noord")
+	c := s.newClient(t)
+	c.login("vera")
+
+	for _, path := range []string{"/api/groups", "/api/access/orphaned", "/api/audit", "/api/roles", "/api/export/access"} {
+		if status, _ := c.get(path); status != http.StatusForbidden {
+			t.Errorf("GET %s: %d, want 403", path, status)
+		}
+	}
+	if status, _ := c.postJSON("/api/users/2/access/copy-from/1", ""); status != http.StatusForbidden {
+		t.Errorf("copy access: %d, want 403", status)
+	}
+}
@@ -24,7 +24,7 @@ func (h *Handler) SearchGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results, err := groupRepo.Search(r.Context(), query)
+	results, err := groupRepo.Search(r.Context(), query, ScopeFromContext(r.Context()))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+
+	"powerbi-access-tool/audit"
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
 )
 
 type CreateUserRequest struct {
@@ -14,6 +18,11 @@ type UpdateUserRequest struct {
 	Email string `json:"email"`
 }
 
+type DeleteUserResponse struct {
+	ID            int `json:"id"`
+	AccessRemoved int `json:"accessRemoved"`
+}
+
 func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	h.mu.RLock()
 	userRepo := h.userRepo
@@ -24,18 +33,43 @@ func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filter := r.URL.Query().Get("filter")
-	sortField := r.URL.Query().Get("sort")
-	sortDir := r.URL.Query().Get("dir")
+	page, limit, err := parsePaging(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := userListOptions(r)
+	opts.Offset = (page - 1) * limit
+	opts.Limit = limit
 
-	users, err := userRepo.List(r.Context(), filter, sortField, sortDir)
+	users, total, err := userRepo.List(r.Context(), opts)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if users == nil {
+		users = []models.User{}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(models.UserPage{
+		Items:   users,
+		Total:   total,
+		Page:    page,
+		Limit:   limit,
+		HasMore: opts.Offset+len(users) < total,
+	})
+}
+
+// userListOptions reads the filter and sort parameters shared by the user list endpoints
+func userListOptions(r *http.Request) repository.UserListOptions {
+	return repository.UserListOptions{
+		Filter:    r.URL.Query().Get("filter"),
+		SortField: r.URL.Query().Get("sort"),
+		SortDir:   r.URL.Query().Get("dir"),
+	}
 }
 
 func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -65,6 +99,13 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.recordAudit(r, models.AuditEntry{
+		Action:    audit.ActionUserCreate,
+		UserID:    id,
+		UserEmail: req.Email,
+		After:     req.Email,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]int{"id": id})
@@ -98,20 +139,41 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before, err := userRepo.GetByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if before == nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
 	if err := userRepo.Update(r.Context(), id, req.Email); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	h.recordAudit(r, models.AuditEntry{
+		Action:    audit.ActionUserUpdate,
+		UserID:    id,
+		UserEmail: req.Email,
+		Before:    before.PowerBIUser,
+		After:     req.Email,
+	})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// DeleteUser removes a user with all their access. Each removed group is
+// audited as a revoke next to the deletion of the user.
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	h.mu.RLock()
 	userRepo := h.userRepo
+	accessRepo := h.accessRepo
 	h.mu.RUnlock()
 
-	if userRepo == nil {
+	if userRepo == nil || accessRepo == nil {
 		http.Error(w, "Database not connected", http.StatusServiceUnavailable)
 		return
 	}
@@ -123,10 +185,30 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := userRepo.Delete(r.Context(), id); err != nil {
+	user, err := userRepo.GetByID(r.Context(), id)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if user == nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
 
-	w.WriteHeader(http.StatusNoContent)
+	accessList, err := accessRepo.ListByUser(r.Context(), id, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessRemoved, err := userRepo.Delete(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.recordAudit(r, audit.UserDeleteEntries(*user, accessList, accessRemoved)...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeleteUserResponse{ID: id, AccessRemoved: accessRemoved})
 }
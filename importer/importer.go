@@ -0,0 +1,324 @@
+// Package importer validates CSV files of users and group assignments and turns
+// them into a plan of user creates and grants.
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+// columns maps accepted header names to the field they fill
+var columns = map[string]string{
+	"email":      "email",
+	"e-mail":     "email",
+	"user":       "email",
+	"group":      "group",
+	"groups":     "group",
+	"groupbkey":  "group",
+	"group_bkey": "group",
+	"level2":     "level2",
+	"level2name": "level2",
+	"level3":     "level3",
+	"level3name": "level3",
+}
+
+// Record is a parsed CSV line before validation
+type Record struct {
+	Line   int
+	Email  string
+	Groups string
+	Level2 string
+	Level3 string
+}
+
+// Parse reads a CSV file with a header row. The separator may be a comma or a
+// semicolon, as written by Excel with Dutch regional settings. The group column
+// may hold several keys separated by spaces, commas, semicolons or pipes.
+func Parse(r io.Reader) ([]Record, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = detectSeparator(text)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("CSV is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV header: %w", err)
+	}
+
+	index := make(map[string]int)
+	for i, name := range header {
+		field, ok := columns[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q; expected email, group, level2 and level3", name)
+		}
+		index[field] = i
+	}
+	if _, ok := index["email"]; !ok {
+		return nil, fmt.Errorf("CSV needs an email column")
+	}
+
+	cell := func(row []string, field string) string {
+		i, ok := index[field]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		rec := Record{
+			Line:   line,
+			Email:  cell(row, "email"),
+			Groups: cell(row, "group"),
+			Level2: cell(row, "level2"),
+			Level3: cell(row, "level3"),
+		}
+		if rec == (Record{Line: line}) {
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+func detectSeparator(text string) rune {
+	header, _, _ := strings.Cut(text, "\n")
+	if strings.Count(header, ";") > strings.Count(header, ",") {
+		return ';'
+	}
+	return ','
+}
+
+// Preview validates every record against the stores and returns the per-row outcome
+// together with the plan that applies it. The plan is only meaningful when the
+// preview has no errors.
+func Preview(ctx context.Context, stores repository.Stores, records []Record) (*models.ImportPreview, *models.Plan, error) {
+	users, _, err := stores.Users.List(ctx, repository.UserListOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	existing := make(map[string]models.User, len(users))
+	for _, u := range users {
+		existing[strings.ToLower(u.PowerBIUser)] = u
+	}
+
+	v := &validator{
+		ctx:      ctx,
+		stores:   stores,
+		existing: existing,
+		granted:  make(map[string]map[int]bool),
+		names:    make(map[int]string),
+		unknown:  make(map[int]bool),
+	}
+
+	// Look up every group key in the file at once instead of per row
+	var groupBkeys []int
+	for _, rec := range records {
+		for _, field := range strings.FieldsFunc(rec.Groups, isKeySeparator) {
+			if groupBkey, err := strconv.Atoi(field); err == nil {
+				groupBkeys = append(groupBkeys, groupBkey)
+			}
+		}
+	}
+	if err := v.loadGroups(groupBkeys); err != nil {
+		return nil, nil, err
+	}
+
+	preview := &models.ImportPreview{Rows: make([]models.ImportRow, 0, len(records))}
+	var creates, grants []models.PlanChange
+	for _, rec := range records {
+		row, err := v.row(rec)
+		if err != nil {
+			return nil, nil, err
+		}
+		preview.Rows = append(preview.Rows, row)
+
+		if len(row.Errors) > 0 {
+			preview.ErrorCount++
+			continue
+		}
+
+		userID := existing[strings.ToLower(row.Email)].PowerBIUserID
+		if row.CreateUser {
+			creates = append(creates, models.PlanChange{Action: models.PlanCreateUser, Email: row.Email})
+		}
+		for _, groupBkey := range row.Grants {
+			grants = append(grants, models.PlanChange{
+				Action:    models.PlanGrant,
+				UserID:    userID,
+				Email:     row.Email,
+				GroupBkey: groupBkey,
+				GroupName: v.names[groupBkey],
+			})
+		}
+	}
+
+	preview.Creates = len(creates)
+	preview.Grants = len(grants)
+	return preview, &models.Plan{Changes: append(creates, grants...)}, nil
+}
+
+// validator keeps the state shared between rows, so a user created or a group
+// granted on an earlier row is not planned twice
+type validator struct {
+	ctx      context.Context
+	stores   repository.Stores
+	existing map[string]models.User
+	granted  map[string]map[int]bool
+	names    map[int]string
+	unknown  map[int]bool
+}
+
+// row validates one record. Problems with the data end up in row.Errors;
+// the returned error is reserved for store failures.
+func (v *validator) row(rec Record) (models.ImportRow, error) {
+	row := models.ImportRow{
+		Line:       rec.Line,
+		Email:      rec.Email,
+		Level2Name: rec.Level2,
+		Level3Name: rec.Level3,
+		GroupBkeys: []int{},
+		Grants:     []int{},
+		Existing:   []int{},
+		Errors:     []string{},
+	}
+
+	if rec.Email == "" {
+		row.Errors = append(row.Errors, "email is missing")
+	} else if !strings.Contains(rec.Email, "@") {
+		row.Errors = append(row.Errors, fmt.Sprintf("%q is not an email address", rec.Email))
+	}
+
+	wanted := make(map[int]bool)
+	for _, field := range strings.FieldsFunc(rec.Groups, isKeySeparator) {
+		groupBkey, err := strconv.Atoi(field)
+		if err != nil {
+			row.Errors = append(row.Errors, fmt.Sprintf("%q is not a group key", field))
+			continue
+		}
+		if err := v.loadGroups([]int{groupBkey}); err != nil {
+			return row, err
+		}
+		if v.unknown[groupBkey] {
+			row.Errors = append(row.Errors, fmt.Sprintf("group %d does not exist", groupBkey))
+			continue
+		}
+		row.GroupBkeys = append(row.GroupBkeys, groupBkey)
+		wanted[groupBkey] = true
+	}
+
+	if rec.Level2 != "" || rec.Level3 != "" {
+		under, err := v.stores.Groups.GroupsUnder(v.ctx, rec.Level2, rec.Level3)
+		if err != nil {
+			return row, err
+		}
+		if len(under) == 0 {
+			row.Errors = append(row.Errors, "no groups found for this unit")
+		}
+		if err := v.loadGroups(under); err != nil {
+			return row, err
+		}
+		for _, groupBkey := range under {
+			wanted[groupBkey] = true
+		}
+	}
+
+	if len(row.Errors) > 0 {
+		return row, nil
+	}
+
+	key := strings.ToLower(rec.Email)
+	held, ok := v.granted[key]
+	if !ok {
+		held = make(map[int]bool)
+		if user, exists := v.existing[key]; exists {
+			access, err := v.stores.Access.ListByUser(v.ctx, user.PowerBIUserID, false)
+			if err != nil {
+				return row, err
+			}
+			for _, a := range access {
+				held[a.GroupBkey] = true
+			}
+		} else {
+			row.CreateUser = true
+		}
+		v.granted[key] = held
+	}
+
+	groupBkeys := make([]int, 0, len(wanted))
+	for groupBkey := range wanted {
+		groupBkeys = append(groupBkeys, groupBkey)
+	}
+	sort.Ints(groupBkeys)
+
+	for _, groupBkey := range groupBkeys {
+		if held[groupBkey] {
+			row.Existing = append(row.Existing, groupBkey)
+			continue
+		}
+		held[groupBkey] = true
+		row.Grants = append(row.Grants, groupBkey)
+	}
+
+	return row, nil
+}
+
+// loadGroups looks up the groups not seen before in one catalog query, caching
+// the names of the groups that exist and the keys of those that do not
+func (v *validator) loadGroups(groupBkeys []int) error {
+	var missing []int
+	for _, groupBkey := range groupBkeys {
+		if _, ok := v.names[groupBkey]; !ok && !v.unknown[groupBkey] {
+			missing = append(missing, groupBkey)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	groups, err := v.stores.Groups.GetByBkeys(v.ctx, missing)
+	if err != nil {
+		return err
+	}
+	for _, groupBkey := range missing {
+		if g, ok := groups[groupBkey]; ok {
+			v.names[groupBkey] = g.GroupName
+		} else {
+			v.unknown[groupBkey] = true
+		}
+	}
+	return nil
+}
+
+func isKeySeparator(r rune) bool {
+	return r == ' ' || r == ',' || r == ';' || r == '|'
+}
@@ -0,0 +1,201 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+// countingCatalog counts the group lookups that reach the catalog
+type countingCatalog struct {
+	repository.GroupCatalog
+	single, batched int
+}
+
+func (c *countingCatalog) GetByBkey(ctx context.Context, groupBkey int) (*models.Group, error) {
+	c.single++
+	return c.GroupCatalog.GetByBkey(ctx, groupBkey)
+}
+
+func (c *countingCatalog) GetByBkeys(ctx context.Context, groupBkeys []int) (map[int]models.Group, error) {
+	c.batched++
+	return c.GroupCatalog.GetByBkeys(ctx, groupBkeys)
+}
+
+func demoStores() repository.Stores {
+	db := repository.NewMemoryDB()
+	db.SeedDemo()
+	return repository.NewMemoryStores(db)
+}
+
+func TestPreviewLooksUpGroupsOnce(t *testing.T) {
+	stores := demoStores()
+	catalog := &countingCatalog{GroupCatalog: stores.Groups}
+	stores.Groups = catalog
+
+	records, err := Parse(strings.NewReader("email;group\n" +
+		"a@voorbeeld.nl;1001 1002\n" +
+		"b@voorbeeld.nl;1002,1003\n" +
+		"c@voorbeeld.nl;1004|9999\n" +
+		"d@voorbeeld.nl;1001\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, _, err := Preview(context.Background(), stores, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.ErrorCount != 1 {
+		t.Errorf("preview has %d errors, want 1 for group 9999", preview.ErrorCount)
+	}
+	if catalog.single != 0 || catalog.batched != 1 {
+		t.Errorf("preview made %d single and %d batched group lookups, want one batched lookup", catalog.single, catalog.batched)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []Record
+		wantErr string
+	}{
+		{
+			name: "comma separated",
+			csv:  "email,group\nanna@voorbeeld.nl,1001\n",
+			want: []Record{{Line: 2, Email: "anna@voorbeeld.nl", Groups: "1001"}},
+		},
+		{
+			name: "semicolons, byte order mark and header aliases",
+			csv:  "\ufeffE-mail; Groups ;Level2Name;level3\nanna@voorbeeld.nl; 1001 1002 ;Regio Noord;Verkoop\n",
+			want: []Record{{Line: 2, Email: "anna@voorbeeld.nl", Groups: "1001 1002", Level2: "Regio Noord", Level3: "Verkoop"}},
+		},
+		{
+			name: "blank and short rows",
+			csv:  "email;group;level2\n;;\nbert@voorbeeld.nl\n",
+			want: []Record{{Line: 3, Email: "bert@voorbeeld.nl"}},
+		},
+		{
+			name:    "empty file",
+			csv:     "",
+			wantErr: "CSV is empty",
+		},
+		{
+			name:    "unknown column",
+			csv:     "email;afdeling\n",
+			wantErr: `unknown column "afdeling"`,
+		},
+		{
+			name:    "no email column",
+			csv:     "group\n1001\n",
+			wantErr: "CSV needs an email column",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := Parse(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parse: %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := fmt.Sprintf("%+v", records), fmt.Sprintf("%+v", tt.want); got != want {
+				t.Errorf("records %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// importRow formats the outcome of a previewed row
+func importRow(row models.ImportRow) string {
+	if len(row.Errors) > 0 {
+		return strings.Join(row.Errors, "; ")
+	}
+	return fmt.Sprintf("create=%v grants=%v existing=%v", row.CreateUser, row.Grants, row.Existing)
+}
+
+func TestPreviewRows(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want []string
+	}{
+		{
+			name: "new and existing users",
+			csv: "email;group\n" +
+				"anna@voorbeeld.nl;1001 1002\n" +
+				"nieuw@voorbeeld.nl;1003\n",
+			want: []string{
+				"create=false grants=[1002] existing=[1001]",
+				"create=true grants=[1003] existing=[]",
+			},
+		},
+		{
+			name: "earlier rows count as granted",
+			csv: "email;group\n" +
+				"nieuw@voorbeeld.nl;1003\n" +
+				"NIEUW@voorbeeld.nl;1003,1004\n",
+			want: []string{
+				"create=true grants=[1003] existing=[]",
+				"create=false grants=[1004] existing=[1003]",
+			},
+		},
+		{
+			name: "units expand to their groups",
+			csv: "email;group;level2;level3\n" +
+				"bert@voorbeeld.nl;1005;Regio Zuid;\n" +
+				"bert@voorbeeld.nl;;Regio Noord;Inkoop\n",
+			want: []string{
+				"create=false grants=[1003 1004 1005] existing=[]",
+				"create=false grants=[1002] existing=[]",
+			},
+		},
+		{
+			name: "invalid rows",
+			csv: "email;group;level2\n" +
+				";1001;\n" +
+				"geen-email;1001;\n" +
+				"bert@voorbeeld.nl;abc|9999;\n" +
+				"bert@voorbeeld.nl;;Regio West\n",
+			want: []string{
+				"email is missing",
+				`"geen-email" is not an email address`,
+				`"abc" is not a group key; group 9999 does not exist`,
+				"no groups found for this unit",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			stores := demoStores()
+			if err := stores.Access.AddGroups(ctx, 1, []int{1001}); err != nil {
+				t.Fatal(err)
+			}
+
+			records, err := Parse(strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			preview, _, err := Preview(ctx, stores, records)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range preview.Rows {
+				got = append(got, importRow(row))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("rows:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -10,16 +10,27 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"powerbi-access-tool/admin"
+	"powerbi-access-tool/cli"
 	"powerbi-access-tool/config"
 	"powerbi-access-tool/db"
 	"powerbi-access-tool/handlers"
+	"powerbi-access-tool/oidc"
 	"powerbi-access-tool/repository"
 )
 
+const (
+	expirySweepInterval   = 5 * time.Minute
+	ruleReconcileInterval = 15 * time.Minute
+)
+
 func main() {
-	// Log security configuration
-	logSecurityConfig()
+	// Subcommands run against the same store and exit without starting the server
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
 
 	// Load config
 	cfg, err := config.Load()
@@ -27,45 +38,30 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	var database *sql.DB
+	// Log security configuration
+	logSecurityConfig(cfg)
 
-	// Only connect if credentials are configured
-	if cfg.Username != "" && cfg.Password != "" {
-		log.Printf("Connecting to database %s on %s...", cfg.Database, cfg.Server)
-
-		database, err = db.Open(db.Config{
-			Server:   cfg.Server,
-			Database: cfg.Database,
-			Username: cfg.Username,
-			Password: cfg.Password,
-		})
-		if err != nil {
-			log.Printf("Warning: Failed to connect to database: %v", err)
-			log.Println("Start the application and configure credentials in Settings")
-		} else {
+	var h *handlers.Handler
+
+	if os.Getenv("POWERBI_STORE") == "memory" {
+		h, err = setupMemoryHandler(cfg)
+	} else {
+		database := connectDatabase(cfg)
+		if database != nil {
 			defer database.Close()
-			log.Println("Connected to database successfully")
 		}
-	} else {
-		log.Println("No database credentials configured. Please configure in Settings.")
+		h, err = setupDatabaseHandler(database, cfg)
 	}
-
-	// Setup repositories (may be nil if no database connection)
-	var userRepo *repository.UserRepository
-	var accessRepo *repository.AccessRepository
-	var groupRepo *repository.GroupRepository
-
-	if database != nil {
-		userRepo = repository.NewUserRepository(database)
-		accessRepo = repository.NewAccessRepository(database)
-		groupRepo = repository.NewGroupRepository(database)
+	if err != nil {
+		log.Fatalf("Failed to setup handlers: %v", err)
 	}
 
-	// Setup handlers
-	h, err := handlers.NewHandler(database, userRepo, accessRepo, groupRepo, cfg)
+	// Only these proxies may report the client address in X-Forwarded-For
+	proxies, err := handlers.ParseTrustedProxies(os.Getenv("POWERBI_TRUSTED_PROXIES"))
 	if err != nil {
-		log.Fatalf("Failed to setup handlers: %v", err)
+		log.Fatalf("Invalid POWERBI_TRUSTED_PROXIES: %v", err)
 	}
+	h.TrustProxies(proxies)
 
 	// Setup router
 	router := handlers.SetupRoutes(h)
@@ -84,9 +80,23 @@ func main() {
 
 	fmt.Printf("Server running at http://localhost:%d\n", actualPort)
 
+	mockIssuer, err := setupOIDC(h, actualPort)
+	if err != nil {
+		log.Fatalf("Failed to set up OIDC sign-in: %v", err)
+	}
+	if mockIssuer != nil {
+		defer mockIssuer.Close()
+	}
+
 	// Setup server
 	server := &http.Server{Handler: router}
 
+	// Revoke time-limited grants once they expire
+	sweepCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	go h.RunExpirySweeper(sweepCtx, expirySweepInterval)
+	go h.RunRuleReconciler(sweepCtx, ruleReconcileInterval)
+
 	// Start server in goroutine
 	go func() {
 		if err := server.Serve(listener); err != http.ErrServerClosed {
@@ -105,16 +115,144 @@ func main() {
 	}
 }
 
-func logSecurityConfig() {
+// setupOIDC enables sign-in with OpenID Connect when POWERBI_OIDC_* is configured.
+// POWERBI_OIDC_MOCK starts the in-process mock issuer on the given address, such
+// as 127.0.0.1:0, so the flow can be tried without Entra ID.
+func setupOIDC(h *handlers.Handler, port int) (*oidc.MockIssuer, error) {
+	oidcCfg, enabled := oidc.ConfigFromEnv()
+
+	var mockIssuer *oidc.MockIssuer
+	if addr := os.Getenv("POWERBI_OIDC_MOCK"); addr != "" {
+		var err error
+		mockIssuer, err = oidc.StartMockIssuer(addr)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("OIDC: mock issuer running at %s (for testing only)", mockIssuer.URL)
+
+		oidcCfg.Issuer = mockIssuer.URL
+		if oidcCfg.ClientID == "" {
+			oidcCfg.ClientID = "powerbi-access-tool"
+		}
+		if oidcCfg.RedirectURL == "" {
+			oidcCfg.RedirectURL = fmt.Sprintf("http://localhost:%d/login/oidc/callback", port)
+		}
+		if len(oidcCfg.AllowValues)+len(oidcCfg.EditorValues)+len(oidcCfg.AdministratorValues) == 0 {
+			oidcCfg.AllowValues = []string{oidc.MockViewerGroup}
+			oidcCfg.EditorValues = []string{oidc.MockEditorGroup}
+			oidcCfg.AdministratorValues = []string{oidc.MockAdminGroup}
+		}
+		if len(oidcCfg.Level2Scopes) == 0 {
+			oidcCfg.EditorValues = append(oidcCfg.EditorValues, oidc.MockScopedGroup)
+			oidcCfg.Level2Scopes = map[string][]string{oidc.MockScopedGroup: {oidc.MockScopedLevel2}}
+		}
+		enabled = true
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	provider, err := oidc.NewProvider(*oidcCfg)
+	if err != nil {
+		if mockIssuer != nil {
+			mockIssuer.Close()
+		}
+		return nil, err
+	}
+	h.EnableOIDC(provider)
+	log.Printf("Authentication: OIDC sign-in ENABLED (issuer %s)", provider.Issuer())
+
+	return mockIssuer, nil
+}
+
+// connectDatabase opens the SQL Server connection when credentials are configured.
+// A failed connection is logged and returns nil so the user can fix it in Settings.
+// So is a failure to create the side tables, as the access queries join them.
+func connectDatabase(cfg *config.Config) *sql.DB {
+	// Only connect if credentials are configured
+	if cfg.Username == "" || cfg.Password == "" {
+		log.Println("No database credentials configured. Please configure in Settings.")
+		return nil
+	}
+
+	log.Printf("Connecting to database %s on %s...", cfg.Database, cfg.Server)
+
+	database, err := db.Open(db.Config{
+		Server:   cfg.Server,
+		Database: cfg.Database,
+		Username: cfg.Username,
+		Password: cfg.Password,
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to connect to database: %v", err)
+		log.Println("Start the application and configure credentials in Settings")
+		return nil
+	}
+
+	if err := repository.EnsureSchema(context.Background(), database); err != nil {
+		database.Close()
+		log.Printf("Warning: %v", err)
+		log.Println("The database login needs rights to create the powerbi side tables, or an administrator must create them")
+		return nil
+	}
+
+	log.Println("Connected to database successfully")
+	return database
+}
+
+func setupDatabaseHandler(database *sql.DB, cfg *config.Config) (*handlers.Handler, error) {
+	// Setup repositories (empty if no database connection)
+	var stores repository.Stores
+
+	if database != nil {
+		stores = repository.NewSQLStores(database)
+	}
+
+	return handlers.NewHandler(database, stores, cfg)
+}
+
+// setupMemoryHandler runs the application against an in-memory store,
+// seeded from POWERBI_MEMORY_SEED or with demo data
+func setupMemoryHandler(cfg *config.Config) (*handlers.Handler, error) {
+	seedPath := os.Getenv("POWERBI_MEMORY_SEED")
+	memDB, err := repository.OpenMemoryDB(seedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if seedPath != "" {
+		log.Printf("Using in-memory store seeded from %s", seedPath)
+	} else {
+		log.Println("Using in-memory store with demo data")
+	}
+
+	return handlers.NewMemoryHandler(memDB, cfg)
+}
+
+func logSecurityConfig(cfg *config.Config) {
 	if _, hasKey := config.GetMasterKey(); hasKey {
 		log.Println("Config encryption: ENABLED (POWERBI_MASTER_KEY set)")
 	} else {
 		log.Println("Config encryption: DISABLED (set POWERBI_MASTER_KEY for encryption)")
 	}
 
-	if _, hasPassword := handlers.GetAdminPassword(); hasPassword {
-		log.Println("Authentication: ENABLED (POWERBI_ADMIN_PASSWORD set)")
-	} else {
-		log.Println("Authentication: DISABLED (set POWERBI_ADMIN_PASSWORD to enable)")
+	adminsPath, err := cfg.AdminsFilePath()
+	if err != nil {
+		log.Printf("Authentication: admin accounts unavailable: %v", err)
+		return
+	}
+	count, err := admin.NewStore(adminsPath).Count()
+	if err != nil {
+		log.Printf("Authentication: ENABLED (failed to read %s: %v)", adminsPath, err)
+		return
+	}
+
+	if count > 0 {
+		log.Printf("Authentication: ENABLED (%d admin accounts in %s)", count, adminsPath)
+	} else if _, hasPassword := handlers.GetAdminPassword(); hasPassword {
+		log.Println("Authentication: ENABLED (POWERBI_ADMIN_PASSWORD set, log in as \"admin\")")
+	} else if _, oidcEnabled := oidc.ConfigFromEnv(); !oidcEnabled && os.Getenv("POWERBI_OIDC_MOCK") == "" {
+		log.Println("Authentication: DISABLED (add an account with \"admins add\" or configure OIDC to enable)")
 	}
 }
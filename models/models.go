@@ -1,27 +1,326 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type User struct {
 	PowerBIUserID int    `json:"id"`
 	PowerBIUser   string `json:"email"`
 }
 
+// UserPage is one page of a user listing
+type UserPage struct {
+	Items   []User `json:"items"`
+	Total   int    `json:"total"`
+	Page    int    `json:"page"`
+	Limit   int    `json:"limit"`
+	HasMore bool   `json:"hasMore"`
+}
+
 type UserAccess struct {
+	UserAccessID int        `json:"id"`
+	UserID       int        `json:"userId"`
+	GroupBkey    int        `json:"groupBkey"`
+	GroupName    string     `json:"groupName"`
+	CreationDate time.Time  `json:"creationDate"`
+	ValidUntil   *time.Time `json:"validUntil,omitempty"`
+	Orphaned     bool       `json:"orphaned,omitempty"`
+}
+
+// OrphanedAccess is an access record whose group no longer exists in dim.[Group]
+type OrphanedAccess struct {
 	UserAccessID int       `json:"id"`
 	UserID       int       `json:"userId"`
+	UserEmail    string    `json:"userEmail"`
 	GroupBkey    int       `json:"groupBkey"`
-	GroupName    string    `json:"groupName"`
 	CreationDate time.Time `json:"creationDate"`
 }
 
+// GroupMember is a user that has access to a group
+type GroupMember struct {
+	UserAccessID int        `json:"accessId"`
+	UserID       int        `json:"userId"`
+	Email        string     `json:"email"`
+	CreationDate time.Time  `json:"creationDate"`
+	ValidUntil   *time.Time `json:"validUntil,omitempty"`
+}
+
 type Group struct {
 	GroupBkey int    `json:"groupBkey"`
 	GroupName string `json:"groupName"`
 }
 
+// GroupSummary is a group with its number of members and objects
+type GroupSummary struct {
+	GroupBkey   int    `json:"groupBkey"`
+	GroupName   string `json:"groupName"`
+	MemberCount int    `json:"memberCount"`
+	ObjectCount int    `json:"objectCount"`
+}
+
+// GroupPage is one page of the group catalog
+type GroupPage struct {
+	Items   []GroupSummary `json:"items"`
+	Total   int            `json:"total"`
+	Page    int            `json:"page"`
+	Limit   int            `json:"limit"`
+	HasMore bool           `json:"hasMore"`
+}
+
+// TreeNode is a node in the dim.[Object] hierarchy (Level1 → Level2 → Level3 → Group).
+// GrantedCount is the number of groups below the node the selected user already has.
+type TreeNode struct {
+	Level        string `json:"level"`
+	Name         string `json:"name"`
+	GroupBkey    int    `json:"groupBkey,omitempty"`
+	GroupCount   int    `json:"groupCount"`
+	GrantedCount int    `json:"grantedCount"`
+}
+
+// SearchResult is a group found by a search. Match is the best match quality
+// ("exact", "prefix" or "contains") over all matched columns.
 type SearchResult struct {
+	GroupBkey      int      `json:"groupBkey"`
+	GroupName      string   `json:"groupName"`
+	Match          string   `json:"match"`
+	MatchedOn      []string `json:"matchedOn"`
+	MatchedObjects []string `json:"matchedObjects"`
+}
+
+type Object struct {
+	ObjectName string `json:"objectName"`
+	Level1Name string `json:"level1Name"`
+	Level2Name string `json:"level2Name"`
+	Level3Name string `json:"level3Name"`
+	GroupBkey  int    `json:"groupBkey"`
+}
+
+// Grant requests access to one group, optionally until a given time
+type Grant struct {
+	GroupBkey  int        `json:"groupBkey"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+}
+
+// PermanentGrants turns group keys into grants without an expiry
+func PermanentGrants(groupBkeys []int) []Grant {
+	grants := make([]Grant, len(groupBkeys))
+	for i, groupBkey := range groupBkeys {
+		grants[i] = Grant{GroupBkey: groupBkey}
+	}
+	return grants
+}
+
+// GrantResult reports the outcome of granting a set of groups to a user
+type GrantResult struct {
+	Added   []int `json:"added"`
+	Skipped []int `json:"skipped"`
+	Unknown []int `json:"unknown"`
+}
+
+// AuditEntry records a single change made through the tool
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	UserID    int       `json:"userId,omitempty"`
+	UserEmail string    `json:"userEmail,omitempty"`
+	GroupBkey int       `json:"groupBkey,omitempty"`
+	GroupName string    `json:"groupName,omitempty"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+	ClientIP  string    `json:"clientIp,omitempty"`
+}
+
+// AccessRule gives a user every group below a Level2 unit, a Level3 unit, or a
+// Level3 unit within a Level2 unit. Empty names are not filtered on.
+type AccessRule struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"userId"`
+	UserEmail    string    `json:"userEmail"`
+	Level2Name   string    `json:"level2Name"`
+	Level3Name   string    `json:"level3Name"`
+	RemoveStale  bool      `json:"removeStale"`
+	CreationDate time.Time `json:"creationDate"`
+}
+
+// ReconcileChange is one access record added or removed by the rule reconciler
+type ReconcileChange struct {
+	RuleID    int    `json:"ruleId"`
+	UserID    int    `json:"userId"`
+	UserEmail string `json:"userEmail"`
+	GroupBkey int    `json:"groupBkey"`
+	GroupName string `json:"groupName"`
+}
+
+// ReconcileReport describes what a reconciler run changed, or would change on a dry run
+type ReconcileReport struct {
+	StartedAt time.Time         `json:"startedAt"`
+	DryRun    bool              `json:"dryRun"`
+	Rules     int               `json:"rules"`
+	Added     []ReconcileChange `json:"added"`
+	Removed   []ReconcileChange `json:"removed"`
+}
+
+// Actions in a desired-state plan
+const (
+	PlanCreateUser = "create"
+	PlanGrant      = "grant"
+	PlanRevoke     = "revoke"
+	PlanDeleteUser = "delete"
+)
+
+// PlanChange is one step needed to bring the database in line with a desired-state file.
+// UserID is 0 for users the plan creates; AccessID is only set for revokes and
+// ValidUntil only for grants that expire.
+type PlanChange struct {
+	Action     string     `json:"action"`
+	UserID     int        `json:"userId,omitempty"`
+	Email      string     `json:"email"`
+	AccessID   int        `json:"accessId,omitempty"`
+	GroupBkey  int        `json:"groupBkey,omitempty"`
+	GroupName  string     `json:"groupName,omitempty"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+}
+
+// Plan lists the changes in the order they are applied: creates, grants, revokes, deletes
+type Plan struct {
+	Changes []PlanChange `json:"changes"`
+}
+
+// CreatedUsers maps the lowercased email of each user a plan created to its new ID
+type CreatedUsers map[string]int
+
+// UserID returns the ID of the user a change is for, including users the plan created
+func (u CreatedUsers) UserID(c PlanChange) int {
+	if c.UserID != 0 {
+		return c.UserID
+	}
+	return u[strings.ToLower(c.Email)]
+}
+
+// ImportRow is one validated line of a CSV import. Grants are the groups this line
+// adds; Existing are groups the user already has or gets from an earlier line.
+type ImportRow struct {
+	Line       int      `json:"line"`
+	Email      string   `json:"email"`
+	GroupBkeys []int    `json:"groupBkeys"`
+	Level2Name string   `json:"level2Name,omitempty"`
+	Level3Name string   `json:"level3Name,omitempty"`
+	CreateUser bool     `json:"createUser"`
+	Grants     []int    `json:"grants"`
+	Existing   []int    `json:"existing"`
+	Errors     []string `json:"errors"`
+}
+
+// ImportPreview is the outcome of validating a CSV import. Nothing is applied
+// while any row has errors.
+type ImportPreview struct {
+	Rows       []ImportRow `json:"rows"`
+	Creates    int         `json:"creates"`
+	Grants     int         `json:"grants"`
+	ErrorCount int         `json:"errorCount"`
+	Applied    bool        `json:"applied"`
+}
+
+// AccessExportRow is one line of the access export: a user, one of their groups
+// and a Level2/Level3 unit the group belongs to
+type AccessExportRow struct {
+	UserID       int        `json:"userId"`
+	Email        string     `json:"email"`
+	GroupBkey    int        `json:"groupBkey"`
+	GroupName    string     `json:"groupName"`
+	Level2Name   string     `json:"level2Name"`
+	Level3Name   string     `json:"level3Name"`
+	CreationDate time.Time  `json:"creationDate"`
+	ValidUntil   *time.Time `json:"validUntil,omitempty"`
+}
+
+// Copy modes: merge adds the source user's groups, replace also revokes the
+// target user's groups the source user does not have
+const (
+	CopyMerge   = "merge"
+	CopyReplace = "replace"
+)
+
+// AccessCopy is the difference between two users' access when copying from
+// SourceID to TargetID. Add holds the source user's records, Remove and
+// Unchanged the target user's.
+type AccessCopy struct {
+	SourceID  int          `json:"sourceId"`
+	TargetID  int          `json:"targetId"`
+	Mode      string       `json:"mode"`
+	DryRun    bool         `json:"dryRun"`
+	Add       []UserAccess `json:"add"`
+	Remove    []UserAccess `json:"remove"`
+	Unchanged []UserAccess `json:"unchanged"`
+}
+
+// Role is a named set of groups. Assigning it to a user grants all of them.
+type Role struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	Groups       []Group   `json:"groups"`
+	MemberCount  int       `json:"memberCount"`
+	CreationDate time.Time `json:"creationDate"`
+}
+
+// GroupBkeys returns the keys of the role's groups
+func (r Role) GroupBkeys() []int {
+	keys := make([]int, len(r.Groups))
+	for i, g := range r.Groups {
+		keys[i] = g.GroupBkey
+	}
+	return keys
+}
+
+// RoleMember is a user a role is assigned to
+type RoleMember struct {
+	UserID     int       `json:"userId"`
+	Email      string    `json:"email"`
+	AssignedAt time.Time `json:"assignedAt"`
+}
+
+// RoleChange is a group granted to or revoked from a user because of a role
+type RoleChange struct {
+	RoleID    int    `json:"roleId"`
+	UserID    int    `json:"userId"`
+	UserEmail string `json:"userEmail"`
 	GroupBkey int    `json:"groupBkey"`
 	GroupName string `json:"groupName"`
-	MatchedOn string `json:"matchedOn"`
+}
+
+// RoleSync reports the access changes made to bring role members in line with their roles
+type RoleSync struct {
+	Added   []RoleChange `json:"added"`
+	Removed []RoleChange `json:"removed"`
+}
+
+// OrgUnit is a Level2/Level3 unit from dim.[Object]
+type OrgUnit struct {
+	Level2Name string `json:"level2Name"`
+	Level3Name string `json:"level3Name"`
+}
+
+// ComparedGroup is a group in an access comparison, with the units it belongs to
+// and each user's expiry
+type ComparedGroup struct {
+	GroupBkey   int        `json:"groupBkey"`
+	GroupName   string     `json:"groupName"`
+	Units       []OrgUnit  `json:"units"`
+	ValidUntilA *time.Time `json:"validUntilA,omitempty"`
+	ValidUntilB *time.Time `json:"validUntilB,omitempty"`
+}
+
+// AccessComparison splits the groups of users A and B into those only one of
+// them has and those both have
+type AccessComparison struct {
+	A     User            `json:"a"`
+	B     User            `json:"b"`
+	OnlyA []ComparedGroup `json:"onlyA"`
+	OnlyB []ComparedGroup `json:"onlyB"`
+	Both  []ComparedGroup `json:"both"`
 }
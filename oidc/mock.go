@@ -0,0 +1,295 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MockDefaultUser is suggested on the mock issuer's sign-in page, with the
+// administrators group. The groups are what the mock setup allows per role;
+// MockScopedGroup makes an editor limited to MockScopedLevel2.
+const (
+	MockDefaultUser  = "beheerder@voorbeeld.nl"
+	MockAdminGroup   = "powerbi-admins"
+	MockEditorGroup  = "powerbi-editors"
+	MockViewerGroup  = "powerbi-viewers"
+	MockScopedGroup  = "powerbi-regio-noord"
+	MockScopedLevel2 = "Regio Noord"
+)
+
+const (
+	mockKeyID    = "mock-key"
+	mockCodeTTL  = 2 * time.Minute
+	mockTokenTTL = time.Hour
+)
+
+// MockIssuer is a minimal OpenID Connect issuer for trying the login flow
+// offline. Its sign-in page accepts any username and groups, and it implements
+// discovery, JWKS, the authorization code flow with PKCE and end session.
+type MockIssuer struct {
+	URL string
+
+	key    *rsa.PrivateKey
+	server *http.Server
+
+	mu    sync.Mutex
+	codes map[string]mockCode
+}
+
+// mockCode is an issued authorization code waiting to be redeemed
+type mockCode struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	username    string
+	groups      []string
+	expiry      time.Time
+}
+
+// StartMockIssuer listens on addr, such as "127.0.0.1:0", and serves the mock issuer
+func StartMockIssuer(addr string) (*MockIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MockIssuer{
+		URL:   "http://" + listener.Addr().String(),
+		key:   key,
+		codes: make(map[string]mockCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /keys", m.jwks)
+	mux.HandleFunc("GET /authorize", m.authorizePage)
+	mux.HandleFunc("POST /authorize", m.authorize)
+	mux.HandleFunc("POST /token", m.token)
+	mux.HandleFunc("GET /logout", m.logout)
+
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(listener)
+
+	return m, nil
+}
+
+// Close stops the mock issuer
+func (m *MockIssuer) Close() error {
+	return m.server.Close()
+}
+
+func (m *MockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.URL,
+		"authorization_endpoint":                m.URL + "/authorize",
+		"token_endpoint":                        m.URL + "/token",
+		"jwks_uri":                              m.URL + "/keys",
+		"end_session_endpoint":                  m.URL + "/logout",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (m *MockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := m.key.PublicKey
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": mockKeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var mockLoginPage = template.Must(template.New("mock").Parse(`<!DOCTYPE html>
+<html lang="nl">
+<head><meta charset="UTF-8"><title>Mock identity provider</title></head>
+<body style="font-family: sans-serif; max-width: 28rem; margin: 3rem auto;">
+<h1>Mock identity provider</h1>
+<p>Alleen voor testen. Elke gebruikersnaam wordt geaccepteerd.</p>
+<form method="POST" action="/authorize">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<p><label>Gebruikersnaam<br><input name="username" value="{{.User}}" size="40"></label></p>
+<p><label>Groepen (komma-gescheiden)<br><input name="groups" value="{{.Group}}" size="40"></label></p>
+<p><button type="submit">Inloggen</button></p>
+</form>
+</body>
+</html>`))
+
+// mockAuthParams are the authorization request parameters carried through the sign-in page
+var mockAuthParams = []string{"client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method", "response_type", "scope"}
+
+func (m *MockIssuer) authorizePage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if msg := checkMockAuthRequest(q); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	params := make(map[string]string)
+	for _, name := range mockAuthParams {
+		params[name] = q.Get(name)
+	}
+	data := struct {
+		Params map[string]string
+		User   string
+		Group  string
+	}{params, MockDefaultUser, MockAdminGroup}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	mockLoginPage.Execute(w, data)
+}
+
+func (m *MockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	if msg := checkMockAuthRequest(r.PostForm); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	if username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	code := randomMockValue()
+	m.mu.Lock()
+	m.codes[code] = mockCode{
+		clientID:    r.PostForm.Get("client_id"),
+		redirectURI: r.PostForm.Get("redirect_uri"),
+		nonce:       r.PostForm.Get("nonce"),
+		challenge:   r.PostForm.Get("code_challenge"),
+		username:    username,
+		groups:      splitList(r.PostForm.Get("groups")),
+		expiry:      time.Now().Add(mockCodeTTL),
+	}
+	m.mu.Unlock()
+
+	q := url.Values{"code": {code}, "state": {r.PostForm.Get("state")}}
+	http.Redirect(w, r, withQuery(r.PostForm.Get("redirect_uri"), q), http.StatusSeeOther)
+}
+
+func checkMockAuthRequest(q url.Values) string {
+	switch {
+	case q.Get("response_type") != "code":
+		return "response_type must be code"
+	case q.Get("client_id") == "":
+		return "client_id is required"
+	case q.Get("redirect_uri") == "":
+		return "redirect_uri is required"
+	case q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256":
+		return "PKCE with S256 is required"
+	}
+	return ""
+}
+
+func (m *MockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeMockError(w, "unsupported_grant_type")
+		return
+	}
+
+	// Codes can be redeemed once
+	m.mu.Lock()
+	code, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	switch {
+	case !ok || time.Now().After(code.expiry):
+		writeMockError(w, "invalid_grant")
+		return
+	case r.PostForm.Get("client_id") != code.clientID:
+		writeMockError(w, "invalid_client")
+		return
+	case r.PostForm.Get("redirect_uri") != code.redirectURI:
+		writeMockError(w, "invalid_grant")
+		return
+	case codeChallenge(r.PostForm.Get("code_verifier")) != code.challenge:
+		writeMockError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	sub := sha256.Sum256([]byte(code.username))
+	claims := jwt.MapClaims{
+		"iss":                m.URL,
+		"sub":                hex.EncodeToString(sub[:16]),
+		"aud":                code.clientID,
+		"iat":                now.Unix(),
+		"nbf":                now.Unix(),
+		"exp":                now.Add(mockTokenTTL).Unix(),
+		"nonce":              code.nonce,
+		"name":               code.username,
+		"preferred_username": code.username,
+		"groups":             code.groups,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = mockKeyID
+
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		writeMockError(w, "server_error")
+		return
+	}
+
+	writeMockJSON(w, http.StatusOK, map[string]interface{}{
+		"token_type":   "Bearer",
+		"access_token": randomMockValue(),
+		"id_token":     signed,
+		"expires_in":   int(mockTokenTTL.Seconds()),
+	})
+}
+
+func (m *MockIssuer) logout(w http.ResponseWriter, r *http.Request) {
+	if target := r.URL.Query().Get("post_logout_redirect_uri"); target != "" {
+		http.Redirect(w, r, target, http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("Uitgelogd bij de mock identity provider.\n"))
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeMockError(w http.ResponseWriter, code string) {
+	writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func randomMockValue() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
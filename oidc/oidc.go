@@ -0,0 +1,520 @@
+// Package oidc implements an OpenID Connect relying party for the authorization
+// code flow with PKCE, as used to sign in with Microsoft Entra ID. ID tokens are
+// checked against the issuer's signing keys and an allowlist of claim values.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"powerbi-access-tool/admin"
+)
+
+const (
+	// clockSkew is the leeway allowed on exp, nbf and iat
+	clockSkew = time.Minute
+	// keyRefreshInterval limits how often an unknown key ID refetches the JWKS
+	keyRefreshInterval = time.Minute
+)
+
+var (
+	// ErrNotAllowed is returned for valid tokens whose claims are not on the allowlist
+	ErrNotAllowed = errors.New("account is not allowed to administer this tool")
+)
+
+// Config describes the client registration at the issuer. AllowClaim is the
+// claim checked against the allowlists, such as "groups" with Entra group object
+// IDs or "roles" with app role names. AllowValues let an account in as viewer;
+// EditorValues and AdministratorValues let it in with that role. Level2Scopes
+// maps values of the same claim to the Level2 units an account holding them
+// is limited to, like a scoped local account.
+type Config struct {
+	Issuer                string
+	ClientID              string
+	ClientSecret          string
+	RedirectURL           string
+	PostLogoutRedirectURL string
+	Scopes                []string
+	AllowClaim            string
+	AllowValues           []string
+	EditorValues          []string
+	AdministratorValues   []string
+	Level2Scopes          map[string][]string
+}
+
+// ConfigFromEnv reads the POWERBI_OIDC_* variables. OIDC is enabled when an
+// issuer or client ID is set; Validate reports what is missing.
+func ConfigFromEnv() (*Config, bool) {
+	cfg := &Config{
+		Issuer:                strings.TrimRight(os.Getenv("POWERBI_OIDC_ISSUER"), "/"),
+		ClientID:              os.Getenv("POWERBI_OIDC_CLIENT_ID"),
+		ClientSecret:          os.Getenv("POWERBI_OIDC_CLIENT_SECRET"),
+		RedirectURL:           os.Getenv("POWERBI_OIDC_REDIRECT_URL"),
+		PostLogoutRedirectURL: os.Getenv("POWERBI_OIDC_POST_LOGOUT_URL"),
+		Scopes:                splitList(os.Getenv("POWERBI_OIDC_SCOPES")),
+		AllowClaim:            os.Getenv("POWERBI_OIDC_ALLOW_CLAIM"),
+		AllowValues:           splitList(os.Getenv("POWERBI_OIDC_ALLOW_VALUES")),
+		EditorValues:          splitList(os.Getenv("POWERBI_OIDC_EDITOR_VALUES")),
+		AdministratorValues:   splitList(os.Getenv("POWERBI_OIDC_ADMINISTRATOR_VALUES")),
+		Level2Scopes:          parseLevel2Scopes(os.Getenv("POWERBI_OIDC_LEVEL2_SCOPES")),
+	}
+	return cfg, cfg.Issuer != "" || cfg.ClientID != ""
+}
+
+// parseLevel2Scopes reads "value=Level2 name" pairs separated by semicolons.
+// Level2 names may hold spaces; a value listed twice is limited to both units.
+func parseLevel2Scopes(s string) map[string][]string {
+	scopes := make(map[string][]string)
+	for _, pair := range strings.Split(s, ";") {
+		value, level2, ok := strings.Cut(pair, "=")
+		value, level2 = strings.TrimSpace(value), strings.TrimSpace(level2)
+		if ok && value != "" && level2 != "" {
+			scopes[strings.ToLower(value)] = append(scopes[strings.ToLower(value)], level2)
+		}
+	}
+	return scopes
+}
+
+// Validate checks that the configuration is complete. An empty allowlist is
+// rejected so a misconfiguration cannot let every account of the tenant in.
+func (c *Config) Validate() error {
+	if c.Issuer == "" || c.ClientID == "" {
+		return fmt.Errorf("issuer and client ID are required")
+	}
+	if _, err := url.ParseRequestURI(c.RedirectURL); err != nil || c.RedirectURL == "" {
+		return fmt.Errorf("invalid redirect URL %q", c.RedirectURL)
+	}
+	if len(c.AllowValues)+len(c.EditorValues)+len(c.AdministratorValues) == 0 {
+		return fmt.Errorf("no allowed %s values configured", c.allowClaim())
+	}
+	return nil
+}
+
+func (c *Config) allowClaim() string {
+	if c.AllowClaim == "" {
+		return "groups"
+	}
+	return c.AllowClaim
+}
+
+func (c *Config) scopes() []string {
+	if len(c.Scopes) == 0 {
+		return []string{"openid", "profile", "email"}
+	}
+	return c.Scopes
+}
+
+// Provider is the issuer as seen by this client. The discovery document and
+// signing keys are fetched on first use and cached.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *metadata
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+// metadata is the part of the discovery document the flow needs
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// NewProvider checks the configuration. Without a post-logout URL the issuer
+// sends the browser back to /login next to the redirect URL.
+func NewProvider(cfg Config) (*Provider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.PostLogoutRedirectURL == "" {
+		u, _ := url.Parse(cfg.RedirectURL)
+		u.Path, u.RawQuery, u.Fragment = "/login", "", ""
+		cfg.PostLogoutRedirectURL = u.String()
+	}
+	return &Provider{
+		config: cfg,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+// Issuer returns the configured issuer URL
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// AuthRequest is the per-login state kept between redirecting to the issuer and
+// handling the callback
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewAuthRequest generates a fresh state, nonce and PKCE code verifier
+func NewAuthRequest() (*AuthRequest, error) {
+	var values [3]string
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &AuthRequest{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// codeChallenge is the S256 PKCE challenge for a verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the issuer URL the browser is sent to for signing in
+func (p *Provider) AuthCodeURL(ctx context.Context, req *AuthRequest) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.scopes(), " ")},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {codeChallenge(req.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	return withQuery(md.AuthorizationEndpoint, q), nil
+}
+
+// LogoutURL returns the issuer's end-session URL, or "" when it has none
+func (p *Provider) LogoutURL(ctx context.Context, idToken string) string {
+	md, err := p.discover(ctx)
+	if err != nil || md.EndSessionEndpoint == "" {
+		return ""
+	}
+
+	q := url.Values{"client_id": {p.config.ClientID}}
+	if idToken != "" {
+		q.Set("id_token_hint", idToken)
+	}
+	if p.config.PostLogoutRedirectURL != "" {
+		q.Set("post_logout_redirect_uri", p.config.PostLogoutRedirectURL)
+	}
+	return withQuery(md.EndSessionEndpoint, q)
+}
+
+// Identity is a verified sign-in. Name is the preferred username, falling back
+// to the email address and subject. Role and Level2Scope are set once the
+// allowlist is checked; an empty scope covers all Level2 units.
+type Identity struct {
+	Subject     string
+	Name        string
+	Role        admin.Role
+	Level2Scope []string
+	IDToken     string
+	Claims      jwt.MapClaims
+}
+
+// Exchange redeems an authorization code, verifies the ID token and checks the
+// allowlist. A valid token that is not allowed returns ErrNotAllowed together
+// with the identity, so the caller can log who was refused.
+func (p *Provider) Exchange(ctx context.Context, req *AuthRequest, code string) (*Identity, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {req.Verifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return nil, fmt.Errorf("token request failed: status %d without ID token", resp.StatusCode)
+	}
+
+	identity, err := p.Verify(ctx, token.IDToken, req.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	role, ok := p.role(identity.Claims)
+	if !ok {
+		return identity, ErrNotAllowed
+	}
+	identity.Role = role
+	identity.Level2Scope = p.level2Scope(identity.Claims)
+	return identity, nil
+}
+
+// Verify checks the signature, issuer, audience, lifetime and nonce of an ID token
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Identity, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims,
+		func(t *jwt.Token) (interface{}, error) {
+			kid, _ := t.Header["kid"].(string)
+			return p.key(ctx, md, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(md.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	// With several audiences the token must have been issued to this client
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return nil, fmt.Errorf("invalid ID token: issued to %q", azp)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("invalid ID token: nonce mismatch")
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("invalid ID token: no subject")
+	}
+
+	return &Identity{
+		Subject: subject,
+		Name:    identityName(claims, subject),
+		IDToken: rawIDToken,
+		Claims:  claims,
+	}, nil
+}
+
+func identityName(claims jwt.MapClaims, subject string) string {
+	for _, name := range []string{"preferred_username", "email", "upn"} {
+		if v, ok := claims[name].(string); ok && v != "" {
+			return strings.ToLower(v)
+		}
+	}
+	return subject
+}
+
+// role returns the highest role whose allowlist holds a value of the allowlist
+// claim. The claim may be a string or a list of strings. Entra leaves out the
+// groups claim for users in too many groups; use app roles in that case.
+func (p *Provider) role(claims jwt.MapClaims) (admin.Role, bool) {
+	values := p.allowClaimValues(claims)
+	switch {
+	case containsAny(values, p.config.AdministratorValues):
+		return admin.RoleAdministrator, true
+	case containsAny(values, p.config.EditorValues):
+		return admin.RoleEditor, true
+	case containsAny(values, p.config.AllowValues):
+		return admin.RoleViewer, true
+	}
+	return "", false
+}
+
+// level2Scope returns the Level2 units mapped to the account's allowlist claim
+// values. Any mapped value limits the account, even when other values it holds
+// are not mapped, so a scoped group cannot be widened by mistake.
+func (p *Provider) level2Scope(claims jwt.MapClaims) []string {
+	var scope []string
+	seen := make(map[string]bool)
+	for _, value := range p.allowClaimValues(claims) {
+		for _, level2 := range p.config.Level2Scopes[strings.ToLower(value)] {
+			if !seen[strings.ToLower(level2)] {
+				seen[strings.ToLower(level2)] = true
+				scope = append(scope, level2)
+			}
+		}
+	}
+	return scope
+}
+
+// allowClaimValues returns the allowlist claim, which may be a string or a list of strings
+func (p *Provider) allowClaimValues(claims jwt.MapClaims) []string {
+	var values []string
+	switch v := claims[p.config.allowClaim()].(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, have := range values {
+		for _, want := range wanted {
+			if strings.EqualFold(have, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discover fetches the discovery document once; a failed fetch is retried on
+// the next call
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var md metadata
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &md); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimRight(md.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("OIDC discovery failed: issuer %q does not match %q", md.Issuer, p.config.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery failed: incomplete metadata")
+	}
+
+	p.metadata = &md
+	return p.metadata, nil
+}
+
+// key returns the signing key with the given ID, refetching the JWKS when the
+// issuer has rotated its keys
+func (p *Provider) key(ctx context.Context, md *metadata, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, md.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func withQuery(endpoint string, q url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + q.Encode()
+	}
+	return endpoint + "?" + q.Encode()
+}
+
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
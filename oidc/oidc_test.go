@@ -0,0 +1,257 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"powerbi-access-tool/admin"
+)
+
+const testClientID = "powerbi-access-tool"
+
+func startMock(t *testing.T) *MockIssuer {
+	t.Helper()
+	m, err := StartMockIssuer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("StartMockIssuer: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func newTestProvider(t *testing.T, m *MockIssuer) *Provider {
+	t.Helper()
+	p, err := NewProvider(Config{
+		Issuer:              m.URL,
+		ClientID:            testClientID,
+		RedirectURL:         "http://localhost:8080/login/oidc/callback",
+		AllowValues:         []string{MockViewerGroup},
+		EditorValues:        []string{MockEditorGroup, MockScopedGroup},
+		AdministratorValues: []string{MockAdminGroup},
+		Level2Scopes:        map[string][]string{MockScopedGroup: {MockScopedLevel2}},
+	})
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return p
+}
+
+// authorize signs in at the mock issuer's page and returns the authorization code
+func authorize(t *testing.T, p *Provider, req *AuthRequest, username, groups string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), req)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse %s: %v", authURL, err)
+	}
+	if got := u.Query().Get("code_challenge"); got != codeChallenge(req.Verifier) {
+		t.Fatalf("code_challenge = %q, want the S256 challenge of the verifier", got)
+	}
+
+	form := u.Query()
+	form.Set("username", username)
+	form.Set("groups", groups)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.PostForm(p.config.Issuer+"/authorize", form)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("authorize returned %d without redirect", resp.StatusCode)
+	}
+	if got := location.Query().Get("state"); got != req.State {
+		t.Fatalf("state = %q, want %q", got, req.State)
+	}
+	return location.Query().Get("code")
+}
+
+func newAuthRequest(t *testing.T) *AuthRequest {
+	t.Helper()
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatalf("NewAuthRequest: %v", err)
+	}
+	return req
+}
+
+func TestExchange(t *testing.T) {
+	m := startMock(t)
+	p := newTestProvider(t, m)
+
+	tests := []struct {
+		groups string
+		role   admin.Role
+		scope  []string
+	}{
+		{MockViewerGroup, admin.RoleViewer, nil},
+		{MockEditorGroup + "," + MockViewerGroup, admin.RoleEditor, nil},
+		{MockAdminGroup, admin.RoleAdministrator, nil},
+		{MockScopedGroup, admin.RoleEditor, []string{MockScopedLevel2}},
+		// A scoped group limits the account even next to an unscoped one
+		{MockScopedGroup + "," + MockAdminGroup, admin.RoleAdministrator, []string{MockScopedLevel2}},
+	}
+	for _, tt := range tests {
+		req := newAuthRequest(t)
+		code := authorize(t, p, req, "Kees@Voorbeeld.nl", tt.groups)
+
+		identity, err := p.Exchange(context.Background(), req, code)
+		if err != nil {
+			t.Fatalf("groups %s: Exchange: %v", tt.groups, err)
+		}
+		if identity.Name != "kees@voorbeeld.nl" {
+			t.Errorf("groups %s: name = %q, want kees@voorbeeld.nl", tt.groups, identity.Name)
+		}
+		if identity.Role != tt.role {
+			t.Errorf("groups %s: role = %q, want %q", tt.groups, identity.Role, tt.role)
+		}
+		if strings.Join(identity.Level2Scope, ",") != strings.Join(tt.scope, ",") {
+			t.Errorf("groups %s: scope = %v, want %v", tt.groups, identity.Level2Scope, tt.scope)
+		}
+	}
+}
+
+func TestExchangeRedeemsCodeOnce(t *testing.T) {
+	p := newTestProvider(t, startMock(t))
+	req := newAuthRequest(t)
+	code := authorize(t, p, req, MockDefaultUser, MockAdminGroup)
+
+	if _, err := p.Exchange(context.Background(), req, code); err != nil {
+		t.Fatalf("first Exchange: %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), req, code); err == nil {
+		t.Fatal("second Exchange of the same code succeeded")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	p := newTestProvider(t, startMock(t))
+	req := newAuthRequest(t)
+	code := authorize(t, p, req, MockDefaultUser, MockAdminGroup)
+
+	other := newAuthRequest(t)
+	wrong := &AuthRequest{State: req.State, Nonce: req.Nonce, Verifier: other.Verifier}
+	if _, err := p.Exchange(context.Background(), wrong, code); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Exchange with another verifier: err = %v, want invalid_grant", err)
+	}
+}
+
+func TestExchangeRejectsWrongNonce(t *testing.T) {
+	p := newTestProvider(t, startMock(t))
+	req := newAuthRequest(t)
+	code := authorize(t, p, req, MockDefaultUser, MockAdminGroup)
+
+	wrong := &AuthRequest{State: req.State, Nonce: "another-nonce", Verifier: req.Verifier}
+	if _, err := p.Exchange(context.Background(), wrong, code); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("Exchange with another nonce: err = %v, want nonce mismatch", err)
+	}
+}
+
+func TestExchangeRefusesAccountsNotOnAllowlist(t *testing.T) {
+	p := newTestProvider(t, startMock(t))
+	req := newAuthRequest(t)
+	code := authorize(t, p, req, "gast@voorbeeld.nl", "everyone")
+
+	identity, err := p.Exchange(context.Background(), req, code)
+	if !errors.Is(err, ErrNotAllowed) {
+		t.Fatalf("err = %v, want ErrNotAllowed", err)
+	}
+	if identity == nil || identity.Name != "gast@voorbeeld.nl" {
+		t.Fatalf("identity = %+v, want the refused account", identity)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := startMock(t)
+	p := newTestProvider(t, m)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() jwt.MapClaims {
+		now := time.Now()
+		return jwt.MapClaims{
+			"iss":                m.URL,
+			"sub":                "subject",
+			"aud":                testClientID,
+			"iat":                now.Unix(),
+			"exp":                now.Add(time.Hour).Unix(),
+			"nonce":              "nonce",
+			"preferred_username": "kees@voorbeeld.nl",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		key    *rsa.PrivateKey
+		want   string
+	}{
+		{name: "valid"},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "another-client" }, want: "audience"},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://issuer.example" }, want: "issuer"},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, want: "expired"},
+		{name: "no expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }, want: "exp"},
+		{name: "wrong nonce", modify: func(c jwt.MapClaims) { c["nonce"] = "other" }, want: "nonce"},
+		{name: "other party", modify: func(c jwt.MapClaims) { c["azp"] = "another-client" }, want: "issued to"},
+		{name: "unknown key", key: otherKey, want: "signature"},
+	}
+	for _, tt := range tests {
+		claims := valid()
+		if tt.modify != nil {
+			tt.modify(claims)
+		}
+		key := m.key
+		if tt.key != nil {
+			key = tt.key
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = mockKeyID
+		raw, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = p.Verify(context.Background(), raw, "nonce")
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: Verify: %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: err = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestParseLevel2Scopes(t *testing.T) {
+	scopes := parseLevel2Scopes(" Noord-Group = Regio Noord ; holding=Holding;holding=Regio Zuid;invalid; =x")
+	want := map[string]string{
+		"noord-group": "Regio Noord",
+		"holding":     "Holding,Regio Zuid",
+	}
+	if len(scopes) != len(want) {
+		t.Fatalf("scopes = %v, want %v", scopes, want)
+	}
+	for value, level2s := range want {
+		if got := strings.Join(scopes[value], ","); got != level2s {
+			t.Errorf("scopes[%q] = %q, want %q", value, got, level2s)
+		}
+	}
+}
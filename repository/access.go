@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"powerbi-access-tool/models"
 )
@@ -16,13 +18,21 @@ func NewAccessRepository(db *sql.DB) *AccessRepository {
 	return &AccessRepository{db: db}
 }
 
-func (r *AccessRepository) ListByUser(ctx context.Context, userID int) ([]models.UserAccess, error) {
-	query := `
-		SELECT ua.UserAccessID, ua.UserID, ua.Group_Bkey, g.GroupName, ua.CreationDate
+// ListByUser returns the user's access records. Records whose group no longer
+// exists in dim.[Group] are only included, flagged as orphaned, when includeOrphaned is set.
+func (r *AccessRepository) ListByUser(ctx context.Context, userID int, includeOrphaned bool) ([]models.UserAccess, error) {
+	join := "INNER JOIN"
+	if includeOrphaned {
+		join = "LEFT JOIN"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT ua.UserAccessID, ua.UserID, ua.Group_Bkey, g.GroupName, ua.CreationDate, e.ValidUntil
 		FROM powerbi.UserAccess ua
-		INNER JOIN dim.[Group] g ON ua.Group_Bkey = g.Group_Bkey
+		%s dim.[Group] g ON ua.Group_Bkey = g.Group_Bkey
+		LEFT JOIN powerbi.UserAccessExpiry e ON e.UserAccessID = ua.UserAccessID
 		WHERE ua.UserID = @p1
-		ORDER BY g.GroupName`
+		ORDER BY CASE WHEN g.Group_Bkey IS NULL THEN 1 ELSE 0 END, g.GroupName, ua.Group_Bkey`, join)
 
 	rows, err := r.db.QueryContext(ctx, query, userID)
 	if err != nil {
@@ -33,9 +43,16 @@ func (r *AccessRepository) ListByUser(ctx context.Context, userID int) ([]models
 	var accessList []models.UserAccess
 	for rows.Next() {
 		var a models.UserAccess
-		if err := rows.Scan(&a.UserAccessID, &a.UserID, &a.GroupBkey, &a.GroupName, &a.CreationDate); err != nil {
+		var groupName sql.NullString
+		var validUntil sql.NullTime
+		if err := rows.Scan(&a.UserAccessID, &a.UserID, &a.GroupBkey, &groupName, &a.CreationDate, &validUntil); err != nil {
 			return nil, fmt.Errorf("failed to scan user access: %w", err)
 		}
+		a.GroupName = groupName.String
+		a.Orphaned = !groupName.Valid
+		if validUntil.Valid {
+			a.ValidUntil = &validUntil.Time
+		}
 		accessList = append(accessList, a)
 	}
 
@@ -46,24 +63,365 @@ func (r *AccessRepository) ListByUser(ctx context.Context, userID int) ([]models
 	return accessList, nil
 }
 
+// ListOrphaned returns every access record whose Group_Bkey no longer exists in dim.[Group]
+func (r *AccessRepository) ListOrphaned(ctx context.Context) ([]models.OrphanedAccess, error) {
+	query := `
+		SELECT ua.UserAccessID, ua.UserID, u.PowerBIUser, ua.Group_Bkey, ua.CreationDate
+		FROM powerbi.UserAccess ua
+		LEFT JOIN powerbi.Users u ON ua.UserID = u.PowerBIUserID
+		WHERE NOT EXISTS (SELECT 1 FROM dim.[Group] g WHERE g.Group_Bkey = ua.Group_Bkey)
+		ORDER BY ua.Group_Bkey, u.PowerBIUser`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orphaned access: %w", err)
+	}
+	defer rows.Close()
+
+	var orphaned []models.OrphanedAccess
+	for rows.Next() {
+		var o models.OrphanedAccess
+		var email sql.NullString
+		if err := rows.Scan(&o.UserAccessID, &o.UserID, &email, &o.GroupBkey, &o.CreationDate); err != nil {
+			return nil, fmt.Errorf("failed to scan orphaned access: %w", err)
+		}
+		o.UserEmail = email.String
+		orphaned = append(orphaned, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating orphaned access: %w", err)
+	}
+
+	return orphaned, nil
+}
+
+// RemoveOrphaned deletes the given access records, or all orphaned records when
+// accessIDs is empty. Records whose group exists are never removed.
+func (r *AccessRepository) RemoveOrphaned(ctx context.Context, accessIDs []int) ([]models.OrphanedAccess, error) {
+	var removed []models.OrphanedAccess
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		if len(accessIDs) == 0 {
+			return removeOrphanedBatch(ctx, tx, nil, &removed)
+		}
+		for start := 0; start < len(accessIDs); start += grantBatchSize {
+			end := min(start+grantBatchSize, len(accessIDs))
+			if err := removeOrphanedBatch(ctx, tx, accessIDs[start:end], &removed); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+func removeOrphanedBatch(ctx context.Context, tx *sql.Tx, accessIDs []int, removed *[]models.OrphanedAccess) error {
+	query := `
+		DELETE ua
+		OUTPUT DELETED.UserAccessID, DELETED.UserID, u.PowerBIUser, DELETED.Group_Bkey, DELETED.CreationDate
+		FROM powerbi.UserAccess ua
+		LEFT JOIN powerbi.Users u ON ua.UserID = u.PowerBIUserID
+		WHERE NOT EXISTS (SELECT 1 FROM dim.[Group] g WHERE g.Group_Bkey = ua.Group_Bkey)`
+
+	var args []interface{}
+	if len(accessIDs) > 0 {
+		query += fmt.Sprintf(` AND ua.UserAccessID IN (%s)`, placeholders(1, len(accessIDs)))
+		for _, id := range accessIDs {
+			args = append(args, id)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to remove orphaned access: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.OrphanedAccess
+		var email sql.NullString
+		if err := rows.Scan(&o.UserAccessID, &o.UserID, &email, &o.GroupBkey, &o.CreationDate); err != nil {
+			return fmt.Errorf("failed to scan removed access: %w", err)
+		}
+		o.UserEmail = email.String
+		*removed = append(*removed, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating removed access: %w", err)
+	}
+	return nil
+}
+
+// ListByGroup returns the users that have access to a group
+func (r *AccessRepository) ListByGroup(ctx context.Context, groupBkey int) ([]models.GroupMember, error) {
+	query := `
+		SELECT ua.UserAccessID, u.PowerBIUserID, u.PowerBIUser, ua.CreationDate, e.ValidUntil
+		FROM powerbi.UserAccess ua
+		INNER JOIN powerbi.Users u ON ua.UserID = u.PowerBIUserID
+		LEFT JOIN powerbi.UserAccessExpiry e ON e.UserAccessID = ua.UserAccessID
+		WHERE ua.Group_Bkey = @p1
+		ORDER BY u.PowerBIUser`
+
+	rows, err := r.db.QueryContext(ctx, query, groupBkey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []models.GroupMember
+	for rows.Next() {
+		var m models.GroupMember
+		var validUntil sql.NullTime
+		if err := rows.Scan(&m.UserAccessID, &m.UserID, &m.Email, &m.CreationDate, &validUntil); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		if validUntil.Valid {
+			m.ValidUntil = &validUntil.Time
+		}
+		members = append(members, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating group members: %w", err)
+	}
+
+	return members, nil
+}
+
+// GetByID returns a single access record; GroupName is empty when the group no longer exists
+func (r *AccessRepository) GetByID(ctx context.Context, accessID int) (*models.UserAccess, error) {
+	query := `
+		SELECT ua.UserAccessID, ua.UserID, ua.Group_Bkey, g.GroupName, ua.CreationDate, e.ValidUntil
+		FROM powerbi.UserAccess ua
+		LEFT JOIN dim.[Group] g ON ua.Group_Bkey = g.Group_Bkey
+		LEFT JOIN powerbi.UserAccessExpiry e ON e.UserAccessID = ua.UserAccessID
+		WHERE ua.UserAccessID = @p1`
+
+	var a models.UserAccess
+	var groupName sql.NullString
+	var validUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx, query, accessID).Scan(&a.UserAccessID, &a.UserID, &a.GroupBkey, &groupName, &a.CreationDate, &validUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access record: %w", err)
+	}
+	a.GroupName = groupName.String
+	if validUntil.Valid {
+		a.ValidUntil = &validUntil.Time
+	}
+	return &a, nil
+}
+
 func (r *AccessRepository) AddGroups(ctx context.Context, userID int, groupBkeys []int) error {
 	if len(groupBkeys) == 0 {
 		return nil
 	}
 
-	// Insert each group access record
+	// Insert each group access record, all or nothing
 	query := `INSERT INTO powerbi.UserAccess (UserID, Group_Bkey) VALUES (@p1, @p2)`
 
-	for _, groupBkey := range groupBkeys {
-		_, err := r.db.ExecContext(ctx, query, userID, groupBkey)
-		if err != nil {
-			return fmt.Errorf("failed to add group %d for user %d: %w", groupBkey, userID, err)
+	return withTx(ctx, r.db, func(tx *sql.Tx) error {
+		for _, groupBkey := range groupBkeys {
+			_, err := tx.ExecContext(ctx, query, userID, groupBkey)
+			if err != nil {
+				return fmt.Errorf("failed to add group %d for user %d: %w", groupBkey, userID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// grantBatchSize keeps each statement well below the SQL Server limit of 2100 parameters
+const grantBatchSize = 1000
+
+// Grant gives a user permanent access to the given groups in a single transaction.
+// Groups the user already has are skipped and keys missing from dim.[Group] are reported as unknown.
+func (r *AccessRepository) Grant(ctx context.Context, userID int, groupBkeys []int) (*models.GrantResult, error) {
+	return r.GrantWithExpiry(ctx, userID, models.PermanentGrants(groupBkeys))
+}
+
+// GrantWithExpiry works like Grant but lets each group carry an optional expiry,
+// stored in powerbi.UserAccessExpiry. Groups the user already has keep their current expiry.
+func (r *AccessRepository) GrantWithExpiry(ctx context.Context, userID int, grants []models.Grant) (*models.GrantResult, error) {
+	result := &models.GrantResult{Added: []int{}, Skipped: []int{}, Unknown: []int{}}
+	grants = uniqueGrants(grants)
+	if len(grants) == 0 {
+		return result, nil
+	}
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		for start := 0; start < len(grants); start += grantBatchSize {
+			end := min(start+grantBatchSize, len(grants))
+			if err := grantBatch(ctx, tx, userID, grants[start:end], result); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func grantBatch(ctx context.Context, tx *sql.Tx, userID int, grants []models.Grant, result *models.GrantResult) error {
+	args := []interface{}{userID}
+	for _, g := range grants {
+		args = append(args, g.GroupBkey)
+	}
+
+	// Classify the requested keys; the locks keep concurrent grants from inserting duplicates
+	classifyQuery := fmt.Sprintf(`
+		SELECT g.Group_Bkey,
+			CASE WHEN EXISTS (
+				SELECT 1 FROM powerbi.UserAccess ua WITH (UPDLOCK, HOLDLOCK)
+				WHERE ua.UserID = @p1 AND ua.Group_Bkey = g.Group_Bkey
+			) THEN 1 ELSE 0 END
+		FROM dim.[Group] g
+		WHERE g.Group_Bkey IN (%s)`, placeholders(2, len(grants)))
+
+	rows, err := tx.QueryContext(ctx, classifyQuery, args...)
+	if err != nil {
+		return fmt.Errorf("failed to check existing access: %w", err)
+	}
+
+	existing := make(map[int]bool)
+	for rows.Next() {
+		var groupBkey int
+		var hasAccess bool
+		if err := rows.Scan(&groupBkey, &hasAccess); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan existing access: %w", err)
+		}
+		existing[groupBkey] = hasAccess
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("error iterating existing access: %w", err)
+	}
+	rows.Close()
+
+	insertArgs := []interface{}{userID}
+	validUntil := make(map[int]time.Time)
+	for _, g := range grants {
+		hasAccess, known := existing[g.GroupBkey]
+		switch {
+		case !known:
+			result.Unknown = append(result.Unknown, g.GroupBkey)
+		case hasAccess:
+			result.Skipped = append(result.Skipped, g.GroupBkey)
+		default:
+			insertArgs = append(insertArgs, g.GroupBkey)
+			if g.ValidUntil != nil {
+				validUntil[g.GroupBkey] = *g.ValidUntil
+			}
 		}
 	}
 
+	if len(insertArgs) == 1 {
+		return nil
+	}
+
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO powerbi.UserAccess (UserID, Group_Bkey)
+		OUTPUT INSERTED.UserAccessID, INSERTED.Group_Bkey
+		SELECT @p1, g.Group_Bkey
+		FROM dim.[Group] g
+		WHERE g.Group_Bkey IN (%s)`, placeholders(2, len(insertArgs)-1))
+
+	rows, err = tx.QueryContext(ctx, insertQuery, insertArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to add groups for user %d: %w", userID, err)
+	}
+
+	var expiryArgs []interface{}
+	for rows.Next() {
+		var accessID, groupBkey int
+		if err := rows.Scan(&accessID, &groupBkey); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan added access: %w", err)
+		}
+		result.Added = append(result.Added, groupBkey)
+		if until, ok := validUntil[groupBkey]; ok {
+			expiryArgs = append(expiryArgs, accessID, until)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("error iterating added access: %w", err)
+	}
+	rows.Close()
+
+	if len(expiryArgs) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(expiryArgs)/2)
+	for i := 1; i < len(expiryArgs); i += 2 {
+		values = append(values, fmt.Sprintf("(@p%d, @p%d)", i, i+1))
+	}
+	expiryQuery := `INSERT INTO powerbi.UserAccessExpiry (UserAccessID, ValidUntil) VALUES ` + strings.Join(values, ", ")
+
+	if _, err := tx.ExecContext(ctx, expiryQuery, expiryArgs...); err != nil {
+		return fmt.Errorf("failed to store access expiry: %w", err)
+	}
 	return nil
 }
 
+// RemoveExpired deletes every access record whose expiry lies before now and
+// returns the removed records. Records whose group one of the user's roles
+// covers are kept; the role takes over once the expiry passes.
+func (r *AccessRepository) RemoveExpired(ctx context.Context, now time.Time) ([]models.UserAccess, error) {
+	var removed []models.UserAccess
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		query := `
+			DELETE ua
+			OUTPUT DELETED.UserAccessID, DELETED.UserID, DELETED.Group_Bkey, g.GroupName, DELETED.CreationDate, e.ValidUntil
+			FROM powerbi.UserAccess ua
+			INNER JOIN powerbi.UserAccessExpiry e ON e.UserAccessID = ua.UserAccessID
+			LEFT JOIN dim.[Group] g ON g.Group_Bkey = ua.Group_Bkey
+			WHERE e.ValidUntil <= @p1 AND NOT ` + roleCoveredCondition
+
+		rows, err := tx.QueryContext(ctx, query, now)
+		if err != nil {
+			return fmt.Errorf("failed to remove expired access: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var a models.UserAccess
+			var groupName sql.NullString
+			var validUntil time.Time
+			if err := rows.Scan(&a.UserAccessID, &a.UserID, &a.GroupBkey, &groupName, &a.CreationDate, &validUntil); err != nil {
+				return fmt.Errorf("failed to scan expired access: %w", err)
+			}
+			a.GroupName = groupName.String
+			a.ValidUntil = &validUntil
+			removed = append(removed, a)
+		}
+
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating expired access: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
 func (r *AccessRepository) Remove(ctx context.Context, accessID int) error {
 	query := `DELETE FROM powerbi.UserAccess WHERE UserAccessID = @p1`
 
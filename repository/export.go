@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"powerbi-access-tool/models"
+)
+
+// AccessExportOptions selects the users in an access export. Offset and Limit of
+// Users are ignored. ByLevel2 orders the rows by Level2 unit first.
+type AccessExportOptions struct {
+	Users    UserListOptions
+	ByLevel2 bool
+}
+
+// Export streams a row for every access record of the matching users, once per
+// Level2/Level3 unit its group belongs to. Rows are passed to fn as they are read;
+// an error from fn stops the export and is returned. Orphaned records are left out.
+func (r *AccessRepository) Export(ctx context.Context, opts AccessExportOptions, fn func(models.AccessExportRow) error) error {
+	where := ""
+	var args []interface{}
+	if opts.Users.Filter != "" {
+		where = ` WHERE u.PowerBIUser LIKE @p1`
+		args = append(args, "%"+opts.Users.Filter+"%")
+	}
+
+	orderBy := userOrderBy(opts.Users, "u") + `, g.GroupName, ua.Group_Bkey, Level2Name, Level3Name`
+	if opts.ByLevel2 {
+		orderBy = `Level2Name, ` + orderBy
+	}
+
+	query := `
+		SELECT u.PowerBIUserID, u.PowerBIUser, ua.Group_Bkey, g.GroupName,
+			COALESCE(o.Level2Name, '') AS Level2Name, COALESCE(o.Level3Name, '') AS Level3Name,
+			ua.CreationDate, e.ValidUntil
+		FROM powerbi.Users u
+		INNER JOIN powerbi.UserAccess ua ON ua.UserID = u.PowerBIUserID
+		INNER JOIN dim.[Group] g ON g.Group_Bkey = ua.Group_Bkey
+		LEFT JOIN (
+			SELECT DISTINCT Group_Bkey, Level2Name, Level3Name FROM dim.[Object]
+		) o ON o.Group_Bkey = ua.Group_Bkey
+		LEFT JOIN powerbi.UserAccessExpiry e ON e.UserAccessID = ua.UserAccessID` + where + `
+		ORDER BY ` + orderBy
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query access export: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row models.AccessExportRow
+		var validUntil sql.NullTime
+		if err := rows.Scan(&row.UserID, &row.Email, &row.GroupBkey, &row.GroupName,
+			&row.Level2Name, &row.Level3Name, &row.CreationDate, &validUntil); err != nil {
+			return fmt.Errorf("failed to scan access export: %w", err)
+		}
+		if validUntil.Valid {
+			row.ValidUntil = &validUntil.Time
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating access export: %w", err)
+	}
+	return nil
+}
+
+// Export collects the rows under the read lock and passes them to fn afterwards,
+// so a slow client does not block writers
+func (r *MemoryAccessRepository) Export(ctx context.Context, opts AccessExportOptions, fn func(models.AccessExportRow) error) error {
+	users, _, err := NewMemoryUserRepository(r.db).List(ctx, UserListOptions{
+		Filter:    opts.Users.Filter,
+		SortField: opts.Users.SortField,
+		SortDir:   opts.Users.SortDir,
+	})
+	if err != nil {
+		return err
+	}
+
+	userOrder := make(map[int]int, len(users))
+	for i, u := range users {
+		userOrder[u.PowerBIUserID] = i
+	}
+
+	type unit struct{ level2, level3 string }
+
+	r.db.mu.RLock()
+	units := make(map[int][]unit)
+	seen := make(map[int]map[unit]bool)
+	for _, o := range r.db.objects {
+		u := unit{o.Level2Name, o.Level3Name}
+		if seen[o.GroupBkey] == nil {
+			seen[o.GroupBkey] = make(map[unit]bool)
+		}
+		if !seen[o.GroupBkey][u] {
+			seen[o.GroupBkey][u] = true
+			units[o.GroupBkey] = append(units[o.GroupBkey], u)
+		}
+	}
+
+	var rows []models.AccessExportRow
+	for _, a := range r.db.access {
+		i, ok := userOrder[a.UserID]
+		if !ok {
+			continue
+		}
+		g, ok := r.db.groups[a.GroupBkey]
+		if !ok {
+			continue
+		}
+
+		groupUnits := units[a.GroupBkey]
+		if len(groupUnits) == 0 {
+			groupUnits = []unit{{}}
+		}
+		for _, u := range groupUnits {
+			rows = append(rows, models.AccessExportRow{
+				UserID:       a.UserID,
+				Email:        users[i].PowerBIUser,
+				GroupBkey:    a.GroupBkey,
+				GroupName:    g.GroupName,
+				Level2Name:   u.level2,
+				Level3Name:   u.level3,
+				CreationDate: a.CreationDate,
+				ValidUntil:   a.ValidUntil,
+			})
+		}
+	}
+	r.db.mu.RUnlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if opts.ByLevel2 && !strings.EqualFold(a.Level2Name, b.Level2Name) {
+			return strings.ToLower(a.Level2Name) < strings.ToLower(b.Level2Name)
+		}
+		if userOrder[a.UserID] != userOrder[b.UserID] {
+			return userOrder[a.UserID] < userOrder[b.UserID]
+		}
+		if !strings.EqualFold(a.GroupName, b.GroupName) {
+			return strings.ToLower(a.GroupName) < strings.ToLower(b.GroupName)
+		}
+		if a.GroupBkey != b.GroupBkey {
+			return a.GroupBkey < b.GroupBkey
+		}
+		if a.Level2Name != b.Level2Name {
+			return a.Level2Name < b.Level2Name
+		}
+		return a.Level3Name < b.Level3Name
+	})
+
+	for _, row := range rows {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"powerbi-access-tool/models"
 )
@@ -16,73 +17,261 @@ func NewGroupRepository(db *sql.DB) *GroupRepository {
 	return &GroupRepository{db: db}
 }
 
-func (r *GroupRepository) Search(ctx context.Context, searchTerm string) ([]models.SearchResult, error) {
-	if searchTerm == "" {
-		return nil, nil
+// GroupListOptions filters, sorts and pages the group catalog. A Limit of 0 returns all rows.
+type GroupListOptions struct {
+	Filter    string
+	SortField string
+	SortDir   string
+	Offset    int
+	Limit     int
+}
+
+// List returns one page of groups with their member and object counts,
+// together with the total number of matching groups
+func (r *GroupRepository) List(ctx context.Context, opts GroupListOptions) ([]models.GroupSummary, int, error) {
+	where := ""
+	var args []interface{}
+	if opts.Filter != "" {
+		where = ` WHERE g.GroupName LIKE @p1 OR CAST(g.Group_Bkey AS NVARCHAR(20)) = @p2`
+		args = append(args, "%"+opts.Filter+"%", opts.Filter)
+	}
+
+	var total int
+	countQuery := `SELECT COUNT(1) FROM dim.[Group] g` + where
+	if err := r.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count groups: %w", err)
+	}
+
+	query := `
+		SELECT g.Group_Bkey, g.GroupName,
+			(SELECT COUNT(1) FROM powerbi.UserAccess ua WHERE ua.Group_Bkey = g.Group_Bkey) AS MemberCount,
+			(SELECT COUNT(1) FROM dim.[Object] o WHERE o.Group_Bkey = g.Group_Bkey) AS ObjectCount
+		FROM dim.[Group] g` + where
+
+	// Validate sort field to prevent SQL injection
+	validSortFields := map[string]string{
+		"bkey":    "g.Group_Bkey",
+		"name":    "g.GroupName",
+		"members": "MemberCount",
+		"objects": "ObjectCount",
+	}
+	dbField, ok := validSortFields[opts.SortField]
+	if !ok {
+		dbField = "g.GroupName"
+	}
+
+	// Validate sort direction
+	sortDir := opts.SortDir
+	if sortDir != "asc" && sortDir != "desc" {
+		sortDir = "asc"
 	}
 
-	searchPattern := "%" + searchTerm + "%"
+	// Tie-break on the key so pages stay stable
+	query += fmt.Sprintf(` ORDER BY %s %s, g.Group_Bkey %s`, dbField, sortDir, sortDir)
+
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(` OFFSET @p%d ROWS FETCH NEXT @p%d ROWS ONLY`, len(args)+1, len(args)+2)
+		args = append(args, opts.Offset, opts.Limit)
+	}
 
-	// First search in level2name
-	results, err := r.searchInColumn(ctx, "level2name", searchPattern)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, fmt.Errorf("failed to query groups: %w", err)
 	}
+	defer rows.Close()
 
-	// If no results in level2name, search in level3name
-	if len(results) == 0 {
-		results, err = r.searchInColumn(ctx, "level3name", searchPattern)
-		if err != nil {
-			return nil, err
+	var groups []models.GroupSummary
+	for rows.Next() {
+		var g models.GroupSummary
+		if err := rows.Scan(&g.GroupBkey, &g.GroupName, &g.MemberCount, &g.ObjectCount); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan group: %w", err)
 		}
+		groups = append(groups, g)
 	}
 
-	return results, nil
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating groups: %w", err)
+	}
+
+	return groups, total, nil
 }
 
-func (r *GroupRepository) searchInColumn(ctx context.Context, column string, pattern string) ([]models.SearchResult, error) {
-	// Using parameterized column name via switch to prevent SQL injection
-	// level2name and level3name are in dim.Object, joined via Group_Bkey
-	var query string
-	switch column {
-	case "level2name":
-		query = `
-			SELECT DISTINCT g.Group_Bkey, g.GroupName, 'level2name' as MatchedOn
-			FROM dim.[Group] g
-			INNER JOIN dim.[Object] o ON g.Group_Bkey = o.Group_Bkey
-			WHERE o.Level2Name LIKE @p1
-			ORDER BY g.GroupName`
-	case "level3name":
-		query = `
-			SELECT DISTINCT g.Group_Bkey, g.GroupName, 'level3name' as MatchedOn
-			FROM dim.[Group] g
-			INNER JOIN dim.[Object] o ON g.Group_Bkey = o.Group_Bkey
-			WHERE o.Level3Name LIKE @p1
-			ORDER BY g.GroupName`
-	default:
-		return nil, fmt.Errorf("invalid search column: %s", column)
-	}
-
-	rows, err := r.db.QueryContext(ctx, query, pattern)
+// Search finds groups by key, group name, Level2Name and Level3Name in one pass,
+// ranked by match quality (exact, prefix, contains). A non-empty level2Scope only
+// matches objects below those Level2 units.
+func (r *GroupRepository) Search(ctx context.Context, searchTerm string, level2Scope []string) ([]models.SearchResult, error) {
+	if searchTerm == "" {
+		return nil, nil
+	}
+
+	escaped := escapeLike(searchTerm)
+	args := []interface{}{searchTerm, escaped + "%", "%" + escaped + "%"}
+
+	// @p1 exact term, @p2 prefix pattern, @p3 contains pattern
+	query := `
+		SELECT g.Group_Bkey, g.GroupName, o.ObjectName,
+			CASE WHEN CAST(g.Group_Bkey AS NVARCHAR(20)) = @p1 THEN 3 ELSE 0 END,
+			CASE WHEN g.GroupName = @p1 THEN 3 WHEN g.GroupName LIKE @p2 ESCAPE '\' THEN 2
+				WHEN g.GroupName LIKE @p3 ESCAPE '\' THEN 1 ELSE 0 END,
+			CASE WHEN o.Level2Name = @p1 THEN 3 WHEN o.Level2Name LIKE @p2 ESCAPE '\' THEN 2
+				WHEN o.Level2Name LIKE @p3 ESCAPE '\' THEN 1 ELSE 0 END,
+			CASE WHEN o.Level3Name = @p1 THEN 3 WHEN o.Level3Name LIKE @p2 ESCAPE '\' THEN 2
+				WHEN o.Level3Name LIKE @p3 ESCAPE '\' THEN 1 ELSE 0 END
+		FROM dim.[Group] g
+		LEFT JOIN dim.[Object] o ON g.Group_Bkey = o.Group_Bkey
+		WHERE (CAST(g.Group_Bkey AS NVARCHAR(20)) = @p1
+			OR g.GroupName LIKE @p3 ESCAPE '\'
+			OR o.Level2Name LIKE @p3 ESCAPE '\'
+			OR o.Level3Name LIKE @p3 ESCAPE '\')`
+
+	// A scope only keeps objects below its Level2 units, and so drops groups without
+	// objects. Names match case-insensitively whatever the collation, like the handlers' checks.
+	if len(level2Scope) > 0 {
+		query += ` AND LOWER(o.Level2Name) IN (` + placeholders(len(args)+1, len(level2Scope)) + `)`
+		for _, name := range level2Scope {
+			args = append(args, strings.ToLower(name))
+		}
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search groups in %s: %w", column, err)
+		return nil, fmt.Errorf("failed to search groups: %w", err)
 	}
 	defer rows.Close()
 
-	var results []models.SearchResult
+	results := newSearchResults()
 	for rows.Next() {
-		var r models.SearchResult
-		if err := rows.Scan(&r.GroupBkey, &r.GroupName, &r.MatchedOn); err != nil {
+		var objectName sql.NullString
+		var bkey, groupName, level2, level3 int
+		row := searchRow{}
+		if err := rows.Scan(&row.groupBkey, &row.groupName, &objectName, &bkey, &groupName, &level2, &level3); err != nil {
 			return nil, fmt.Errorf("failed to scan search result: %w", err)
 		}
-		results = append(results, r)
+		row.objectName = objectName.String
+		row.scores = map[string]int{
+			"groupbkey":  bkey,
+			"groupname":  groupName,
+			"level2name": level2,
+			"level3name": level3,
+		}
+		results.add(row)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating search results: %w", err)
 	}
 
-	return results, nil
+	return results.ranked(), nil
+}
+
+// GroupsUnder returns the keys of all groups below a Level2 unit, a Level3 unit,
+// or a Level3 unit within a Level2 unit. Empty names are not filtered on.
+func (r *GroupRepository) GroupsUnder(ctx context.Context, level2Name string, level3Name string) ([]int, error) {
+	if level2Name == "" && level3Name == "" {
+		return nil, fmt.Errorf("a Level2 or Level3 name is required")
+	}
+
+	query := `
+		SELECT DISTINCT g.Group_Bkey
+		FROM dim.[Group] g
+		INNER JOIN dim.[Object] o ON g.Group_Bkey = o.Group_Bkey
+		WHERE (@p1 = '' OR o.Level2Name = @p1)
+			AND (@p2 = '' OR o.Level3Name = @p2)
+		ORDER BY g.Group_Bkey`
+
+	rows, err := r.db.QueryContext(ctx, query, level2Name, level3Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groupBkeys []int
+	for rows.Next() {
+		var groupBkey int
+		if err := rows.Scan(&groupBkey); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		groupBkeys = append(groupBkeys, groupBkey)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating groups: %w", err)
+	}
+
+	return groupBkeys, nil
+}
+
+// Units returns the distinct Level2/Level3 units each of the groups belongs to,
+// keyed by group. Groups without objects are left out.
+func (r *GroupRepository) Units(ctx context.Context, groupBkeys []int) (map[int][]models.OrgUnit, error) {
+	units := make(map[int][]models.OrgUnit)
+	groupBkeys = uniqueInts(groupBkeys)
+
+	for start := 0; start < len(groupBkeys); start += grantBatchSize {
+		batch := groupBkeys[start:min(start+grantBatchSize, len(groupBkeys))]
+
+		query := fmt.Sprintf(`
+			SELECT DISTINCT Group_Bkey, COALESCE(Level2Name, ''), COALESCE(Level3Name, '')
+			FROM dim.[Object]
+			WHERE Group_Bkey IN (%s)
+			ORDER BY 2, 3`, placeholders(1, len(batch)))
+
+		rows, err := r.db.QueryContext(ctx, query, intArgs(batch)...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query group units: %w", err)
+		}
+
+		for rows.Next() {
+			var groupBkey int
+			var u models.OrgUnit
+			if err := rows.Scan(&groupBkey, &u.Level2Name, &u.Level3Name); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan group unit: %w", err)
+			}
+			units[groupBkey] = append(units[groupBkey], u)
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error iterating group units: %w", err)
+		}
+		rows.Close()
+	}
+
+	return units, nil
+}
+
+// GetByBkeys looks up many groups at once and returns the ones that exist by key
+func (r *GroupRepository) GetByBkeys(ctx context.Context, groupBkeys []int) (map[int]models.Group, error) {
+	groups := make(map[int]models.Group)
+	groupBkeys = uniqueInts(groupBkeys)
+
+	for start := 0; start < len(groupBkeys); start += grantBatchSize {
+		batch := groupBkeys[start:min(start+grantBatchSize, len(groupBkeys))]
+
+		query := fmt.Sprintf(`SELECT Group_Bkey, GroupName FROM dim.[Group] WHERE Group_Bkey IN (%s)`,
+			placeholders(1, len(batch)))
+
+		rows, err := r.db.QueryContext(ctx, query, intArgs(batch)...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query groups: %w", err)
+		}
+
+		for rows.Next() {
+			var g models.Group
+			if err := rows.Scan(&g.GroupBkey, &g.GroupName); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan group: %w", err)
+			}
+			groups[g.GroupBkey] = g
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error iterating groups: %w", err)
+		}
+		rows.Close()
+	}
+
+	return groups, nil
 }
 
 func (r *GroupRepository) GetByBkey(ctx context.Context, groupBkey int) (*models.Group, error) {
@@ -0,0 +1,1312 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"powerbi-access-tool/models"
+)
+
+// MemoryDB holds an in-memory copy of powerbi.Users, powerbi.UserAccess,
+// dim.[Group] and dim.[Object] for running without SQL Server
+type MemoryDB struct {
+	mu           sync.RWMutex
+	users        map[int]models.User
+	access       map[int]models.UserAccess
+	groups       map[int]models.Group
+	objects      []models.Object
+	rules        map[int]models.AccessRule
+	ruleGrants   map[int]int // access ID → rule ID
+	roles        map[int]models.Role
+	roleMembers  map[roleMember]time.Time
+	roleGrants   map[int]int // access ID → role ID
+	nextUserID   int
+	nextAccessID int
+	nextRuleID   int
+	nextRoleID   int
+}
+
+// roleMember is a row of powerbi.AccessRoleMember
+type roleMember struct {
+	roleID int
+	userID int
+}
+
+// MemorySeed is the JSON layout accepted by LoadSeed
+type MemorySeed struct {
+	Groups  []models.Group  `json:"groups"`
+	Objects []models.Object `json:"objects"`
+	Users   []models.User   `json:"users"`
+	Access  []struct {
+		UserID    int `json:"userId"`
+		GroupBkey int `json:"groupBkey"`
+	} `json:"access"`
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:        make(map[int]models.User),
+		access:       make(map[int]models.UserAccess),
+		groups:       make(map[int]models.Group),
+		rules:        make(map[int]models.AccessRule),
+		ruleGrants:   make(map[int]int),
+		roles:        make(map[int]models.Role),
+		roleMembers:  make(map[roleMember]time.Time),
+		roleGrants:   make(map[int]int),
+		nextUserID:   1,
+		nextAccessID: 1,
+		nextRuleID:   1,
+		nextRoleID:   1,
+	}
+}
+
+// OpenMemoryDB returns a database seeded from the JSON file at seedPath,
+// or with the demo data when seedPath is empty
+func OpenMemoryDB(seedPath string) (*MemoryDB, error) {
+	m := NewMemoryDB()
+	if seedPath == "" {
+		m.SeedDemo()
+		return m, nil
+	}
+	if err := m.LoadSeed(seedPath); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadSeed reads a JSON seed file into the database
+func (m *MemoryDB) LoadSeed(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read seed file: %w", err)
+	}
+
+	var seed MemorySeed
+	if err := json.Unmarshal(data, &seed); err != nil {
+		return fmt.Errorf("failed to parse seed file: %w", err)
+	}
+
+	m.Seed(seed)
+	return nil
+}
+
+// Seed adds the given rows to the database. Users keep their IDs when set.
+func (m *MemoryDB) Seed(seed MemorySeed) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, g := range seed.Groups {
+		m.groups[g.GroupBkey] = g
+	}
+	m.objects = append(m.objects, seed.Objects...)
+
+	for _, u := range seed.Users {
+		if u.PowerBIUserID == 0 {
+			u.PowerBIUserID = m.nextUserID
+		}
+		if u.PowerBIUserID >= m.nextUserID {
+			m.nextUserID = u.PowerBIUserID + 1
+		}
+		m.users[u.PowerBIUserID] = u
+	}
+
+	for _, a := range seed.Access {
+		m.insertAccess(a.UserID, a.GroupBkey)
+	}
+}
+
+// SeedDemo fills the database with a small sample organisation
+func (m *MemoryDB) SeedDemo() {
+	m.Seed(MemorySeed{
+		Groups: []models.Group{
+			{GroupBkey: 1001, GroupName: "Noord - Verkoop"},
+			{GroupBkey: 1002, GroupName: "Noord - Inkoop"},
+			{GroupBkey: 1003, GroupName: "Zuid - Verkoop"},
+			{GroupBkey: 1004, GroupName: "Zuid - Financien"},
+			{GroupBkey: 1005, GroupName: "Holding - Financien"},
+		},
+		Objects: []models.Object{
+			{ObjectName: "Vestiging Groningen", Level1Name: "Tascon", Level2Name: "Regio Noord", Level3Name: "Verkoop", GroupBkey: 1001},
+			{ObjectName: "Vestiging Leeuwarden", Level1Name: "Tascon", Level2Name: "Regio Noord", Level3Name: "Verkoop", GroupBkey: 1001},
+			{ObjectName: "Inkoop Noord", Level1Name: "Tascon", Level2Name: "Regio Noord", Level3Name: "Inkoop", GroupBkey: 1002},
+			{ObjectName: "Vestiging Eindhoven", Level1Name: "Tascon", Level2Name: "Regio Zuid", Level3Name: "Verkoop", GroupBkey: 1003},
+			{ObjectName: "Administratie Zuid", Level1Name: "Tascon", Level2Name: "Regio Zuid", Level3Name: "Financien", GroupBkey: 1004},
+			{ObjectName: "Concernadministratie", Level1Name: "Tascon", Level2Name: "Holding", Level3Name: "Financien", GroupBkey: 1005},
+		},
+		Users: []models.User{
+			{PowerBIUser: "anna@voorbeeld.nl"},
+			{PowerBIUser: "bert@voorbeeld.nl"},
+		},
+	})
+}
+
+// insertAccess adds an access row; the caller must hold the write lock
+func (m *MemoryDB) insertAccess(userID int, groupBkey int) models.UserAccess {
+	a := models.UserAccess{
+		UserAccessID: m.nextAccessID,
+		UserID:       userID,
+		GroupBkey:    groupBkey,
+		CreationDate: time.Now(),
+	}
+	m.access[a.UserAccessID] = a
+	m.nextAccessID++
+	return a
+}
+
+// hasAccess reports whether the user has the group; the caller must hold a lock
+func (m *MemoryDB) hasAccess(userID int, groupBkey int) bool {
+	for _, a := range m.access {
+		if a.UserID == userID && a.GroupBkey == groupBkey {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteAccess removes an access row along with the rows that reference it,
+// like the ON DELETE CASCADE keys do; the caller must hold the write lock
+func (m *MemoryDB) deleteAccess(accessID int) {
+	delete(m.access, accessID)
+	delete(m.ruleGrants, accessID)
+	delete(m.roleGrants, accessID)
+}
+
+// deleteRoleMemberships removes a user from every role; the caller must hold the write lock
+func (m *MemoryDB) deleteRoleMemberships(userID int) {
+	for member := range m.roleMembers {
+		if member.userID == userID {
+			delete(m.roleMembers, member)
+		}
+	}
+}
+
+// containsFold mimics a LIKE '%term%' match under a case-insensitive collation
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+type MemoryUserRepository struct {
+	db *MemoryDB
+}
+
+func NewMemoryUserRepository(db *MemoryDB) *MemoryUserRepository {
+	return &MemoryUserRepository{db: db}
+}
+
+func (r *MemoryUserRepository) List(ctx context.Context, opts UserListOptions) ([]models.User, int, error) {
+	r.db.mu.RLock()
+	var users []models.User
+	for _, u := range r.db.users {
+		if opts.Filter == "" || containsFold(u.PowerBIUser, opts.Filter) {
+			users = append(users, u)
+		}
+	}
+	r.db.mu.RUnlock()
+
+	less := func(a, b models.User) bool {
+		if opts.SortField != "id" {
+			ae, be := strings.ToLower(a.PowerBIUser), strings.ToLower(b.PowerBIUser)
+			if ae != be {
+				return ae < be
+			}
+		}
+		return a.PowerBIUserID < b.PowerBIUserID
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if opts.SortDir == "desc" {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+
+	total := len(users)
+	if opts.Limit > 0 {
+		start := min(max(opts.Offset, 0), total)
+		end := min(start+opts.Limit, total)
+		users = users[start:end]
+	}
+
+	return users, total, nil
+}
+
+func (r *MemoryUserRepository) GetByID(ctx context.Context, id int) (*models.User, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	u, ok := r.db.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, email string) (int, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	id := r.db.nextUserID
+	r.db.users[id] = models.User{PowerBIUserID: id, PowerBIUser: email}
+	r.db.nextUserID++
+	return id, nil
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, id int, email string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	u, ok := r.db.users[id]
+	if !ok {
+		return fmt.Errorf("user not found")
+	}
+	u.PowerBIUser = email
+	r.db.users[id] = u
+	return nil
+}
+
+func (r *MemoryUserRepository) Delete(ctx context.Context, id int) (int, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, ok := r.db.users[id]; !ok {
+		return 0, fmt.Errorf("user not found")
+	}
+
+	for ruleID, rule := range r.db.rules {
+		if rule.UserID == id {
+			delete(r.db.rules, ruleID)
+		}
+	}
+	r.db.deleteRoleMemberships(id)
+
+	var accessRemoved int
+	for accessID, a := range r.db.access {
+		if a.UserID == id {
+			r.db.deleteAccess(accessID)
+			accessRemoved++
+		}
+	}
+
+	delete(r.db.users, id)
+	return accessRemoved, nil
+}
+
+type MemoryAccessRepository struct {
+	db *MemoryDB
+}
+
+func NewMemoryAccessRepository(db *MemoryDB) *MemoryAccessRepository {
+	return &MemoryAccessRepository{db: db}
+}
+
+func (r *MemoryAccessRepository) ListByUser(ctx context.Context, userID int, includeOrphaned bool) ([]models.UserAccess, error) {
+	r.db.mu.RLock()
+	var accessList []models.UserAccess
+	for _, a := range r.db.access {
+		if a.UserID != userID {
+			continue
+		}
+		// Rows without a matching group are dropped, like the INNER JOIN, unless requested
+		g, ok := r.db.groups[a.GroupBkey]
+		if !ok && !includeOrphaned {
+			continue
+		}
+		a.GroupName = g.GroupName
+		a.Orphaned = !ok
+		accessList = append(accessList, a)
+	}
+	r.db.mu.RUnlock()
+
+	sort.Slice(accessList, func(i, j int) bool {
+		a, b := accessList[i], accessList[j]
+		if a.Orphaned != b.Orphaned {
+			return b.Orphaned
+		}
+		if a.GroupName != b.GroupName {
+			return a.GroupName < b.GroupName
+		}
+		return a.GroupBkey < b.GroupBkey
+	})
+
+	return accessList, nil
+}
+
+func (r *MemoryAccessRepository) ListOrphaned(ctx context.Context) ([]models.OrphanedAccess, error) {
+	r.db.mu.RLock()
+	orphaned := r.db.orphanedAccess(nil)
+	r.db.mu.RUnlock()
+	return orphaned, nil
+}
+
+func (r *MemoryAccessRepository) RemoveOrphaned(ctx context.Context, accessIDs []int) ([]models.OrphanedAccess, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	removed := r.db.orphanedAccess(accessIDs)
+	for _, o := range removed {
+		r.db.deleteAccess(o.UserAccessID)
+	}
+	return removed, nil
+}
+
+// orphanedAccess lists access rows without a group, limited to accessIDs when given.
+// The caller must hold the lock.
+func (m *MemoryDB) orphanedAccess(accessIDs []int) []models.OrphanedAccess {
+	var wanted map[int]bool
+	if len(accessIDs) > 0 {
+		wanted = make(map[int]bool, len(accessIDs))
+		for _, id := range accessIDs {
+			wanted[id] = true
+		}
+	}
+
+	var orphaned []models.OrphanedAccess
+	for _, a := range m.access {
+		if _, ok := m.groups[a.GroupBkey]; ok {
+			continue
+		}
+		if wanted != nil && !wanted[a.UserAccessID] {
+			continue
+		}
+		orphaned = append(orphaned, models.OrphanedAccess{
+			UserAccessID: a.UserAccessID,
+			UserID:       a.UserID,
+			UserEmail:    m.users[a.UserID].PowerBIUser,
+			GroupBkey:    a.GroupBkey,
+			CreationDate: a.CreationDate,
+		})
+	}
+
+	sort.Slice(orphaned, func(i, j int) bool {
+		if orphaned[i].GroupBkey != orphaned[j].GroupBkey {
+			return orphaned[i].GroupBkey < orphaned[j].GroupBkey
+		}
+		return orphaned[i].UserEmail < orphaned[j].UserEmail
+	})
+
+	return orphaned
+}
+
+func (r *MemoryAccessRepository) ListByGroup(ctx context.Context, groupBkey int) ([]models.GroupMember, error) {
+	r.db.mu.RLock()
+	var members []models.GroupMember
+	for _, a := range r.db.access {
+		if a.GroupBkey != groupBkey {
+			continue
+		}
+		u, ok := r.db.users[a.UserID]
+		if !ok {
+			continue
+		}
+		members = append(members, models.GroupMember{
+			UserAccessID: a.UserAccessID,
+			UserID:       u.PowerBIUserID,
+			Email:        u.PowerBIUser,
+			CreationDate: a.CreationDate,
+			ValidUntil:   a.ValidUntil,
+		})
+	}
+	r.db.mu.RUnlock()
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Email < members[j].Email
+	})
+
+	return members, nil
+}
+
+func (r *MemoryAccessRepository) GetByID(ctx context.Context, accessID int) (*models.UserAccess, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	a, ok := r.db.access[accessID]
+	if !ok {
+		return nil, nil
+	}
+	a.GroupName = r.db.groups[a.GroupBkey].GroupName
+	return &a, nil
+}
+
+func (r *MemoryAccessRepository) AddGroups(ctx context.Context, userID int, groupBkeys []int) error {
+	if len(groupBkeys) == 0 {
+		return nil
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	for _, groupBkey := range groupBkeys {
+		r.db.insertAccess(userID, groupBkey)
+	}
+
+	return nil
+}
+
+func (r *MemoryAccessRepository) Grant(ctx context.Context, userID int, groupBkeys []int) (*models.GrantResult, error) {
+	return r.GrantWithExpiry(ctx, userID, models.PermanentGrants(groupBkeys))
+}
+
+func (r *MemoryAccessRepository) GrantWithExpiry(ctx context.Context, userID int, grants []models.Grant) (*models.GrantResult, error) {
+	result := &models.GrantResult{Added: []int{}, Skipped: []int{}, Unknown: []int{}}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	existing := make(map[int]bool)
+	for _, a := range r.db.access {
+		if a.UserID == userID {
+			existing[a.GroupBkey] = true
+		}
+	}
+
+	for _, g := range uniqueGrants(grants) {
+		_, known := r.db.groups[g.GroupBkey]
+		switch {
+		case !known:
+			result.Unknown = append(result.Unknown, g.GroupBkey)
+		case existing[g.GroupBkey]:
+			result.Skipped = append(result.Skipped, g.GroupBkey)
+		default:
+			a := r.db.insertAccess(userID, g.GroupBkey)
+			if g.ValidUntil != nil {
+				until := *g.ValidUntil
+				a.ValidUntil = &until
+				r.db.access[a.UserAccessID] = a
+			}
+			result.Added = append(result.Added, g.GroupBkey)
+		}
+	}
+
+	return result, nil
+}
+
+func (r *MemoryAccessRepository) RemoveExpired(ctx context.Context, now time.Time) ([]models.UserAccess, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	var removed []models.UserAccess
+	for accessID, a := range r.db.access {
+		if a.ValidUntil != nil && !a.ValidUntil.After(now) && !r.db.roleCovers(a.UserID, a.GroupBkey) {
+			r.db.deleteAccess(accessID)
+			a.GroupName = r.db.groups[a.GroupBkey].GroupName
+			removed = append(removed, a)
+		}
+	}
+
+	return removed, nil
+}
+
+func (r *MemoryAccessRepository) Remove(ctx context.Context, accessID int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, ok := r.db.access[accessID]; !ok {
+		return fmt.Errorf("access record not found")
+	}
+	r.db.deleteAccess(accessID)
+	return nil
+}
+
+func (r *MemoryAccessRepository) Exists(ctx context.Context, userID int, groupBkey int) (bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	return r.db.hasAccess(userID, groupBkey), nil
+}
+
+type MemoryGroupRepository struct {
+	db *MemoryDB
+}
+
+func NewMemoryGroupRepository(db *MemoryDB) *MemoryGroupRepository {
+	return &MemoryGroupRepository{db: db}
+}
+
+func (r *MemoryGroupRepository) List(ctx context.Context, opts GroupListOptions) ([]models.GroupSummary, int, error) {
+	r.db.mu.RLock()
+	memberCounts := make(map[int]int)
+	for _, a := range r.db.access {
+		memberCounts[a.GroupBkey]++
+	}
+	objectCounts := make(map[int]int)
+	for _, o := range r.db.objects {
+		objectCounts[o.GroupBkey]++
+	}
+
+	var groups []models.GroupSummary
+	for _, g := range r.db.groups {
+		if opts.Filter != "" && !containsFold(g.GroupName, opts.Filter) && strconv.Itoa(g.GroupBkey) != opts.Filter {
+			continue
+		}
+		groups = append(groups, models.GroupSummary{
+			GroupBkey:   g.GroupBkey,
+			GroupName:   g.GroupName,
+			MemberCount: memberCounts[g.GroupBkey],
+			ObjectCount: objectCounts[g.GroupBkey],
+		})
+	}
+	r.db.mu.RUnlock()
+
+	less := func(a, b models.GroupSummary) bool {
+		switch opts.SortField {
+		case "bkey":
+		case "members":
+			if a.MemberCount != b.MemberCount {
+				return a.MemberCount < b.MemberCount
+			}
+		case "objects":
+			if a.ObjectCount != b.ObjectCount {
+				return a.ObjectCount < b.ObjectCount
+			}
+		default:
+			if a.GroupName != b.GroupName {
+				return a.GroupName < b.GroupName
+			}
+		}
+		return a.GroupBkey < b.GroupBkey
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if opts.SortDir == "desc" {
+			return less(groups[j], groups[i])
+		}
+		return less(groups[i], groups[j])
+	})
+
+	total := len(groups)
+	if opts.Limit > 0 {
+		start := min(max(opts.Offset, 0), total)
+		end := min(start+opts.Limit, total)
+		groups = groups[start:end]
+	}
+
+	return groups, total, nil
+}
+
+func (r *MemoryGroupRepository) Search(ctx context.Context, searchTerm string, level2Scope []string) ([]models.SearchResult, error) {
+	if searchTerm == "" {
+		return nil, nil
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	// Compared case-insensitively, like the IN filter under the default collation
+	inScope := make(map[string]bool, len(level2Scope))
+	for _, name := range level2Scope {
+		inScope[strings.ToLower(name)] = true
+	}
+
+	results := newSearchResults()
+	hasObjects := make(map[int]bool)
+	for _, o := range r.db.objects {
+		g, ok := r.db.groups[o.GroupBkey]
+		if !ok || (len(inScope) > 0 && !inScope[strings.ToLower(o.Level2Name)]) {
+			continue
+		}
+		hasObjects[g.GroupBkey] = true
+		results.add(searchRow{
+			groupBkey:  g.GroupBkey,
+			groupName:  g.GroupName,
+			objectName: o.ObjectName,
+			scores: map[string]int{
+				"groupbkey":  bkeyScore(g.GroupBkey, searchTerm),
+				"groupname":  matchScore(g.GroupName, searchTerm),
+				"level2name": matchScore(o.Level2Name, searchTerm),
+				"level3name": matchScore(o.Level3Name, searchTerm),
+			},
+		})
+	}
+
+	// Groups without objects can still match on key or name, like the LEFT JOIN,
+	// unless a scope is given
+	for _, g := range r.db.groups {
+		if hasObjects[g.GroupBkey] || len(inScope) > 0 {
+			continue
+		}
+		results.add(searchRow{
+			groupBkey: g.GroupBkey,
+			groupName: g.GroupName,
+			scores: map[string]int{
+				"groupbkey": bkeyScore(g.GroupBkey, searchTerm),
+				"groupname": matchScore(g.GroupName, searchTerm),
+			},
+		})
+	}
+
+	return results.ranked(), nil
+}
+
+func (r *MemoryGroupRepository) GroupsUnder(ctx context.Context, level2Name string, level3Name string) ([]int, error) {
+	if level2Name == "" && level3Name == "" {
+		return nil, fmt.Errorf("a Level2 or Level3 name is required")
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	seen := make(map[int]bool)
+	var groupBkeys []int
+	for _, o := range r.db.objects {
+		if level2Name != "" && !strings.EqualFold(o.Level2Name, level2Name) {
+			continue
+		}
+		if level3Name != "" && !strings.EqualFold(o.Level3Name, level3Name) {
+			continue
+		}
+		if _, ok := r.db.groups[o.GroupBkey]; !ok || seen[o.GroupBkey] {
+			continue
+		}
+		seen[o.GroupBkey] = true
+		groupBkeys = append(groupBkeys, o.GroupBkey)
+	}
+
+	sort.Ints(groupBkeys)
+	return groupBkeys, nil
+}
+
+func (r *MemoryGroupRepository) Units(ctx context.Context, groupBkeys []int) (map[int][]models.OrgUnit, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	wanted := make(map[int]bool, len(groupBkeys))
+	for _, groupBkey := range groupBkeys {
+		wanted[groupBkey] = true
+	}
+
+	units := make(map[int][]models.OrgUnit)
+	for _, o := range r.db.objects {
+		if !wanted[o.GroupBkey] {
+			continue
+		}
+		u := models.OrgUnit{Level2Name: o.Level2Name, Level3Name: o.Level3Name}
+		if !slices.Contains(units[o.GroupBkey], u) {
+			units[o.GroupBkey] = append(units[o.GroupBkey], u)
+		}
+	}
+
+	for _, list := range units {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Level2Name != list[j].Level2Name {
+				return list[i].Level2Name < list[j].Level2Name
+			}
+			return list[i].Level3Name < list[j].Level3Name
+		})
+	}
+	return units, nil
+}
+
+func (r *MemoryGroupRepository) GetByBkey(ctx context.Context, groupBkey int) (*models.Group, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	g, ok := r.db.groups[groupBkey]
+	if !ok {
+		return nil, nil
+	}
+	return &g, nil
+}
+
+func (r *MemoryGroupRepository) GetByBkeys(ctx context.Context, groupBkeys []int) (map[int]models.Group, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	groups := make(map[int]models.Group)
+	for _, groupBkey := range groupBkeys {
+		if g, ok := r.db.groups[groupBkey]; ok {
+			groups[groupBkey] = g
+		}
+	}
+	return groups, nil
+}
+
+func (r *MemoryGroupRepository) Children(ctx context.Context, path []string, userID int) ([]models.TreeNode, error) {
+	if len(path) > len(treeLevels) {
+		return nil, fmt.Errorf("invalid tree path: too many levels")
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	granted := make(map[int]bool)
+	for _, a := range r.db.access {
+		if a.UserID == userID {
+			granted[a.GroupBkey] = true
+		}
+	}
+
+	// Collect the distinct groups below each child node
+	children := make(map[string]map[int]bool)
+	for _, o := range r.db.objects {
+		if _, ok := r.db.groups[o.GroupBkey]; !ok {
+			continue
+		}
+		levels := []string{o.Level1Name, o.Level2Name, o.Level3Name}
+		if !slices.Equal(levels[:len(path)], path) {
+			continue
+		}
+
+		var key string
+		if len(path) < len(levels) {
+			key = levels[len(path)]
+		} else {
+			key = strconv.Itoa(o.GroupBkey)
+		}
+		if children[key] == nil {
+			children[key] = make(map[int]bool)
+		}
+		children[key][o.GroupBkey] = true
+	}
+
+	var nodes []models.TreeNode
+	for key, groups := range children {
+		var n models.TreeNode
+		if len(path) < len(treeLevels) {
+			n = models.TreeNode{Level: treeLevels[len(path)].name, Name: key}
+		} else {
+			groupBkey, _ := strconv.Atoi(key)
+			n = models.TreeNode{Level: "group", Name: r.db.groups[groupBkey].GroupName, GroupBkey: groupBkey}
+		}
+		for groupBkey := range groups {
+			n.GroupCount++
+			if granted[groupBkey] {
+				n.GrantedCount++
+			}
+		}
+		nodes = append(nodes, n)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Name != nodes[j].Name {
+			return nodes[i].Name < nodes[j].Name
+		}
+		return nodes[i].GroupBkey < nodes[j].GroupBkey
+	})
+
+	return nodes, nil
+}
+
+type MemoryRuleRepository struct {
+	db *MemoryDB
+}
+
+func NewMemoryRuleRepository(db *MemoryDB) *MemoryRuleRepository {
+	return &MemoryRuleRepository{db: db}
+}
+
+func (r *MemoryRuleRepository) List(ctx context.Context, userID int) ([]models.AccessRule, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	return r.db.listRules(userID), nil
+}
+
+// listRules returns the rules sorted like the SQL query; the caller must hold a lock
+func (m *MemoryDB) listRules(userID int) []models.AccessRule {
+	var rules []models.AccessRule
+	for _, rule := range m.rules {
+		if userID != 0 && rule.UserID != userID {
+			continue
+		}
+		rule.UserEmail = m.users[rule.UserID].PowerBIUser
+		rules = append(rules, rule)
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := strings.ToLower(rules[i].UserEmail), strings.ToLower(rules[j].UserEmail)
+		if a != b {
+			return a < b
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules
+}
+
+func (r *MemoryRuleRepository) Create(ctx context.Context, rule models.AccessRule) (int, error) {
+	if rule.Level2Name == "" && rule.Level3Name == "" {
+		return 0, fmt.Errorf("a Level2 or Level3 name is required")
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	rule.ID = r.db.nextRuleID
+	rule.UserEmail = ""
+	rule.CreationDate = time.Now().UTC()
+	r.db.rules[rule.ID] = rule
+	r.db.nextRuleID++
+	return rule.ID, nil
+}
+
+func (r *MemoryRuleRepository) Delete(ctx context.Context, id int) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, ok := r.db.rules[id]; !ok {
+		return fmt.Errorf("rule not found")
+	}
+	delete(r.db.rules, id)
+	for accessID, ruleID := range r.db.ruleGrants {
+		if ruleID == id {
+			delete(r.db.ruleGrants, accessID)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRuleRepository) Reconcile(ctx context.Context, dryRun bool) (*models.ReconcileReport, error) {
+	report := newReconcileReport(dryRun)
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	rules := r.db.listRules(0)
+	report.Rules = len(rules)
+	if len(rules) == 0 {
+		return report, nil
+	}
+
+	ruleUsers := make(map[int]bool)
+	var desired []models.ReconcileChange
+	for _, rule := range rules {
+		ruleUsers[rule.UserID] = true
+		if _, ok := r.db.users[rule.UserID]; !ok {
+			continue
+		}
+
+		seen := make(map[int]bool)
+		for _, o := range r.db.objects {
+			if rule.Level2Name != "" && !strings.EqualFold(o.Level2Name, rule.Level2Name) {
+				continue
+			}
+			if rule.Level3Name != "" && !strings.EqualFold(o.Level3Name, rule.Level3Name) {
+				continue
+			}
+			g, ok := r.db.groups[o.GroupBkey]
+			if !ok || seen[o.GroupBkey] {
+				continue
+			}
+			seen[o.GroupBkey] = true
+			desired = append(desired, models.ReconcileChange{
+				RuleID:    rule.ID,
+				UserID:    rule.UserID,
+				UserEmail: rule.UserEmail,
+				GroupBkey: g.GroupBkey,
+				GroupName: g.GroupName,
+			})
+		}
+	}
+
+	var existing []models.UserAccess
+	roleCovered := make(map[int]bool)
+	for _, a := range r.db.access {
+		if ruleUsers[a.UserID] {
+			a.GroupName = r.db.groups[a.GroupBkey].GroupName
+			existing = append(existing, a)
+			if r.db.roleCovers(a.UserID, a.GroupBkey) {
+				roleCovered[a.UserAccessID] = true
+			}
+		}
+	}
+
+	adds, removes := planReconcile(rules, desired, existing, r.db.ruleGrants, roleCovered)
+	report.Added = adds
+	report.Removed = removes
+
+	if dryRun {
+		return report, nil
+	}
+
+	for _, c := range adds {
+		a := r.db.insertAccess(c.UserID, c.GroupBkey)
+		r.db.ruleGrants[a.UserAccessID] = c.RuleID
+	}
+	for _, accessID := range staleAccessIDs(existing, r.db.ruleGrants, removes) {
+		r.db.deleteAccess(accessID)
+	}
+
+	return report, nil
+}
+
+type MemoryPlanRepository struct {
+	db *MemoryDB
+}
+
+func NewMemoryPlanRepository(db *MemoryDB) *MemoryPlanRepository {
+	return &MemoryPlanRepository{db: db}
+}
+
+// Apply carries out the plan on a copy of the data and only keeps the result
+// when every step succeeds, like the SQL transaction
+func (r *MemoryPlanRepository) Apply(ctx context.Context, plan *models.Plan) (models.CreatedUsers, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	users := maps.Clone(r.db.users)
+	access := maps.Clone(r.db.access)
+	rules := maps.Clone(r.db.rules)
+	ruleGrants := maps.Clone(r.db.ruleGrants)
+	roleMembers := maps.Clone(r.db.roleMembers)
+	roleGrants := maps.Clone(r.db.roleGrants)
+	nextUserID, nextAccessID := r.db.nextUserID, r.db.nextAccessID
+
+	created, err := r.apply(plan)
+	if err != nil {
+		r.db.users, r.db.access, r.db.rules, r.db.ruleGrants = users, access, rules, ruleGrants
+		r.db.roleMembers, r.db.roleGrants = roleMembers, roleGrants
+		r.db.nextUserID, r.db.nextAccessID = nextUserID, nextAccessID
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *MemoryPlanRepository) apply(plan *models.Plan) (models.CreatedUsers, error) {
+	created := make(models.CreatedUsers)
+
+	for _, c := range plan.Changes {
+		switch c.Action {
+		case models.PlanCreateUser:
+			id := r.db.nextUserID
+			r.db.users[id] = models.User{PowerBIUserID: id, PowerBIUser: c.Email}
+			r.db.nextUserID++
+			created[strings.ToLower(c.Email)] = id
+
+		case models.PlanGrant:
+			userID := created.UserID(c)
+			if _, ok := r.db.groups[c.GroupBkey]; !ok {
+				continue
+			}
+			if !r.db.hasAccess(userID, c.GroupBkey) {
+				a := r.db.insertAccess(userID, c.GroupBkey)
+				if c.ValidUntil != nil {
+					until := *c.ValidUntil
+					a.ValidUntil = &until
+					r.db.access[a.UserAccessID] = a
+				}
+			}
+
+		case models.PlanRevoke:
+			if a, ok := r.db.access[c.AccessID]; ok && a.UserID == c.UserID {
+				r.db.deleteAccess(c.AccessID)
+			}
+
+		case models.PlanDeleteUser:
+			for ruleID, rule := range r.db.rules {
+				if rule.UserID == c.UserID {
+					delete(r.db.rules, ruleID)
+				}
+			}
+			r.db.deleteRoleMemberships(c.UserID)
+			for accessID, a := range r.db.access {
+				if a.UserID == c.UserID {
+					r.db.deleteAccess(accessID)
+				}
+			}
+			delete(r.db.users, c.UserID)
+
+		default:
+			return nil, fmt.Errorf("unknown plan action %q", c.Action)
+		}
+	}
+	return created, nil
+}
+
+type MemoryRoleRepository struct {
+	db *MemoryDB
+}
+
+func NewMemoryRoleRepository(db *MemoryDB) *MemoryRoleRepository {
+	return &MemoryRoleRepository{db: db}
+}
+
+func (r *MemoryRoleRepository) List(ctx context.Context) ([]models.Role, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	return r.db.listRoles(0, 0), nil
+}
+
+func (r *MemoryRoleRepository) ListByUser(ctx context.Context, userID int) ([]models.Role, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	return r.db.listRoles(0, userID), nil
+}
+
+func (r *MemoryRoleRepository) GetByID(ctx context.Context, id int) (*models.Role, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	roles := r.db.listRoles(id, 0)
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	return &roles[0], nil
+}
+
+// listRoles returns the roles sorted like the SQL query; the caller must hold a lock
+func (m *MemoryDB) listRoles(roleID, userID int) []models.Role {
+	roles := []models.Role{}
+	for _, role := range m.roles {
+		if roleID != 0 && role.ID != roleID {
+			continue
+		}
+		if _, ok := m.roleMembers[roleMember{role.ID, userID}]; userID != 0 && !ok {
+			continue
+		}
+
+		groups := make([]models.Group, len(role.Groups))
+		for i, g := range role.Groups {
+			groups[i] = models.Group{GroupBkey: g.GroupBkey, GroupName: m.groups[g.GroupBkey].GroupName}
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			a, b := strings.ToLower(groups[i].GroupName), strings.ToLower(groups[j].GroupName)
+			if a != b {
+				return a < b
+			}
+			return groups[i].GroupBkey < groups[j].GroupBkey
+		})
+		role.Groups = groups
+
+		for member := range m.roleMembers {
+			if member.roleID == role.ID {
+				role.MemberCount++
+			}
+		}
+		roles = append(roles, role)
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return strings.ToLower(roles[i].Name) < strings.ToLower(roles[j].Name)
+	})
+	return roles
+}
+
+// roleNameTaken mimics the unique key on Name; the caller must hold a lock
+func (m *MemoryDB) roleNameTaken(name string, exceptID int) bool {
+	for _, role := range m.roles {
+		if role.ID != exceptID && strings.EqualFold(role.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryRoleRepository) Create(ctx context.Context, name, description string, groupBkeys []int) (int, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if r.db.roleNameTaken(name, 0) {
+		return 0, fmt.Errorf("failed to create role: name %q is already in use", name)
+	}
+
+	role := models.Role{
+		ID:           r.db.nextRoleID,
+		Name:         name,
+		Description:  description,
+		Groups:       bkeyGroups(groupBkeys),
+		CreationDate: time.Now().UTC(),
+	}
+	r.db.roles[role.ID] = role
+	r.db.nextRoleID++
+	return role.ID, nil
+}
+
+func (r *MemoryRoleRepository) Update(ctx context.Context, id int, name, description string, groupBkeys []int) (*models.RoleSync, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	role, ok := r.db.roles[id]
+	if !ok {
+		return nil, fmt.Errorf("role not found")
+	}
+	if r.db.roleNameTaken(name, id) {
+		return nil, fmt.Errorf("failed to update role: name %q is already in use", name)
+	}
+
+	role.Name = name
+	role.Description = description
+	role.Groups = bkeyGroups(groupBkeys)
+	r.db.roles[id] = role
+
+	return r.db.syncRoleUsers(r.db.roleMemberIDs(id)), nil
+}
+
+func (r *MemoryRoleRepository) Delete(ctx context.Context, id int) (*models.RoleSync, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if _, ok := r.db.roles[id]; !ok {
+		return nil, fmt.Errorf("role not found")
+	}
+
+	userIDs := r.db.roleMemberIDs(id)
+	for _, userID := range userIDs {
+		delete(r.db.roleMembers, roleMember{id, userID})
+	}
+	sync := r.db.syncRoleUsers(userIDs)
+
+	for accessID, roleID := range r.db.roleGrants {
+		if roleID == id {
+			delete(r.db.roleGrants, accessID)
+		}
+	}
+	delete(r.db.roles, id)
+	return sync, nil
+}
+
+func (r *MemoryRoleRepository) ListMembers(ctx context.Context, roleID int) ([]models.RoleMember, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var members []models.RoleMember
+	for member, assignedAt := range r.db.roleMembers {
+		user, ok := r.db.users[member.userID]
+		if member.roleID != roleID || !ok {
+			continue
+		}
+		members = append(members, models.RoleMember{UserID: user.PowerBIUserID, Email: user.PowerBIUser, AssignedAt: assignedAt})
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return strings.ToLower(members[i].Email) < strings.ToLower(members[j].Email)
+	})
+	return members, nil
+}
+
+func (r *MemoryRoleRepository) Assign(ctx context.Context, roleID, userID int) (*models.RoleSync, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	member := roleMember{roleID, userID}
+	if _, ok := r.db.roleMembers[member]; !ok {
+		r.db.roleMembers[member] = time.Now().UTC()
+	}
+	return r.db.syncRoleUsers([]int{userID}), nil
+}
+
+func (r *MemoryRoleRepository) Unassign(ctx context.Context, roleID, userID int) (*models.RoleSync, error) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	member := roleMember{roleID, userID}
+	if _, ok := r.db.roleMembers[member]; !ok {
+		return nil, fmt.Errorf("role member not found")
+	}
+	delete(r.db.roleMembers, member)
+	return r.db.syncRoleUsers([]int{userID}), nil
+}
+
+// roleMemberIDs returns the users a role is assigned to; the caller must hold a lock
+func (m *MemoryDB) roleMemberIDs(roleID int) []int {
+	var userIDs []int
+	for member := range m.roleMembers {
+		if member.roleID == roleID {
+			userIDs = append(userIDs, member.userID)
+		}
+	}
+	sort.Ints(userIDs)
+	return userIDs
+}
+
+// roleCovers reports whether one of the user's roles covers the group; the caller
+// must hold a lock
+func (m *MemoryDB) roleCovers(userID, groupBkey int) bool {
+	for member := range m.roleMembers {
+		if member.userID != userID {
+			continue
+		}
+		for _, g := range m.roles[member.roleID].Groups {
+			if g.GroupBkey == groupBkey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// syncRoleUsers brings the access of the given users in line with their current
+// roles, like the SQL version; the caller must hold the write lock
+func (m *MemoryDB) syncRoleUsers(userIDs []int) *models.RoleSync {
+	sync := newRoleSync()
+	if len(userIDs) == 0 {
+		return sync
+	}
+
+	inScope := make(map[int]bool, len(userIDs))
+	emails := make(map[int]string, len(userIDs))
+	for _, id := range userIDs {
+		inScope[id] = true
+		emails[id] = m.users[id].PowerBIUser
+	}
+
+	var desired []models.RoleChange
+	for member := range m.roleMembers {
+		if !inScope[member.userID] {
+			continue
+		}
+		for _, g := range m.roles[member.roleID].Groups {
+			group, ok := m.groups[g.GroupBkey]
+			if !ok {
+				continue
+			}
+			desired = append(desired, models.RoleChange{
+				RoleID:    member.roleID,
+				UserID:    member.userID,
+				UserEmail: emails[member.userID],
+				GroupBkey: group.GroupBkey,
+				GroupName: group.GroupName,
+			})
+		}
+	}
+
+	current := &roleAccess{tracked: m.roleGrants, ruleTracked: m.ruleGrants, emails: emails}
+	for _, a := range m.access {
+		if inScope[a.UserID] {
+			a.GroupName = m.groups[a.GroupBkey].GroupName
+			current.existing = append(current.existing, a)
+		}
+	}
+
+	plan := planRoleSync(desired, current)
+
+	for _, c := range plan.adds {
+		a := m.insertAccess(c.UserID, c.GroupBkey)
+		m.roleGrants[a.UserAccessID] = c.RoleID
+	}
+	for accessID, roleID := range plan.retarget {
+		m.roleGrants[accessID] = roleID
+	}
+	for accessID, roleID := range plan.adopt {
+		m.roleGrants[accessID] = roleID
+	}
+	for _, accessID := range plan.release {
+		delete(m.roleGrants, accessID)
+	}
+	for _, accessID := range roleStaleAccessIDs(current.existing, m.roleGrants, plan.removes) {
+		m.deleteAccess(accessID)
+	}
+
+	sync.Added = plan.adds
+	sync.Removed = plan.removes
+	return sync
+}
+
+// bkeyGroups turns group keys into groups without names, dropping duplicates
+func bkeyGroups(groupBkeys []int) []models.Group {
+	groupBkeys = uniqueInts(groupBkeys)
+	groups := make([]models.Group, len(groupBkeys))
+	for i, groupBkey := range groupBkeys {
+		groups[i] = models.Group{GroupBkey: groupBkey}
+	}
+	return groups
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"powerbi-access-tool/models"
+)
+
+type PlanRepository struct {
+	db *sql.DB
+}
+
+func NewPlanRepository(db *sql.DB) *PlanRepository {
+	return &PlanRepository{db: db}
+}
+
+// Apply carries out a desired-state plan in a single transaction. Grants and revokes
+// that were already made by someone else since the plan was computed are skipped.
+func (r *PlanRepository) Apply(ctx context.Context, plan *models.Plan) (models.CreatedUsers, error) {
+	created := make(models.CreatedUsers)
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		for _, c := range plan.Changes {
+			switch c.Action {
+			case models.PlanCreateUser:
+				var id int
+				query := `INSERT INTO powerbi.Users (PowerBIUser) OUTPUT INSERTED.PowerBIUserID VALUES (@p1)`
+				if err := tx.QueryRowContext(ctx, query, c.Email).Scan(&id); err != nil {
+					return fmt.Errorf("failed to create user %s: %w", c.Email, err)
+				}
+				created[strings.ToLower(c.Email)] = id
+
+			case models.PlanGrant:
+				userID := created.UserID(c)
+				query := `
+					INSERT INTO powerbi.UserAccess (UserID, Group_Bkey)
+					OUTPUT INSERTED.UserAccessID
+					SELECT @p1, g.Group_Bkey
+					FROM dim.[Group] g
+					WHERE g.Group_Bkey = @p2
+						AND NOT EXISTS (
+							SELECT 1 FROM powerbi.UserAccess ua WITH (UPDLOCK, HOLDLOCK)
+							WHERE ua.UserID = @p1 AND ua.Group_Bkey = @p2
+						)`
+				var accessID int
+				err := tx.QueryRowContext(ctx, query, userID, c.GroupBkey).Scan(&accessID)
+				if err == sql.ErrNoRows {
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed to grant group %d to %s: %w", c.GroupBkey, c.Email, err)
+				}
+
+				if c.ValidUntil != nil {
+					query := `INSERT INTO powerbi.UserAccessExpiry (UserAccessID, ValidUntil) VALUES (@p1, @p2)`
+					if _, err := tx.ExecContext(ctx, query, accessID, *c.ValidUntil); err != nil {
+						return fmt.Errorf("failed to store expiry of group %d for %s: %w", c.GroupBkey, c.Email, err)
+					}
+				}
+
+			case models.PlanRevoke:
+				query := `DELETE FROM powerbi.UserAccess WHERE UserAccessID = @p1 AND UserID = @p2`
+				if _, err := tx.ExecContext(ctx, query, c.AccessID, c.UserID); err != nil {
+					return fmt.Errorf("failed to revoke group %d from %s: %w", c.GroupBkey, c.Email, err)
+				}
+
+			case models.PlanDeleteUser:
+				for _, query := range []string{
+					`DELETE FROM powerbi.AccessRule WHERE UserID = @p1`,
+					`DELETE FROM powerbi.AccessRoleMember WHERE UserID = @p1`,
+					`DELETE FROM powerbi.UserAccess WHERE UserID = @p1`,
+					`DELETE FROM powerbi.Users WHERE PowerBIUserID = @p1`,
+				} {
+					if _, err := tx.ExecContext(ctx, query, c.UserID); err != nil {
+						return fmt.Errorf("failed to delete user %s: %w", c.Email, err)
+					}
+				}
+
+			default:
+				return fmt.Errorf("unknown plan action %q", c.Action)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
@@ -0,0 +1,667 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"powerbi-access-tool/models"
+)
+
+type RoleRepository struct {
+	db *sql.DB
+}
+
+func NewRoleRepository(db *sql.DB) *RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+// List returns every role with its groups and number of members
+func (r *RoleRepository) List(ctx context.Context) ([]models.Role, error) {
+	return listRoles(ctx, r.db, 0, 0)
+}
+
+// ListByUser returns the roles assigned to a user
+func (r *RoleRepository) ListByUser(ctx context.Context, userID int) ([]models.Role, error) {
+	return listRoles(ctx, r.db, 0, userID)
+}
+
+func (r *RoleRepository) GetByID(ctx context.Context, id int) (*models.Role, error) {
+	roles, err := listRoles(ctx, r.db, id, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	return &roles[0], nil
+}
+
+func (r *RoleRepository) Create(ctx context.Context, name, description string, groupBkeys []int) (int, error) {
+	var id int
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO powerbi.AccessRole (Name, Description)
+			OUTPUT INSERTED.RoleID
+			VALUES (@p1, @p2)`
+
+		if err := tx.QueryRowContext(ctx, query, name, nullString(description)).Scan(&id); err != nil {
+			return fmt.Errorf("failed to create role: %w", err)
+		}
+		return insertRoleGroups(ctx, tx, id, groupBkeys)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// Update renames a role and replaces its groups. Members are granted the groups
+// that were added; groups the role granted them that were dropped are revoked.
+func (r *RoleRepository) Update(ctx context.Context, id int, name, description string, groupBkeys []int) (*models.RoleSync, error) {
+	var sync *models.RoleSync
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		query := `UPDATE powerbi.AccessRole SET Name = @p1, Description = @p2 WHERE RoleID = @p3`
+
+		result, err := tx.ExecContext(ctx, query, name, nullString(description), id)
+		if err != nil {
+			return fmt.Errorf("failed to update role: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("role not found")
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM powerbi.AccessRoleGroup WHERE RoleID = @p1`, id); err != nil {
+			return fmt.Errorf("failed to clear role groups: %w", err)
+		}
+		if err := insertRoleGroups(ctx, tx, id, groupBkeys); err != nil {
+			return err
+		}
+
+		userIDs, err := roleMemberIDs(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		sync, err = syncRoleUsers(ctx, tx, userIDs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sync, nil
+}
+
+// Delete removes a role and revokes the access it granted, unless another role
+// of the same user also covers the group
+func (r *RoleRepository) Delete(ctx context.Context, id int) (*models.RoleSync, error) {
+	var sync *models.RoleSync
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		userIDs, err := roleMemberIDs(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM powerbi.AccessRoleMember WHERE RoleID = @p1`, id); err != nil {
+			return fmt.Errorf("failed to delete role members: %w", err)
+		}
+		if sync, err = syncRoleUsers(ctx, tx, userIDs); err != nil {
+			return err
+		}
+
+		// Whatever the role still tracks was not granted to a current member
+		if _, err := tx.ExecContext(ctx, `DELETE FROM powerbi.AccessRoleGrant WHERE RoleID = @p1`, id); err != nil {
+			return fmt.Errorf("failed to delete role grants: %w", err)
+		}
+
+		result, err := tx.ExecContext(ctx, `DELETE FROM powerbi.AccessRole WHERE RoleID = @p1`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("role not found")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sync, nil
+}
+
+func (r *RoleRepository) ListMembers(ctx context.Context, roleID int) ([]models.RoleMember, error) {
+	query := `
+		SELECT m.UserID, u.PowerBIUser, m.CreationDate
+		FROM powerbi.AccessRoleMember m
+		INNER JOIN powerbi.Users u ON u.PowerBIUserID = m.UserID
+		WHERE m.RoleID = @p1
+		ORDER BY u.PowerBIUser`
+
+	rows, err := r.db.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query role members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []models.RoleMember
+	for rows.Next() {
+		var m models.RoleMember
+		if err := rows.Scan(&m.UserID, &m.Email, &m.AssignedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role member: %w", err)
+		}
+		members = append(members, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating role members: %w", err)
+	}
+
+	return members, nil
+}
+
+// Assign makes the user a member of the role and grants the role's groups
+func (r *RoleRepository) Assign(ctx context.Context, roleID, userID int) (*models.RoleSync, error) {
+	var sync *models.RoleSync
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO powerbi.AccessRoleMember (RoleID, UserID)
+			SELECT @p1, @p2
+			WHERE NOT EXISTS (
+				SELECT 1 FROM powerbi.AccessRoleMember WITH (UPDLOCK, HOLDLOCK)
+				WHERE RoleID = @p1 AND UserID = @p2
+			)`
+
+		if _, err := tx.ExecContext(ctx, query, roleID, userID); err != nil {
+			return fmt.Errorf("failed to assign role: %w", err)
+		}
+
+		var err error
+		sync, err = syncRoleUsers(ctx, tx, []int{userID})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sync, nil
+}
+
+// Unassign removes the user from the role and revokes what the role granted
+func (r *RoleRepository) Unassign(ctx context.Context, roleID, userID int) (*models.RoleSync, error) {
+	var sync *models.RoleSync
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		query := `DELETE FROM powerbi.AccessRoleMember WHERE RoleID = @p1 AND UserID = @p2`
+
+		result, err := tx.ExecContext(ctx, query, roleID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to unassign role: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("role member not found")
+		}
+
+		sync, err = syncRoleUsers(ctx, tx, []int{userID})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sync, nil
+}
+
+// listRoles returns the roles sorted by name, filtered to one role and/or to the
+// roles of one user when the IDs are not 0
+func listRoles(ctx context.Context, q queryer, roleID, userID int) ([]models.Role, error) {
+	const filter = `
+		(@p1 = 0 OR r.RoleID = @p1)
+		AND (@p2 = 0 OR EXISTS (
+			SELECT 1 FROM powerbi.AccessRoleMember m WHERE m.RoleID = r.RoleID AND m.UserID = @p2
+		))`
+
+	query := `
+		SELECT r.RoleID, r.Name, COALESCE(r.Description, ''), r.CreationDate,
+			(SELECT COUNT(1) FROM powerbi.AccessRoleMember m WHERE m.RoleID = r.RoleID)
+		FROM powerbi.AccessRole r
+		WHERE` + filter + `
+		ORDER BY r.Name`
+
+	rows, err := q.QueryContext(ctx, query, roleID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %w", err)
+	}
+
+	var roles []models.Role
+	index := make(map[int]int)
+	for rows.Next() {
+		role := models.Role{Groups: []models.Group{}}
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.CreationDate, &role.MemberCount); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		index[role.ID] = len(roles)
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, fmt.Errorf("error iterating roles: %w", err)
+	}
+	rows.Close()
+
+	if len(roles) == 0 {
+		return roles, nil
+	}
+
+	groupQuery := `
+		SELECT rg.RoleID, rg.Group_Bkey, COALESCE(g.GroupName, '')
+		FROM powerbi.AccessRoleGroup rg
+		INNER JOIN powerbi.AccessRole r ON r.RoleID = rg.RoleID
+		LEFT JOIN dim.[Group] g ON g.Group_Bkey = rg.Group_Bkey
+		WHERE` + filter + `
+		ORDER BY g.GroupName, rg.Group_Bkey`
+
+	groupRows, err := q.QueryContext(ctx, groupQuery, roleID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query role groups: %w", err)
+	}
+	defer groupRows.Close()
+
+	for groupRows.Next() {
+		var id int
+		var g models.Group
+		if err := groupRows.Scan(&id, &g.GroupBkey, &g.GroupName); err != nil {
+			return nil, fmt.Errorf("failed to scan role group: %w", err)
+		}
+		if i, ok := index[id]; ok {
+			roles[i].Groups = append(roles[i].Groups, g)
+		}
+	}
+
+	if err := groupRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating role groups: %w", err)
+	}
+
+	return roles, nil
+}
+
+func insertRoleGroups(ctx context.Context, tx *sql.Tx, roleID int, groupBkeys []int) error {
+	groupBkeys = uniqueInts(groupBkeys)
+
+	for start := 0; start < len(groupBkeys); start += grantBatchSize {
+		batch := groupBkeys[start:min(start+grantBatchSize, len(groupBkeys))]
+		args := []interface{}{roleID}
+		values := make([]string, len(batch))
+		for i, groupBkey := range batch {
+			args = append(args, groupBkey)
+			values[i] = fmt.Sprintf("(@p1, @p%d)", i+2)
+		}
+
+		query := `INSERT INTO powerbi.AccessRoleGroup (RoleID, Group_Bkey) VALUES ` + strings.Join(values, ", ")
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to add role groups: %w", err)
+		}
+	}
+	return nil
+}
+
+func roleMemberIDs(ctx context.Context, tx *sql.Tx, roleID int) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT UserID FROM powerbi.AccessRoleMember WHERE RoleID = @p1`, roleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query role members: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan role member: %w", err)
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating role members: %w", err)
+	}
+
+	return userIDs, nil
+}
+
+// syncRoleUsers brings the access of the given users in line with their current
+// roles, a batch of users at a time
+func syncRoleUsers(ctx context.Context, tx *sql.Tx, userIDs []int) (*models.RoleSync, error) {
+	sync := newRoleSync()
+
+	for start := 0; start < len(userIDs); start += grantBatchSize {
+		batch := userIDs[start:min(start+grantBatchSize, len(userIDs))]
+
+		desired, err := desiredRoleGrants(ctx, tx, batch)
+		if err != nil {
+			return nil, err
+		}
+		current, err := roleUsersAccess(ctx, tx, batch)
+		if err != nil {
+			return nil, err
+		}
+
+		plan := planRoleSync(desired, current)
+
+		byUser := make(map[int]map[int]int)
+		for _, c := range plan.adds {
+			if byUser[c.UserID] == nil {
+				byUser[c.UserID] = make(map[int]int)
+			}
+			byUser[c.UserID][c.GroupBkey] = c.RoleID
+		}
+		for _, userID := range batch {
+			if owners := byUser[userID]; owners != nil {
+				if err := insertTrackedAccess(ctx, tx, "powerbi.AccessRoleGrant", "RoleID", userID, owners); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for accessID, roleID := range plan.retarget {
+			query := `UPDATE powerbi.AccessRoleGrant SET RoleID = @p1 WHERE UserAccessID = @p2`
+			if _, err := tx.ExecContext(ctx, query, roleID, accessID); err != nil {
+				return nil, fmt.Errorf("failed to update role grant: %w", err)
+			}
+		}
+		for accessID, roleID := range plan.adopt {
+			query := `INSERT INTO powerbi.AccessRoleGrant (RoleID, UserAccessID) VALUES (@p1, @p2)`
+			if _, err := tx.ExecContext(ctx, query, roleID, accessID); err != nil {
+				return nil, fmt.Errorf("failed to add role grant: %w", err)
+			}
+		}
+		for _, accessID := range plan.release {
+			query := `DELETE FROM powerbi.AccessRoleGrant WHERE UserAccessID = @p1`
+			if _, err := tx.ExecContext(ctx, query, accessID); err != nil {
+				return nil, fmt.Errorf("failed to remove role grant: %w", err)
+			}
+		}
+
+		if err := deleteAccessIDs(ctx, tx, roleStaleAccessIDs(current.existing, current.tracked, plan.removes)); err != nil {
+			return nil, err
+		}
+
+		sync.Added = append(sync.Added, plan.adds...)
+		sync.Removed = append(sync.Removed, plan.removes...)
+	}
+
+	return sync, nil
+}
+
+// desiredRoleGrants resolves the roles of the given users to the groups they cover
+func desiredRoleGrants(ctx context.Context, tx *sql.Tx, userIDs []int) ([]models.RoleChange, error) {
+	query := fmt.Sprintf(`
+		SELECT m.RoleID, m.UserID, u.PowerBIUser, g.Group_Bkey, g.GroupName
+		FROM powerbi.AccessRoleMember m
+		INNER JOIN powerbi.Users u ON u.PowerBIUserID = m.UserID
+		INNER JOIN powerbi.AccessRoleGroup rg ON rg.RoleID = m.RoleID
+		INNER JOIN dim.[Group] g ON g.Group_Bkey = rg.Group_Bkey
+		WHERE m.UserID IN (%s)`, placeholders(1, len(userIDs)))
+
+	rows, err := tx.QueryContext(ctx, query, intArgs(userIDs)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve roles: %w", err)
+	}
+	defer rows.Close()
+
+	var desired []models.RoleChange
+	for rows.Next() {
+		var c models.RoleChange
+		if err := rows.Scan(&c.RoleID, &c.UserID, &c.UserEmail, &c.GroupBkey, &c.GroupName); err != nil {
+			return nil, fmt.Errorf("failed to scan role group: %w", err)
+		}
+		desired = append(desired, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating role groups: %w", err)
+	}
+
+	return desired, nil
+}
+
+// roleCoveredCondition holds for the access records ua whose group one of the
+// user's roles covers. The expiry sweeper and the rule reconciler leave those alone.
+const roleCoveredCondition = `EXISTS (
+	SELECT 1 FROM powerbi.AccessRoleMember m
+	INNER JOIN powerbi.AccessRoleGroup rg ON rg.RoleID = m.RoleID
+	WHERE m.UserID = ua.UserID AND rg.Group_Bkey = ua.Group_Bkey
+)`
+
+// roleAccess is the current access of a batch of role members
+type roleAccess struct {
+	existing    []models.UserAccess
+	tracked     map[int]int // access ID → role that granted it
+	ruleTracked map[int]int // access ID → rule that granted it
+	emails      map[int]string
+}
+
+// roleUsersAccess returns the current access of the given users, locked until the
+// transaction ends, with the role or rule that granted each record and the users' emails
+func roleUsersAccess(ctx context.Context, tx *sql.Tx, userIDs []int) (*roleAccess, error) {
+	query := fmt.Sprintf(`
+		SELECT ua.UserAccessID, ua.UserID, u.PowerBIUser, ua.Group_Bkey, COALESCE(g.GroupName, ''),
+			e.ValidUntil, rgr.RoleID, rug.RuleID
+		FROM powerbi.UserAccess ua WITH (UPDLOCK, HOLDLOCK)
+		INNER JOIN powerbi.Users u ON u.PowerBIUserID = ua.UserID
+		LEFT JOIN dim.[Group] g ON g.Group_Bkey = ua.Group_Bkey
+		LEFT JOIN powerbi.UserAccessExpiry e ON e.UserAccessID = ua.UserAccessID
+		LEFT JOIN powerbi.AccessRoleGrant rgr ON rgr.UserAccessID = ua.UserAccessID
+		LEFT JOIN powerbi.AccessRuleGrant rug ON rug.UserAccessID = ua.UserAccessID
+		WHERE ua.UserID IN (%s)`, placeholders(1, len(userIDs)))
+
+	rows, err := tx.QueryContext(ctx, query, intArgs(userIDs)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query role users access: %w", err)
+	}
+	defer rows.Close()
+
+	current := &roleAccess{
+		tracked:     make(map[int]int),
+		ruleTracked: make(map[int]int),
+		emails:      make(map[int]string),
+	}
+	for rows.Next() {
+		var a models.UserAccess
+		var email string
+		var validUntil sql.NullTime
+		var roleID, ruleID sql.NullInt64
+		if err := rows.Scan(&a.UserAccessID, &a.UserID, &email, &a.GroupBkey, &a.GroupName,
+			&validUntil, &roleID, &ruleID); err != nil {
+			return nil, fmt.Errorf("failed to scan user access: %w", err)
+		}
+		if validUntil.Valid {
+			a.ValidUntil = &validUntil.Time
+		}
+		current.existing = append(current.existing, a)
+		current.emails[a.UserID] = email
+		if roleID.Valid {
+			current.tracked[a.UserAccessID] = int(roleID.Int64)
+		}
+		if ruleID.Valid {
+			current.ruleTracked[a.UserAccessID] = int(ruleID.Int64)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user access: %w", err)
+	}
+
+	return current, nil
+}
+
+func newRoleSync() *models.RoleSync {
+	return &models.RoleSync{
+		Added:   []models.RoleChange{},
+		Removed: []models.RoleChange{},
+	}
+}
+
+// roleSyncPlan lists what planRoleSync decided for a batch of role members
+type roleSyncPlan struct {
+	adds     []models.RoleChange
+	removes  []models.RoleChange
+	retarget map[int]int // access ID → other role that now gets the credit
+	adopt    map[int]int // access ID → role that starts tracking an existing record
+	release  []int       // access IDs the roles stop tracking without removing them
+}
+
+// planRoleSync compares the groups the users' roles cover with their current access.
+// Only a permanent record that no rule tracks, or one a role already tracks, counts
+// as covering a group: a time-limited or rule-tracked record can be removed by the
+// expiry sweeper or the reconciler, so the role adopts it instead. A group is added
+// when no access record exists for it. A record a role tracks is credited to another
+// of the user's roles (retarget) when only that role still covers it; otherwise it is
+// removed, or only released when its expiry or rule still keeps it.
+func planRoleSync(desired []models.RoleChange, current *roleAccess) *roleSyncPlan {
+	type userGroup struct{ userID, groupBkey int }
+	type roleUserGroup struct{ roleID, userID, groupBkey int }
+
+	// Another claim on the record than a role's: an expiry or a rule
+	held := func(a models.UserAccess) bool {
+		_, ruleTracked := current.ruleTracked[a.UserAccessID]
+		return a.ValidUntil != nil || ruleTracked
+	}
+
+	records := make(map[userGroup]models.UserAccess, len(current.existing))
+	for _, a := range current.existing {
+		records[userGroup{a.UserID, a.GroupBkey}] = a
+	}
+
+	// Sort so the lowest role ID is credited when several roles cover a group
+	sort.Slice(desired, func(i, j int) bool {
+		if desired[i].UserID != desired[j].UserID {
+			return desired[i].UserID < desired[j].UserID
+		}
+		if desired[i].GroupBkey != desired[j].GroupBkey {
+			return desired[i].GroupBkey < desired[j].GroupBkey
+		}
+		return desired[i].RoleID < desired[j].RoleID
+	})
+
+	plan := &roleSyncPlan{
+		adds:     []models.RoleChange{},
+		removes:  []models.RoleChange{},
+		retarget: make(map[int]int),
+		adopt:    make(map[int]int),
+	}
+
+	wanted := make(map[userGroup]int, len(desired))
+	covers := make(map[roleUserGroup]bool, len(desired))
+	for _, c := range desired {
+		covers[roleUserGroup{c.RoleID, c.UserID, c.GroupBkey}] = true
+
+		key := userGroup{c.UserID, c.GroupBkey}
+		if _, ok := wanted[key]; ok {
+			continue
+		}
+		wanted[key] = c.RoleID
+
+		a, ok := records[key]
+		if !ok {
+			plan.adds = append(plan.adds, c)
+			continue
+		}
+		if _, tracked := current.tracked[a.UserAccessID]; !tracked && held(a) {
+			plan.adopt[a.UserAccessID] = c.RoleID
+		}
+	}
+
+	for _, a := range current.existing {
+		roleID, ok := current.tracked[a.UserAccessID]
+		if !ok || covers[roleUserGroup{roleID, a.UserID, a.GroupBkey}] {
+			continue
+		}
+		if other, ok := wanted[userGroup{a.UserID, a.GroupBkey}]; ok {
+			plan.retarget[a.UserAccessID] = other
+			continue
+		}
+		if held(a) {
+			plan.release = append(plan.release, a.UserAccessID)
+			continue
+		}
+		plan.removes = append(plan.removes, models.RoleChange{
+			RoleID:    roleID,
+			UserID:    a.UserID,
+			UserEmail: current.emails[a.UserID],
+			GroupBkey: a.GroupBkey,
+			GroupName: a.GroupName,
+		})
+	}
+
+	sort.Slice(plan.removes, func(i, j int) bool {
+		if plan.removes[i].UserID != plan.removes[j].UserID {
+			return plan.removes[i].UserID < plan.removes[j].UserID
+		}
+		return plan.removes[i].GroupBkey < plan.removes[j].GroupBkey
+	})
+	sort.Ints(plan.release)
+
+	return plan
+}
+
+// roleStaleAccessIDs finds the role-granted access records behind the planned removals
+func roleStaleAccessIDs(existing []models.UserAccess, tracked map[int]int, removes []models.RoleChange) []int {
+	type userGroup struct{ userID, groupBkey int }
+
+	remove := make(map[userGroup]bool, len(removes))
+	for _, c := range removes {
+		remove[userGroup{c.UserID, c.GroupBkey}] = true
+	}
+
+	var accessIDs []int
+	for _, a := range existing {
+		if _, ok := tracked[a.UserAccessID]; ok && remove[userGroup{a.UserID, a.GroupBkey}] {
+			accessIDs = append(accessIDs, a.UserAccessID)
+		}
+	}
+	return accessIDs
+}
+
+func uniqueInts(values []int) []int {
+	seen := make(map[int]bool, len(values))
+	unique := make([]int, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+func intArgs(values []int) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"powerbi-access-tool/models"
+)
+
+// accessGroups lists the groups a user has in the memory store, in key order
+func accessGroups(t *testing.T, db *MemoryDB, userID int) string {
+	t.Helper()
+	accessList, err := NewMemoryAccessRepository(db).ListByUser(context.Background(), userID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var groups []int
+	for _, a := range accessList {
+		groups = append(groups, a.GroupBkey)
+	}
+	sort.Ints(groups)
+	return fmt.Sprint(groups)
+}
+
+// roleChanges formats planned role changes as role:user/group
+func roleChanges(changes []models.RoleChange) string {
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		parts[i] = fmt.Sprintf("%d:%d/%d", c.RoleID, c.UserID, c.GroupBkey)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestPlanRoleSync(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	permanent := func(id, groupBkey int) models.UserAccess {
+		return models.UserAccess{UserAccessID: id, UserID: 1, GroupBkey: groupBkey}
+	}
+	timeLimited := func(id, groupBkey int) models.UserAccess {
+		return models.UserAccess{UserAccessID: id, UserID: 1, GroupBkey: groupBkey, ValidUntil: &until}
+	}
+	covers := func(roleID int, groupBkeys ...int) []models.RoleChange {
+		var changes []models.RoleChange
+		for _, g := range groupBkeys {
+			changes = append(changes, models.RoleChange{RoleID: roleID, UserID: 1, GroupBkey: g})
+		}
+		return changes
+	}
+
+	tests := []struct {
+		name        string
+		desired     []models.RoleChange
+		existing    []models.UserAccess
+		tracked     map[int]int
+		ruleTracked map[int]int
+		adds        string
+		removes     string
+		retarget    string
+		adopt       string
+		release     string
+	}{
+		{
+			name:    "missing group is added",
+			desired: covers(1, 1001),
+			adds:    "1:1/1001",
+		},
+		{
+			name:    "lowest role is credited",
+			desired: append(covers(2, 1001), covers(1, 1001)...),
+			adds:    "1:1/1001",
+		},
+		{
+			name:     "permanent record covers the group",
+			desired:  covers(1, 1001),
+			existing: []models.UserAccess{permanent(10, 1001)},
+		},
+		{
+			name:     "time-limited record is adopted",
+			desired:  covers(1, 1001),
+			existing: []models.UserAccess{timeLimited(10, 1001)},
+			adopt:    "map[10:1]",
+		},
+		{
+			name:        "rule-tracked record is adopted",
+			desired:     covers(1, 1001),
+			existing:    []models.UserAccess{permanent(10, 1001)},
+			ruleTracked: map[int]int{10: 5},
+			adopt:       "map[10:1]",
+		},
+		{
+			name:     "role-tracked record stays",
+			desired:  covers(2, 1001),
+			existing: []models.UserAccess{timeLimited(10, 1001)},
+			tracked:  map[int]int{10: 2},
+		},
+		{
+			name:     "uncovered role record is removed",
+			existing: []models.UserAccess{permanent(10, 1001), permanent(11, 1002)},
+			tracked:  map[int]int{10: 1},
+			removes:  "1:1/1001",
+		},
+		{
+			name:     "record moves to the role that still covers it",
+			desired:  covers(2, 1001),
+			existing: []models.UserAccess{permanent(10, 1001)},
+			tracked:  map[int]int{10: 1},
+			retarget: "map[10:2]",
+		},
+		{
+			name:     "uncovered time-limited record is released",
+			existing: []models.UserAccess{timeLimited(10, 1001)},
+			tracked:  map[int]int{10: 1},
+			release:  "[10]",
+		},
+		{
+			name:        "uncovered rule-tracked record is released",
+			existing:    []models.UserAccess{permanent(10, 1001)},
+			tracked:     map[int]int{10: 1},
+			ruleTracked: map[int]int{10: 5},
+			release:     "[10]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := &roleAccess{
+				existing:    tt.existing,
+				tracked:     tt.tracked,
+				ruleTracked: tt.ruleTracked,
+				emails:      map[int]string{1: "anna@voorbeeld.nl"},
+			}
+			plan := planRoleSync(tt.desired, current)
+
+			if got := roleChanges(plan.adds); got != tt.adds {
+				t.Errorf("adds %q, want %q", got, tt.adds)
+			}
+			if got := roleChanges(plan.removes); got != tt.removes {
+				t.Errorf("removes %q, want %q", got, tt.removes)
+			}
+			if got := nonEmpty(plan.retarget); got != tt.retarget {
+				t.Errorf("retarget %q, want %q", got, tt.retarget)
+			}
+			if got := nonEmpty(plan.adopt); got != tt.adopt {
+				t.Errorf("adopt %q, want %q", got, tt.adopt)
+			}
+			if got := nonEmpty(plan.release); got != tt.release {
+				t.Errorf("release %q, want %q", got, tt.release)
+			}
+		})
+	}
+}
+
+// nonEmpty formats a map or slice, or returns "" when it has no entries
+func nonEmpty[T map[int]int | []int](v T) string {
+	if len(v) == 0 {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func TestRoleKeepsTimeLimitedGroupAfterExpiry(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	db.SeedDemo()
+	access := NewMemoryAccessRepository(db)
+	roles := NewMemoryRoleRepository(db)
+
+	until := time.Now().Add(time.Hour)
+	if _, err := access.GrantWithExpiry(ctx, 1, []models.Grant{{GroupBkey: 1001, ValidUntil: &until}}); err != nil {
+		t.Fatal(err)
+	}
+	roleID, err := roles.Create(ctx, "Verkoop", "", []int{1001, 1003})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roles.Assign(ctx, roleID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The role covers the group, so the sweeper leaves it
+	removed, err := access.RemoveExpired(ctx, until.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("sweeper removed %v while the role covers the group", removed)
+	}
+	if got := accessGroups(t, db, 1); got != "[1001 1003]" {
+		t.Fatalf("after the sweep user has %s, want [1001 1003]", got)
+	}
+
+	// Without the role the expiry applies again
+	sync, err := roles.Unassign(ctx, roleID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sync.Removed) != 1 || sync.Removed[0].GroupBkey != 1003 {
+		t.Fatalf("unassign removed %v, want only group 1003", sync.Removed)
+	}
+	if got := accessGroups(t, db, 1); got != "[1001]" {
+		t.Fatalf("after unassign user has %s, want the time-limited group 1001", got)
+	}
+	if removed, err = access.RemoveExpired(ctx, until.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].GroupBkey != 1001 {
+		t.Fatalf("sweeper removed %v, want group 1001", removed)
+	}
+}
+
+func TestRoleKeepsRuleGroupAfterStaleRemoval(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	db.SeedDemo()
+	rules := NewMemoryRuleRepository(db)
+	roles := NewMemoryRoleRepository(db)
+
+	if _, err := rules.Create(ctx, models.AccessRule{UserID: 1, Level2Name: "Regio Noord", RemoveStale: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rules.Reconcile(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	roleID, err := roles.Create(ctx, "Verkoop Noord", "", []int{1001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roles.Assign(ctx, roleID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both groups move out of the rule's unit
+	db.mu.Lock()
+	for i, o := range db.objects {
+		if o.GroupBkey == 1001 || o.GroupBkey == 1002 {
+			db.objects[i].Level2Name = "Regio Zuid"
+		}
+	}
+	db.mu.Unlock()
+
+	report, err := rules.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Removed) != 1 || report.Removed[0].GroupBkey != 1002 {
+		t.Fatalf("reconcile removed %v, want only group 1002", report.Removed)
+	}
+	if got := accessGroups(t, db, 1); got != "[1001]" {
+		t.Fatalf("after reconcile user has %s, want group 1001 from the role", got)
+	}
+
+	// Without the role the rule's stale record goes at the next reconcile
+	sync, err := roles.Unassign(ctx, roleID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sync.Removed) != 0 {
+		t.Fatalf("unassign removed %v, want the rule's record kept", sync.Removed)
+	}
+	if _, err := rules.Reconcile(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := accessGroups(t, db, 1); got != "[]" {
+		t.Fatalf("after the last reconcile user has %s, want no groups", got)
+	}
+}
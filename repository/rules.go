@@ -0,0 +1,453 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"powerbi-access-tool/models"
+)
+
+type RuleRepository struct {
+	db *sql.DB
+}
+
+func NewRuleRepository(db *sql.DB) *RuleRepository {
+	return &RuleRepository{db: db}
+}
+
+// List returns the rules for a user, or all rules when userID is 0
+func (r *RuleRepository) List(ctx context.Context, userID int) ([]models.AccessRule, error) {
+	return listRules(ctx, r.db, userID)
+}
+
+func (r *RuleRepository) Create(ctx context.Context, rule models.AccessRule) (int, error) {
+	if rule.Level2Name == "" && rule.Level3Name == "" {
+		return 0, fmt.Errorf("a Level2 or Level3 name is required")
+	}
+
+	query := `
+		INSERT INTO powerbi.AccessRule (UserID, Level2Name, Level3Name, RemoveStale)
+		OUTPUT INSERTED.RuleID
+		VALUES (@p1, @p2, @p3, @p4)`
+
+	var id int
+	err := r.db.QueryRowContext(ctx, query, rule.UserID, nullString(rule.Level2Name), nullString(rule.Level3Name), rule.RemoveStale).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create rule: %w", err)
+	}
+	return id, nil
+}
+
+// Delete removes a rule. Access it granted stays in place.
+func (r *RuleRepository) Delete(ctx context.Context, id int) error {
+	query := `DELETE FROM powerbi.AccessRule WHERE RuleID = @p1`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("rule not found")
+	}
+
+	return nil
+}
+
+// Reconcile materialises all rules into powerbi.UserAccess in one transaction.
+// Missing groups are granted; with RemoveStale, access a rule granted earlier is
+// revoked once no rule of that user covers its group anymore. A dry run only reports.
+func (r *RuleRepository) Reconcile(ctx context.Context, dryRun bool) (*models.ReconcileReport, error) {
+	report := newReconcileReport(dryRun)
+
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		rules, err := listRules(ctx, tx, 0)
+		if err != nil {
+			return err
+		}
+		report.Rules = len(rules)
+		if len(rules) == 0 {
+			return nil
+		}
+
+		desired, err := desiredRuleGrants(ctx, tx)
+		if err != nil {
+			return err
+		}
+		existing, roleCovered, err := ruleUsersAccess(ctx, tx)
+		if err != nil {
+			return err
+		}
+		tracked, err := trackedRuleGrants(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		adds, removes := planReconcile(rules, desired, existing, tracked, roleCovered)
+		report.Added = adds
+		report.Removed = removes
+
+		if dryRun {
+			return nil
+		}
+		if err := applyRuleAdds(ctx, tx, adds); err != nil {
+			return err
+		}
+		return applyRuleRemoves(ctx, tx, existing, tracked, removes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func listRules(ctx context.Context, q queryer, userID int) ([]models.AccessRule, error) {
+	query := `
+		SELECT r.RuleID, r.UserID, COALESCE(u.PowerBIUser, ''), COALESCE(r.Level2Name, ''),
+			COALESCE(r.Level3Name, ''), r.RemoveStale, r.CreationDate
+		FROM powerbi.AccessRule r
+		LEFT JOIN powerbi.Users u ON u.PowerBIUserID = r.UserID
+		WHERE @p1 = 0 OR r.UserID = @p1
+		ORDER BY u.PowerBIUser, r.RuleID`
+
+	rows, err := q.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []models.AccessRule
+	for rows.Next() {
+		var rule models.AccessRule
+		if err := rows.Scan(&rule.ID, &rule.UserID, &rule.UserEmail, &rule.Level2Name,
+			&rule.Level3Name, &rule.RemoveStale, &rule.CreationDate); err != nil {
+			return nil, fmt.Errorf("failed to scan rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// desiredRuleGrants resolves every rule to the groups it covers
+func desiredRuleGrants(ctx context.Context, tx *sql.Tx) ([]models.ReconcileChange, error) {
+	query := `
+		SELECT DISTINCT r.RuleID, r.UserID, u.PowerBIUser, g.Group_Bkey, g.GroupName
+		FROM powerbi.AccessRule r
+		INNER JOIN powerbi.Users u ON u.PowerBIUserID = r.UserID
+		INNER JOIN dim.[Object] o
+			ON (r.Level2Name IS NULL OR o.Level2Name = r.Level2Name)
+			AND (r.Level3Name IS NULL OR o.Level3Name = r.Level3Name)
+		INNER JOIN dim.[Group] g ON g.Group_Bkey = o.Group_Bkey`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve rules: %w", err)
+	}
+	defer rows.Close()
+
+	var desired []models.ReconcileChange
+	for rows.Next() {
+		var c models.ReconcileChange
+		if err := rows.Scan(&c.RuleID, &c.UserID, &c.UserEmail, &c.GroupBkey, &c.GroupName); err != nil {
+			return nil, fmt.Errorf("failed to scan rule group: %w", err)
+		}
+		desired = append(desired, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rule groups: %w", err)
+	}
+
+	return desired, nil
+}
+
+// ruleUsersAccess returns the current access of every user that has a rule,
+// locked until the transaction ends, and the access IDs whose group one of the
+// user's roles covers
+func ruleUsersAccess(ctx context.Context, tx *sql.Tx) ([]models.UserAccess, map[int]bool, error) {
+	query := `
+		SELECT ua.UserAccessID, ua.UserID, ua.Group_Bkey, COALESCE(g.GroupName, ''),
+			CASE WHEN ` + roleCoveredCondition + ` THEN 1 ELSE 0 END
+		FROM powerbi.UserAccess ua WITH (UPDLOCK, HOLDLOCK)
+		LEFT JOIN dim.[Group] g ON g.Group_Bkey = ua.Group_Bkey
+		WHERE ua.UserID IN (SELECT UserID FROM powerbi.AccessRule)`
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query rule users access: %w", err)
+	}
+	defer rows.Close()
+
+	var existing []models.UserAccess
+	roleCovered := make(map[int]bool)
+	for rows.Next() {
+		var a models.UserAccess
+		var covered bool
+		if err := rows.Scan(&a.UserAccessID, &a.UserID, &a.GroupBkey, &a.GroupName, &covered); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan user access: %w", err)
+		}
+		existing = append(existing, a)
+		if covered {
+			roleCovered[a.UserAccessID] = true
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating user access: %w", err)
+	}
+
+	return existing, roleCovered, nil
+}
+
+// trackedRuleGrants maps access records created by the reconciler to their rule
+func trackedRuleGrants(ctx context.Context, tx *sql.Tx) (map[int]int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT UserAccessID, RuleID FROM powerbi.AccessRuleGrant`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rule grants: %w", err)
+	}
+	defer rows.Close()
+
+	tracked := make(map[int]int)
+	for rows.Next() {
+		var accessID, ruleID int
+		if err := rows.Scan(&accessID, &ruleID); err != nil {
+			return nil, fmt.Errorf("failed to scan rule grant: %w", err)
+		}
+		tracked[accessID] = ruleID
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rule grants: %w", err)
+	}
+
+	return tracked, nil
+}
+
+func applyRuleAdds(ctx context.Context, tx *sql.Tx, adds []models.ReconcileChange) error {
+	// Insert per user so each statement can use the set-based form from Grant
+	byUser := make(map[int]map[int]int)
+	var userIDs []int
+	for _, c := range adds {
+		if byUser[c.UserID] == nil {
+			byUser[c.UserID] = make(map[int]int)
+			userIDs = append(userIDs, c.UserID)
+		}
+		byUser[c.UserID][c.GroupBkey] = c.RuleID
+	}
+
+	for _, userID := range userIDs {
+		if err := insertTrackedAccess(ctx, tx, "powerbi.AccessRuleGrant", "RuleID", userID, byUser[userID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertTrackedAccess grants the user the groups in owners, a map of group key to
+// the rule or role granting it, and records each new row's owner in trackTable.
+// Groups missing from dim.[Group] are skipped.
+func insertTrackedAccess(ctx context.Context, tx *sql.Tx, trackTable, ownerColumn string, userID int, owners map[int]int) error {
+	groupBkeys := make([]int, 0, len(owners))
+	for groupBkey := range owners {
+		groupBkeys = append(groupBkeys, groupBkey)
+	}
+	sort.Ints(groupBkeys)
+
+	for start := 0; start < len(groupBkeys); start += grantBatchSize {
+		batch := groupBkeys[start:min(start+grantBatchSize, len(groupBkeys))]
+		if err := insertTrackedBatch(ctx, tx, trackTable, ownerColumn, userID, batch, owners); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertTrackedBatch(ctx context.Context, tx *sql.Tx, trackTable, ownerColumn string, userID int, groupBkeys []int, owners map[int]int) error {
+	args := []interface{}{userID}
+	for _, groupBkey := range groupBkeys {
+		args = append(args, groupBkey)
+	}
+
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO powerbi.UserAccess (UserID, Group_Bkey)
+		OUTPUT INSERTED.UserAccessID, INSERTED.Group_Bkey
+		SELECT @p1, g.Group_Bkey
+		FROM dim.[Group] g
+		WHERE g.Group_Bkey IN (%s)`, placeholders(2, len(groupBkeys)))
+
+	rows, err := tx.QueryContext(ctx, insertQuery, args...)
+	if err != nil {
+		return fmt.Errorf("failed to add groups for user %d: %w", userID, err)
+	}
+
+	var trackArgs []interface{}
+	for rows.Next() {
+		var accessID, groupBkey int
+		if err := rows.Scan(&accessID, &groupBkey); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan added access: %w", err)
+		}
+		trackArgs = append(trackArgs, owners[groupBkey], accessID)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return fmt.Errorf("error iterating added access: %w", err)
+	}
+	rows.Close()
+
+	if len(trackArgs) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(trackArgs)/2)
+	for i := 1; i < len(trackArgs); i += 2 {
+		values = append(values, fmt.Sprintf("(@p%d, @p%d)", i, i+1))
+	}
+	trackQuery := fmt.Sprintf(`INSERT INTO %s (%s, UserAccessID) VALUES `, trackTable, ownerColumn) + strings.Join(values, ", ")
+
+	if _, err := tx.ExecContext(ctx, trackQuery, trackArgs...); err != nil {
+		return fmt.Errorf("failed to track granted access: %w", err)
+	}
+	return nil
+}
+
+func applyRuleRemoves(ctx context.Context, tx *sql.Tx, existing []models.UserAccess, tracked map[int]int, removes []models.ReconcileChange) error {
+	return deleteAccessIDs(ctx, tx, staleAccessIDs(existing, tracked, removes))
+}
+
+// deleteAccessIDs removes access records by ID in batches
+func deleteAccessIDs(ctx context.Context, tx *sql.Tx, accessIDs []int) error {
+	for start := 0; start < len(accessIDs); start += grantBatchSize {
+		batch := accessIDs[start:min(start+grantBatchSize, len(accessIDs))]
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			args[i] = id
+		}
+
+		query := fmt.Sprintf(`DELETE FROM powerbi.UserAccess WHERE UserAccessID IN (%s)`, placeholders(1, len(batch)))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to remove access: %w", err)
+		}
+	}
+	return nil
+}
+
+func newReconcileReport(dryRun bool) *models.ReconcileReport {
+	return &models.ReconcileReport{
+		StartedAt: time.Now().UTC(),
+		DryRun:    dryRun,
+		Added:     []models.ReconcileChange{},
+		Removed:   []models.ReconcileChange{},
+	}
+}
+
+// planReconcile compares the groups the rules cover with the users' current access.
+// A group is added when no access record exists for it; a tracked record is removed
+// when its rule has RemoveStale set and no rule of the user covers the group anymore,
+// unless one of the user's roles covers it (roleCovered, by access ID).
+func planReconcile(rules []models.AccessRule, desired []models.ReconcileChange, existing []models.UserAccess, tracked map[int]int, roleCovered map[int]bool) ([]models.ReconcileChange, []models.ReconcileChange) {
+	type userGroup struct{ userID, groupBkey int }
+
+	rulesByID := make(map[int]models.AccessRule, len(rules))
+	for _, rule := range rules {
+		rulesByID[rule.ID] = rule
+	}
+
+	has := make(map[userGroup]bool, len(existing))
+	for _, a := range existing {
+		has[userGroup{a.UserID, a.GroupBkey}] = true
+	}
+
+	// Sort so the lowest rule ID is credited when several rules cover a group
+	sort.Slice(desired, func(i, j int) bool {
+		if desired[i].UserID != desired[j].UserID {
+			return desired[i].UserID < desired[j].UserID
+		}
+		if desired[i].GroupBkey != desired[j].GroupBkey {
+			return desired[i].GroupBkey < desired[j].GroupBkey
+		}
+		return desired[i].RuleID < desired[j].RuleID
+	})
+
+	wanted := make(map[userGroup]bool, len(desired))
+	adds := []models.ReconcileChange{}
+	for _, c := range desired {
+		key := userGroup{c.UserID, c.GroupBkey}
+		if wanted[key] {
+			continue
+		}
+		wanted[key] = true
+		if !has[key] {
+			adds = append(adds, c)
+		}
+	}
+
+	removes := []models.ReconcileChange{}
+	for _, a := range existing {
+		ruleID, ok := tracked[a.UserAccessID]
+		if !ok {
+			continue
+		}
+		rule, ok := rulesByID[ruleID]
+		if !ok || !rule.RemoveStale || wanted[userGroup{a.UserID, a.GroupBkey}] || roleCovered[a.UserAccessID] {
+			continue
+		}
+		removes = append(removes, models.ReconcileChange{
+			RuleID:    ruleID,
+			UserID:    a.UserID,
+			UserEmail: rule.UserEmail,
+			GroupBkey: a.GroupBkey,
+			GroupName: a.GroupName,
+		})
+	}
+
+	sort.Slice(removes, func(i, j int) bool {
+		if removes[i].UserID != removes[j].UserID {
+			return removes[i].UserID < removes[j].UserID
+		}
+		return removes[i].GroupBkey < removes[j].GroupBkey
+	})
+
+	return adds, removes
+}
+
+// staleAccessIDs finds the tracked access records behind the planned removals
+func staleAccessIDs(existing []models.UserAccess, tracked map[int]int, removes []models.ReconcileChange) []int {
+	type userGroup struct{ userID, groupBkey int }
+
+	remove := make(map[userGroup]bool, len(removes))
+	for _, c := range removes {
+		remove[userGroup{c.UserID, c.GroupBkey}] = true
+	}
+
+	var accessIDs []int
+	for _, a := range existing {
+		if _, ok := tracked[a.UserAccessID]; ok && remove[userGroup{a.UserID, a.GroupBkey}] {
+			accessIDs = append(accessIDs, a.UserAccessID)
+		}
+	}
+	return accessIDs
+}
+
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
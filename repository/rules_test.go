@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/models"
+)
+
+// reconcileChanges formats planned reconcile changes as rule:user/group
+func reconcileChanges(changes []models.ReconcileChange) string {
+	parts := make([]string, len(changes))
+	for i, c := range changes {
+		parts[i] = fmt.Sprintf("%d:%d/%d", c.RuleID, c.UserID, c.GroupBkey)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestPlanReconcile(t *testing.T) {
+	rules := []models.AccessRule{
+		{ID: 1, UserID: 1, Level2Name: "Regio Noord", RemoveStale: true},
+		{ID: 2, UserID: 1, Level2Name: "Regio Zuid"},
+		{ID: 3, UserID: 2, Level2Name: "Regio Noord", RemoveStale: true},
+	}
+	record := func(id, userID, groupBkey int) models.UserAccess {
+		return models.UserAccess{UserAccessID: id, UserID: userID, GroupBkey: groupBkey}
+	}
+	covers := func(ruleID, userID int, groupBkeys ...int) []models.ReconcileChange {
+		var changes []models.ReconcileChange
+		for _, g := range groupBkeys {
+			changes = append(changes, models.ReconcileChange{RuleID: ruleID, UserID: userID, GroupBkey: g})
+		}
+		return changes
+	}
+
+	tests := []struct {
+		name        string
+		desired     []models.ReconcileChange
+		existing    []models.UserAccess
+		tracked     map[int]int
+		roleCovered map[int]bool
+		adds        string
+		removes     string
+		stale       string
+	}{
+		{
+			name:    "missing groups are added",
+			desired: append(covers(1, 1, 1001, 1002), covers(3, 2, 1001)...),
+			adds:    "1:1/1001 1:1/1002 3:2/1001",
+		},
+		{
+			name:     "existing record is kept",
+			desired:  covers(1, 1, 1001, 1002),
+			existing: []models.UserAccess{record(10, 1, 1001)},
+			adds:     "1:1/1002",
+		},
+		{
+			name:    "lowest rule is credited",
+			desired: append(covers(2, 1, 1003), covers(1, 1, 1003)...),
+			adds:    "1:1/1003",
+		},
+		{
+			name:     "stale tracked record is removed",
+			existing: []models.UserAccess{record(10, 1, 1001), record(11, 2, 1001)},
+			tracked:  map[int]int{10: 1, 11: 3},
+			removes:  "1:1/1001 3:2/1001",
+			stale:    "[10 11]",
+		},
+		{
+			name:     "untracked record is never removed",
+			existing: []models.UserAccess{record(10, 1, 1001)},
+		},
+		{
+			name:     "rule without RemoveStale keeps its records",
+			existing: []models.UserAccess{record(10, 1, 1003)},
+			tracked:  map[int]int{10: 2},
+		},
+		{
+			name:     "record of a deleted rule is kept",
+			existing: []models.UserAccess{record(10, 1, 1001)},
+			tracked:  map[int]int{10: 9},
+		},
+		{
+			name:     "record another rule still covers is kept",
+			desired:  covers(2, 1, 1001),
+			existing: []models.UserAccess{record(10, 1, 1001)},
+			tracked:  map[int]int{10: 1},
+		},
+		{
+			name:        "record a role covers is kept",
+			existing:    []models.UserAccess{record(10, 1, 1001), record(11, 1, 1002)},
+			tracked:     map[int]int{10: 1, 11: 1},
+			roleCovered: map[int]bool{10: true},
+			removes:     "1:1/1002",
+			stale:       "[11]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adds, removes := planReconcile(rules, tt.desired, tt.existing, tt.tracked, tt.roleCovered)
+
+			if got := reconcileChanges(adds); got != tt.adds {
+				t.Errorf("adds %q, want %q", got, tt.adds)
+			}
+			if got := reconcileChanges(removes); got != tt.removes {
+				t.Errorf("removes %q, want %q", got, tt.removes)
+			}
+			if got := nonEmpty(staleAccessIDs(tt.existing, tt.tracked, removes)); got != tt.stale {
+				t.Errorf("stale access IDs %q, want %q", got, tt.stale)
+			}
+		})
+	}
+}
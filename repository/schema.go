@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// schemaStatements create the tables this tool owns next to the DWH tables
+var schemaStatements = []struct {
+	name  string
+	query string
+}{
+	{
+		name: "powerbi.UserAccessExpiry",
+		query: `
+			IF OBJECT_ID(N'powerbi.UserAccessExpiry', N'U') IS NULL
+			CREATE TABLE powerbi.UserAccessExpiry (
+				UserAccessID INT NOT NULL PRIMARY KEY
+					REFERENCES powerbi.UserAccess (UserAccessID) ON DELETE CASCADE,
+				ValidUntil DATETIME2 NOT NULL
+			)`,
+	},
+	{
+		name: "powerbi.AccessRule",
+		query: `
+			IF OBJECT_ID(N'powerbi.AccessRule', N'U') IS NULL
+			CREATE TABLE powerbi.AccessRule (
+				RuleID INT IDENTITY(1,1) NOT NULL PRIMARY KEY,
+				UserID INT NOT NULL,
+				Level2Name NVARCHAR(255) NULL,
+				Level3Name NVARCHAR(255) NULL,
+				RemoveStale BIT NOT NULL DEFAULT 0,
+				CreationDate DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME()
+			)`,
+	},
+	{
+		name: "powerbi.AccessRuleGrant",
+		query: `
+			IF OBJECT_ID(N'powerbi.AccessRuleGrant', N'U') IS NULL
+			CREATE TABLE powerbi.AccessRuleGrant (
+				UserAccessID INT NOT NULL PRIMARY KEY
+					REFERENCES powerbi.UserAccess (UserAccessID) ON DELETE CASCADE,
+				RuleID INT NOT NULL
+					REFERENCES powerbi.AccessRule (RuleID) ON DELETE CASCADE
+			)`,
+	},
+	{
+		name: "powerbi.AccessRole",
+		query: `
+			IF OBJECT_ID(N'powerbi.AccessRole', N'U') IS NULL
+			CREATE TABLE powerbi.AccessRole (
+				RoleID INT IDENTITY(1,1) NOT NULL PRIMARY KEY,
+				Name NVARCHAR(255) NOT NULL UNIQUE,
+				Description NVARCHAR(1000) NULL,
+				CreationDate DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME()
+			)`,
+	},
+	{
+		name: "powerbi.AccessRoleGroup",
+		query: `
+			IF OBJECT_ID(N'powerbi.AccessRoleGroup', N'U') IS NULL
+			CREATE TABLE powerbi.AccessRoleGroup (
+				RoleID INT NOT NULL
+					REFERENCES powerbi.AccessRole (RoleID) ON DELETE CASCADE,
+				Group_Bkey INT NOT NULL,
+				PRIMARY KEY (RoleID, Group_Bkey)
+			)`,
+	},
+	{
+		name: "powerbi.AccessRoleMember",
+		query: `
+			IF OBJECT_ID(N'powerbi.AccessRoleMember', N'U') IS NULL
+			CREATE TABLE powerbi.AccessRoleMember (
+				RoleID INT NOT NULL
+					REFERENCES powerbi.AccessRole (RoleID) ON DELETE CASCADE,
+				UserID INT NOT NULL,
+				CreationDate DATETIME2 NOT NULL DEFAULT SYSUTCDATETIME(),
+				PRIMARY KEY (RoleID, UserID)
+			)`,
+	},
+	{
+		name: "powerbi.AccessRoleGrant",
+		query: `
+			IF OBJECT_ID(N'powerbi.AccessRoleGrant', N'U') IS NULL
+			CREATE TABLE powerbi.AccessRoleGrant (
+				UserAccessID INT NOT NULL PRIMARY KEY
+					REFERENCES powerbi.UserAccess (UserAccessID) ON DELETE CASCADE,
+				RoleID INT NOT NULL
+					REFERENCES powerbi.AccessRole (RoleID)
+			)`,
+	},
+}
+
+// EnsureSchema creates the side tables used by the repositories when they are missing
+func EnsureSchema(ctx context.Context, db *sql.DB) error {
+	for _, stmt := range schemaStatements {
+		if _, err := db.ExecContext(ctx, stmt.query); err != nil {
+			return fmt.Errorf("failed to create %s: %w", stmt.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"powerbi-access-tool/models"
+)
+
+// Match quality, best first
+const (
+	matchNone = iota
+	matchContains
+	matchPrefix
+	matchExact
+)
+
+var matchNames = map[int]string{
+	matchContains: "contains",
+	matchPrefix:   "prefix",
+	matchExact:    "exact",
+}
+
+// Searchable columns, in the order they are reported in MatchedOn
+var searchColumns = []string{"groupbkey", "groupname", "level2name", "level3name"}
+
+// searchRow is one group/object combination with the match quality per column
+type searchRow struct {
+	groupBkey  int
+	groupName  string
+	objectName string
+	scores     map[string]int
+}
+
+// searchResults merges rows into one ranked result per group
+type searchResults struct {
+	byGroup map[int]*rankedResult
+}
+
+type rankedResult struct {
+	result  models.SearchResult
+	best    int
+	columns map[string]bool
+	objects map[string]bool
+}
+
+func newSearchResults() *searchResults {
+	return &searchResults{byGroup: make(map[int]*rankedResult)}
+}
+
+func (s *searchResults) add(row searchRow) {
+	r, ok := s.byGroup[row.groupBkey]
+	if !ok {
+		r = &rankedResult{
+			result:  models.SearchResult{GroupBkey: row.groupBkey, GroupName: row.groupName},
+			columns: make(map[string]bool),
+			objects: make(map[string]bool),
+		}
+		s.byGroup[row.groupBkey] = r
+	}
+
+	for column, score := range row.scores {
+		if score == matchNone {
+			continue
+		}
+		r.columns[column] = true
+		r.best = max(r.best, score)
+
+		// Only level matches come from the object itself
+		if (column == "level2name" || column == "level3name") && row.objectName != "" {
+			r.objects[row.objectName] = true
+		}
+	}
+}
+
+// ranked returns the results ordered by match quality, then group name
+func (s *searchResults) ranked() []models.SearchResult {
+	var ranked []*rankedResult
+	for _, r := range s.byGroup {
+		if r.best == matchNone {
+			continue
+		}
+		ranked = append(ranked, r)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].best != ranked[j].best {
+			return ranked[i].best > ranked[j].best
+		}
+		if ranked[i].result.GroupName != ranked[j].result.GroupName {
+			return ranked[i].result.GroupName < ranked[j].result.GroupName
+		}
+		return ranked[i].result.GroupBkey < ranked[j].result.GroupBkey
+	})
+
+	results := make([]models.SearchResult, 0, len(ranked))
+	for _, r := range ranked {
+		res := r.result
+		res.Match = matchNames[r.best]
+		res.MatchedOn = []string{}
+		for _, column := range searchColumns {
+			if r.columns[column] {
+				res.MatchedOn = append(res.MatchedOn, column)
+			}
+		}
+		res.MatchedObjects = make([]string, 0, len(r.objects))
+		for name := range r.objects {
+			res.MatchedObjects = append(res.MatchedObjects, name)
+		}
+		sort.Strings(res.MatchedObjects)
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// matchScore rates how well value matches term, ignoring case
+func matchScore(value string, term string) int {
+	value = strings.ToLower(value)
+	term = strings.ToLower(term)
+	switch {
+	case term == "":
+		return matchNone
+	case value == term:
+		return matchExact
+	case strings.HasPrefix(value, term):
+		return matchPrefix
+	case strings.Contains(value, term):
+		return matchContains
+	default:
+		return matchNone
+	}
+}
+
+// bkeyScore matches the numeric group key, which only counts when equal
+func bkeyScore(groupBkey int, term string) int {
+	if strconv.Itoa(groupBkey) == strings.TrimSpace(term) {
+		return matchExact
+	}
+	return matchNone
+}
+
+// escapeLike escapes the LIKE wildcards in a search term; use with ESCAPE '\'
+func escapeLike(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `[`, `\[`).Replace(term)
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"powerbi-access-tool/models"
+)
+
+// UserStore manages the rows in powerbi.Users
+type UserStore interface {
+	List(ctx context.Context, opts UserListOptions) ([]models.User, int, error)
+	GetByID(ctx context.Context, id int) (*models.User, error)
+	Create(ctx context.Context, email string) (int, error)
+	Update(ctx context.Context, id int, email string) error
+	Delete(ctx context.Context, id int) (int, error)
+}
+
+// AccessStore manages the rows in powerbi.UserAccess
+type AccessStore interface {
+	ListByUser(ctx context.Context, userID int, includeOrphaned bool) ([]models.UserAccess, error)
+	ListOrphaned(ctx context.Context) ([]models.OrphanedAccess, error)
+	RemoveOrphaned(ctx context.Context, accessIDs []int) ([]models.OrphanedAccess, error)
+	ListByGroup(ctx context.Context, groupBkey int) ([]models.GroupMember, error)
+	GetByID(ctx context.Context, accessID int) (*models.UserAccess, error)
+	AddGroups(ctx context.Context, userID int, groupBkeys []int) error
+	Grant(ctx context.Context, userID int, groupBkeys []int) (*models.GrantResult, error)
+	GrantWithExpiry(ctx context.Context, userID int, grants []models.Grant) (*models.GrantResult, error)
+	RemoveExpired(ctx context.Context, now time.Time) ([]models.UserAccess, error)
+	Remove(ctx context.Context, accessID int) error
+	Exists(ctx context.Context, userID int, groupBkey int) (bool, error)
+	Export(ctx context.Context, opts AccessExportOptions, fn func(models.AccessExportRow) error) error
+}
+
+// GroupCatalog provides read access to dim.[Group] and dim.[Object]
+type GroupCatalog interface {
+	List(ctx context.Context, opts GroupListOptions) ([]models.GroupSummary, int, error)
+	Search(ctx context.Context, searchTerm string, level2Scope []string) ([]models.SearchResult, error)
+	GetByBkey(ctx context.Context, groupBkey int) (*models.Group, error)
+	GetByBkeys(ctx context.Context, groupBkeys []int) (map[int]models.Group, error)
+	Children(ctx context.Context, path []string, userID int) ([]models.TreeNode, error)
+	GroupsUnder(ctx context.Context, level2Name string, level3Name string) ([]int, error)
+	Units(ctx context.Context, groupBkeys []int) (map[int][]models.OrgUnit, error)
+}
+
+// RuleStore manages access rules and materialises them into powerbi.UserAccess
+type RuleStore interface {
+	List(ctx context.Context, userID int) ([]models.AccessRule, error)
+	Create(ctx context.Context, rule models.AccessRule) (int, error)
+	Delete(ctx context.Context, id int) error
+	Reconcile(ctx context.Context, dryRun bool) (*models.ReconcileReport, error)
+}
+
+// RoleStore manages named sets of groups and keeps their members' access in line
+// with them. Changes that affect access report what was granted and revoked.
+type RoleStore interface {
+	List(ctx context.Context) ([]models.Role, error)
+	ListByUser(ctx context.Context, userID int) ([]models.Role, error)
+	GetByID(ctx context.Context, id int) (*models.Role, error)
+	Create(ctx context.Context, name, description string, groupBkeys []int) (int, error)
+	Update(ctx context.Context, id int, name, description string, groupBkeys []int) (*models.RoleSync, error)
+	Delete(ctx context.Context, id int) (*models.RoleSync, error)
+	ListMembers(ctx context.Context, roleID int) ([]models.RoleMember, error)
+	Assign(ctx context.Context, roleID, userID int) (*models.RoleSync, error)
+	Unassign(ctx context.Context, roleID, userID int) (*models.RoleSync, error)
+}
+
+// PlanApplier applies a desired-state plan atomically and returns the IDs of the users it created
+type PlanApplier interface {
+	Apply(ctx context.Context, plan *models.Plan) (models.CreatedUsers, error)
+}
+
+var (
+	_ UserStore    = (*UserRepository)(nil)
+	_ AccessStore  = (*AccessRepository)(nil)
+	_ GroupCatalog = (*GroupRepository)(nil)
+	_ RuleStore    = (*RuleRepository)(nil)
+	_ RoleStore    = (*RoleRepository)(nil)
+	_ PlanApplier  = (*PlanRepository)(nil)
+	_ UserStore    = (*MemoryUserRepository)(nil)
+	_ AccessStore  = (*MemoryAccessRepository)(nil)
+	_ GroupCatalog = (*MemoryGroupRepository)(nil)
+	_ RuleStore    = (*MemoryRuleRepository)(nil)
+	_ RoleStore    = (*MemoryRoleRepository)(nil)
+	_ PlanApplier  = (*MemoryPlanRepository)(nil)
+)
@@ -0,0 +1,38 @@
+package repository
+
+import "database/sql"
+
+// Stores bundles the repositories the application works with.
+// The zero value has no stores, which is how a missing database connection is represented.
+type Stores struct {
+	Users  UserStore
+	Access AccessStore
+	Groups GroupCatalog
+	Rules  RuleStore
+	Roles  RoleStore
+	Plans  PlanApplier
+}
+
+// NewSQLStores returns the SQL Server repositories for db
+func NewSQLStores(db *sql.DB) Stores {
+	return Stores{
+		Users:  NewUserRepository(db),
+		Access: NewAccessRepository(db),
+		Groups: NewGroupRepository(db),
+		Rules:  NewRuleRepository(db),
+		Roles:  NewRoleRepository(db),
+		Plans:  NewPlanRepository(db),
+	}
+}
+
+// NewMemoryStores returns the in-memory repositories for db
+func NewMemoryStores(db *MemoryDB) Stores {
+	return Stores{
+		Users:  NewMemoryUserRepository(db),
+		Access: NewMemoryAccessRepository(db),
+		Groups: NewMemoryGroupRepository(db),
+		Rules:  NewMemoryRuleRepository(db),
+		Roles:  NewMemoryRoleRepository(db),
+		Plans:  NewMemoryPlanRepository(db),
+	}
+}
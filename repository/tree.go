@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"powerbi-access-tool/models"
+)
+
+// treeLevels are the dim.[Object] columns of the hierarchy, top down
+var treeLevels = []struct {
+	name   string
+	column string
+}{
+	{"level1", "Level1Name"},
+	{"level2", "Level2Name"},
+	{"level3", "Level3Name"},
+}
+
+// Children returns the child nodes below path in the dim.[Object] hierarchy.
+// An empty path returns the Level1 nodes; a full Level1/Level2/Level3 path returns
+// the groups. Access counts are for userID, or zero when userID is 0.
+func (r *GroupRepository) Children(ctx context.Context, path []string, userID int) ([]models.TreeNode, error) {
+	if len(path) > len(treeLevels) {
+		return nil, fmt.Errorf("invalid tree path: too many levels")
+	}
+
+	args := []interface{}{userID}
+	var conditions []string
+	for i, name := range path {
+		args = append(args, name)
+		conditions = append(conditions, fmt.Sprintf("COALESCE(o.%s, '') = @p%d", treeLevels[i].column, len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	if len(path) == len(treeLevels) {
+		return r.groupNodes(ctx, where, args)
+	}
+
+	level := treeLevels[len(path)]
+	query := fmt.Sprintf(`
+		SELECT COALESCE(o.%[1]s, ''), COUNT(DISTINCT g.Group_Bkey), COUNT(DISTINCT ua.Group_Bkey)
+		FROM dim.[Object] o
+		INNER JOIN dim.[Group] g ON g.Group_Bkey = o.Group_Bkey
+		LEFT JOIN powerbi.UserAccess ua ON ua.Group_Bkey = g.Group_Bkey AND ua.UserID = @p1
+		%[2]s
+		GROUP BY COALESCE(o.%[1]s, '')
+		ORDER BY COALESCE(o.%[1]s, '')`, level.column, where)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s nodes: %w", level.name, err)
+	}
+	defer rows.Close()
+
+	var nodes []models.TreeNode
+	for rows.Next() {
+		n := models.TreeNode{Level: level.name}
+		if err := rows.Scan(&n.Name, &n.GroupCount, &n.GrantedCount); err != nil {
+			return nil, fmt.Errorf("failed to scan tree node: %w", err)
+		}
+		nodes = append(nodes, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tree nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
+func (r *GroupRepository) groupNodes(ctx context.Context, where string, args []interface{}) ([]models.TreeNode, error) {
+	query := fmt.Sprintf(`
+		SELECT g.Group_Bkey, g.GroupName,
+			CASE WHEN EXISTS (
+				SELECT 1 FROM powerbi.UserAccess ua
+				WHERE ua.Group_Bkey = g.Group_Bkey AND ua.UserID = @p1
+			) THEN 1 ELSE 0 END
+		FROM dim.[Group] g
+		WHERE g.Group_Bkey IN (SELECT o.Group_Bkey FROM dim.[Object] o %s)
+		ORDER BY g.GroupName`, where)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group nodes: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []models.TreeNode
+	for rows.Next() {
+		n := models.TreeNode{Level: "group", GroupCount: 1}
+		if err := rows.Scan(&n.GroupBkey, &n.Name, &n.GrantedCount); err != nil {
+			return nil, fmt.Errorf("failed to scan group node: %w", err)
+		}
+		nodes = append(nodes, n)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating group nodes: %w", err)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"powerbi-access-tool/models"
+)
+
+// withTx runs fn inside a transaction, committing on success and rolling back on error
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// placeholders returns "@pN, @pN+1, ..." for count parameters starting at first
+func placeholders(first int, count int) string {
+	params := make([]string, count)
+	for i := range params {
+		params[i] = fmt.Sprintf("@p%d", first+i)
+	}
+	return strings.Join(params, ", ")
+}
+
+// uniqueGrants drops repeated group keys, keeping the first grant for each group
+func uniqueGrants(grants []models.Grant) []models.Grant {
+	seen := make(map[int]bool, len(grants))
+	var result []models.Grant
+	for _, g := range grants {
+		if !seen[g.GroupBkey] {
+			seen[g.GroupBkey] = true
+			result = append(result, g)
+		}
+	}
+	return result
+}
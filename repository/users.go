@@ -16,35 +16,42 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) List(ctx context.Context, filter string, sortField string, sortDir string) ([]models.User, error) {
-	query := `SELECT PowerBIUserID, PowerBIUser FROM powerbi.Users`
+// UserListOptions filters, sorts and pages a user listing. A Limit of 0 returns all rows.
+type UserListOptions struct {
+	Filter    string
+	SortField string
+	SortDir   string
+	Offset    int
+	Limit     int
+}
 
+// List returns one page of users together with the total number of matching users
+func (r *UserRepository) List(ctx context.Context, opts UserListOptions) ([]models.User, int, error) {
+	where := ""
 	var args []interface{}
-	if filter != "" {
-		query += ` WHERE PowerBIUser LIKE @p1`
-		args = append(args, "%"+filter+"%")
+	if opts.Filter != "" {
+		where = ` WHERE PowerBIUser LIKE @p1`
+		args = append(args, "%"+opts.Filter+"%")
 	}
 
-	// Validate sort field to prevent SQL injection
-	validSortFields := map[string]string{
-		"id":    "PowerBIUserID",
-		"email": "PowerBIUser",
-	}
-	dbField, ok := validSortFields[sortField]
-	if !ok {
-		dbField = "PowerBIUser"
+	var total int
+	countQuery := `SELECT COUNT(1) FROM powerbi.Users` + where
+	if err := r.db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
 	}
 
-	// Validate sort direction
-	if sortDir != "asc" && sortDir != "desc" {
-		sortDir = "asc"
-	}
+	query := `SELECT PowerBIUserID, PowerBIUser FROM powerbi.Users` + where
+
+	query += ` ORDER BY ` + userOrderBy(opts, "")
 
-	query += fmt.Sprintf(` ORDER BY %s %s`, dbField, sortDir)
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(` OFFSET @p%d ROWS FETCH NEXT @p%d ROWS ONLY`, len(args)+1, len(args)+2)
+		args = append(args, opts.Offset, opts.Limit)
+	}
 
 	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query users: %w", err)
+		return nil, 0, fmt.Errorf("failed to query users: %w", err)
 	}
 	defer rows.Close()
 
@@ -52,16 +59,44 @@ func (r *UserRepository) List(ctx context.Context, filter string, sortField stri
 	for rows.Next() {
 		var u models.User
 		if err := rows.Scan(&u.PowerBIUserID, &u.PowerBIUser); err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
+			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
 		}
 		users = append(users, u)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
+		return nil, 0, fmt.Errorf("error iterating users: %w", err)
 	}
 
-	return users, nil
+	return users, total, nil
+}
+
+// userOrderBy builds the ORDER BY list for the sort options, with the columns
+// prefixed by alias when set. Unknown fields and directions fall back to email ascending.
+func userOrderBy(opts UserListOptions, alias string) string {
+	// Validate sort field to prevent SQL injection
+	validSortFields := map[string]string{
+		"id":    "PowerBIUserID",
+		"email": "PowerBIUser",
+	}
+	dbField, ok := validSortFields[opts.SortField]
+	if !ok {
+		dbField = "PowerBIUser"
+	}
+
+	// Validate sort direction
+	sortDir := opts.SortDir
+	if sortDir != "asc" && sortDir != "desc" {
+		sortDir = "asc"
+	}
+
+	prefix := ""
+	if alias != "" {
+		prefix = alias + "."
+	}
+
+	// Tie-break on the ID so pages stay stable
+	return fmt.Sprintf(`%[1]s%[2]s %[3]s, %[1]sPowerBIUserID %[3]s`, prefix, dbField, sortDir)
 }
 
 func (r *UserRepository) GetByID(ctx context.Context, id int) (*models.User, error) {
@@ -108,28 +143,53 @@ func (r *UserRepository) Update(ctx context.Context, id int, email string) error
 	return nil
 }
 
-func (r *UserRepository) Delete(ctx context.Context, id int) error {
-	// Delete related access records first
-	accessQuery := `DELETE FROM powerbi.UserAccess WHERE UserID = @p1`
-	_, err := r.db.ExecContext(ctx, accessQuery, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete user access records: %w", err)
-	}
+// Delete removes the user and all of its access records in one transaction.
+// It returns the number of access records that were removed.
+func (r *UserRepository) Delete(ctx context.Context, id int) (int, error) {
+	var accessRemoved int
 
-	// Delete the user
-	query := `DELETE FROM powerbi.Users WHERE PowerBIUserID = @p1`
-	result, err := r.db.ExecContext(ctx, query, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
-	}
+	err := withTx(ctx, r.db, func(tx *sql.Tx) error {
+		// Delete the user's rules and role memberships so nothing grants them again
+		if _, err := tx.ExecContext(ctx, `DELETE FROM powerbi.AccessRule WHERE UserID = @p1`, id); err != nil {
+			return fmt.Errorf("failed to delete user rules: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM powerbi.AccessRoleMember WHERE UserID = @p1`, id); err != nil {
+			return fmt.Errorf("failed to delete user roles: %w", err)
+		}
 
-	rowsAffected, err := result.RowsAffected()
+		// Delete related access records first
+		accessQuery := `DELETE FROM powerbi.UserAccess WHERE UserID = @p1`
+		accessResult, err := tx.ExecContext(ctx, accessQuery, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete user access records: %w", err)
+		}
+
+		removed, err := accessResult.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		accessRemoved = int(removed)
+
+		// Delete the user
+		query := `DELETE FROM powerbi.Users WHERE PowerBIUserID = @p1`
+		result, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("user not found")
+		}
+
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return 0, err
 	}
 
-	return nil
+	return accessRemoved, nil
 }
@@ -0,0 +1,268 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+// ErrDeletesAllUsers is returned when a file without users would delete every user,
+// which is far more often an empty or truncated file than the intent
+var ErrDeletesAllUsers = errors.New("the state file lists no users, so every user would be deleted")
+
+// Plan compares the file with powerbi.Users and powerbi.UserAccess and returns
+// the changes that make the database match it. Unknown group keys and Level2
+// names without groups are errors, so a typo never revokes access. A file
+// without users is refused unless allowDeleteAll is set.
+func Plan(ctx context.Context, stores repository.Stores, f *File, allowDeleteAll bool) (*models.Plan, error) {
+	groups := newGroupResolver(stores.Groups)
+
+	// Look up every group key in the file at once instead of per user
+	var groupBkeys []int
+	for _, u := range f.Users {
+		groupBkeys = append(groupBkeys, u.Groups...)
+	}
+	if err := groups.load(ctx, groupBkeys); err != nil {
+		return nil, err
+	}
+
+	desired := make(map[string]map[int]bool, len(f.Users))
+	emails := make(map[string]string, len(f.Users))
+	for email, u := range f.Users {
+		email = strings.TrimSpace(email)
+		key := strings.ToLower(email)
+		emails[key] = email
+
+		want, err := groups.resolve(ctx, email, u)
+		if err != nil {
+			return nil, err
+		}
+		desired[key] = want
+	}
+
+	// Groups under Level2 units still need their names for the plan
+	var under []int
+	for _, want := range desired {
+		under = append(under, sortedKeys(want)...)
+	}
+	if err := groups.load(ctx, under); err != nil {
+		return nil, err
+	}
+
+	users, _, err := stores.Users.List(ctx, repository.UserListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]models.User, len(users))
+	for _, u := range users {
+		key := strings.ToLower(u.PowerBIUser)
+		if other, ok := existing[key]; ok {
+			return nil, fmt.Errorf("users %d and %d share the email %s", other.PowerBIUserID, u.PowerBIUserID, u.PowerBIUser)
+		}
+		existing[key] = u
+	}
+	if len(f.Users) == 0 && len(existing) > 0 && !allowDeleteAll {
+		return nil, ErrDeletesAllUsers
+	}
+
+	var creates, grants, revokes, deletes []models.PlanChange
+
+	for _, key := range sortedKeys(desired) {
+		want := desired[key]
+		user, ok := existing[key]
+		if !ok {
+			creates = append(creates, models.PlanChange{Action: models.PlanCreateUser, Email: emails[key]})
+			for _, groupBkey := range sortedKeys(want) {
+				grants = append(grants, groups.change(models.PlanGrant, 0, emails[key], groupBkey))
+			}
+			continue
+		}
+
+		access, err := stores.Access.ListByUser(ctx, user.PowerBIUserID, true)
+		if err != nil {
+			return nil, err
+		}
+
+		has := make(map[int]bool, len(access))
+		for _, a := range access {
+			if want[a.GroupBkey] && !has[a.GroupBkey] {
+				has[a.GroupBkey] = true
+				continue
+			}
+			revokes = append(revokes, models.PlanChange{
+				Action:    models.PlanRevoke,
+				UserID:    user.PowerBIUserID,
+				Email:     user.PowerBIUser,
+				AccessID:  a.UserAccessID,
+				GroupBkey: a.GroupBkey,
+				GroupName: a.GroupName,
+			})
+		}
+
+		for _, groupBkey := range sortedKeys(want) {
+			if !has[groupBkey] {
+				grants = append(grants, groups.change(models.PlanGrant, user.PowerBIUserID, user.PowerBIUser, groupBkey))
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(existing) {
+		if _, ok := desired[key]; !ok {
+			user := existing[key]
+			deletes = append(deletes, models.PlanChange{
+				Action: models.PlanDeleteUser,
+				UserID: user.PowerBIUserID,
+				Email:  user.PowerBIUser,
+			})
+		}
+	}
+
+	plan := &models.Plan{Changes: []models.PlanChange{}}
+	for _, changes := range [][]models.PlanChange{creates, grants, revokes, deletes} {
+		plan.Changes = append(plan.Changes, changes...)
+	}
+	return plan, nil
+}
+
+// Summary counts the changes per action
+func Summary(plan *models.Plan) map[string]int {
+	counts := make(map[string]int)
+	for _, c := range plan.Changes {
+		counts[c.Action]++
+	}
+	return counts
+}
+
+// Print writes the plan in the style of terraform plan
+func Print(w io.Writer, plan *models.Plan) error {
+	if len(plan.Changes) == 0 {
+		_, err := fmt.Fprintln(w, "No changes. Access matches the desired state.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range plan.Changes {
+		switch c.Action {
+		case models.PlanCreateUser:
+			fmt.Fprintf(tw, "  + create\t%s\n", c.Email)
+		case models.PlanGrant:
+			fmt.Fprintf(tw, "  + grant\t%s\t%d\t%s\n", c.Email, c.GroupBkey, c.GroupName)
+		case models.PlanRevoke:
+			name := c.GroupName
+			if name == "" {
+				name = "(group no longer exists)"
+			}
+			fmt.Fprintf(tw, "  - revoke\t%s\t%d\t%s\n", c.Email, c.GroupBkey, name)
+		case models.PlanDeleteUser:
+			fmt.Fprintf(tw, "  - delete\t%s\n", c.Email)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	counts := Summary(plan)
+	_, err := fmt.Fprintf(w, "\nPlan: %d to create, %d to grant, %d to revoke, %d to delete.\n",
+		counts[models.PlanCreateUser], counts[models.PlanGrant], counts[models.PlanRevoke], counts[models.PlanDeleteUser])
+	return err
+}
+
+// groupResolver looks up groups and Level2 units once per plan
+type groupResolver struct {
+	catalog repository.GroupCatalog
+	names   map[int]string
+	unknown map[int]bool
+	units   map[string][]int
+}
+
+func newGroupResolver(catalog repository.GroupCatalog) *groupResolver {
+	return &groupResolver{
+		catalog: catalog,
+		names:   make(map[int]string),
+		unknown: make(map[int]bool),
+		units:   make(map[string][]int),
+	}
+}
+
+// load looks up the groups not seen before in one catalog query
+func (g *groupResolver) load(ctx context.Context, groupBkeys []int) error {
+	var missing []int
+	for _, groupBkey := range groupBkeys {
+		if _, ok := g.names[groupBkey]; !ok && !g.unknown[groupBkey] {
+			missing = append(missing, groupBkey)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	groups, err := g.catalog.GetByBkeys(ctx, missing)
+	if err != nil {
+		return err
+	}
+	for _, groupBkey := range missing {
+		if group, ok := groups[groupBkey]; ok {
+			g.names[groupBkey] = group.GroupName
+		} else {
+			g.unknown[groupBkey] = true
+		}
+	}
+	return nil
+}
+
+// resolve returns the set of groups a user entry asks for
+func (g *groupResolver) resolve(ctx context.Context, email string, u User) (map[int]bool, error) {
+	want := make(map[int]bool)
+
+	for _, groupBkey := range u.Groups {
+		if err := g.load(ctx, []int{groupBkey}); err != nil {
+			return nil, err
+		}
+		if g.unknown[groupBkey] {
+			return nil, fmt.Errorf("%s: group %d does not exist", email, groupBkey)
+		}
+		want[groupBkey] = true
+	}
+
+	for _, level2Name := range u.Level2 {
+		groupBkeys, ok := g.units[level2Name]
+		if !ok {
+			var err error
+			groupBkeys, err = g.catalog.GroupsUnder(ctx, level2Name, "")
+			if err != nil {
+				return nil, err
+			}
+			if len(groupBkeys) == 0 {
+				return nil, fmt.Errorf("%s: no groups found under Level2 %q", email, level2Name)
+			}
+			g.units[level2Name] = groupBkeys
+		}
+		for _, groupBkey := range groupBkeys {
+			want[groupBkey] = true
+		}
+	}
+
+	return want, nil
+}
+
+// change builds a plan entry for a loaded group
+func (g *groupResolver) change(action string, userID int, email string, groupBkey int) models.PlanChange {
+	return models.PlanChange{Action: action, UserID: userID, Email: email, GroupBkey: groupBkey, GroupName: g.names[groupBkey]}
+}
+
+func sortedKeys[K int | string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,191 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"powerbi-access-tool/models"
+	"powerbi-access-tool/repository"
+)
+
+// countingCatalog counts the group lookups that reach the catalog
+type countingCatalog struct {
+	repository.GroupCatalog
+	single, batched int
+}
+
+func (c *countingCatalog) GetByBkey(ctx context.Context, groupBkey int) (*models.Group, error) {
+	c.single++
+	return c.GroupCatalog.GetByBkey(ctx, groupBkey)
+}
+
+func (c *countingCatalog) GetByBkeys(ctx context.Context, groupBkeys []int) (map[int]models.Group, error) {
+	c.batched++
+	return c.GroupCatalog.GetByBkeys(ctx, groupBkeys)
+}
+
+func demoStores() repository.Stores {
+	db := repository.NewMemoryDB()
+	db.SeedDemo()
+	return repository.NewMemoryStores(db)
+}
+
+// planChanges formats a plan as one "action email group" line per change
+func planChanges(plan *models.Plan) string {
+	lines := make([]string, len(plan.Changes))
+	for i, c := range plan.Changes {
+		lines[i] = strings.TrimSpace(fmt.Sprintf("%s %s %s", c.Action, c.Email, groupText(c.GroupBkey)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func groupText(groupBkey int) string {
+	if groupBkey == 0 {
+		return ""
+	}
+	return fmt.Sprint(groupBkey)
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		access  map[int][]int // user ID → groups granted before planning
+		users   map[string]User
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "matching state has no changes",
+			access: map[int][]int{1: {1001}, 2: {1003}},
+			users: map[string]User{
+				"anna@voorbeeld.nl": {Groups: []int{1001}},
+				"bert@voorbeeld.nl": {Groups: []int{1003}},
+			},
+		},
+		{
+			name:   "changes come as creates, grants, revokes, deletes",
+			access: map[int][]int{1: {1001, 1005}, 2: {1003}},
+			users: map[string]User{
+				"anna@voorbeeld.nl":  {Groups: []int{1001, 1002}},
+				"carla@voorbeeld.nl": {Groups: []int{1004}},
+			},
+			want: []string{
+				models.PlanCreateUser + " carla@voorbeeld.nl",
+				models.PlanGrant + " anna@voorbeeld.nl 1002",
+				models.PlanGrant + " carla@voorbeeld.nl 1004",
+				models.PlanRevoke + " anna@voorbeeld.nl 1005",
+				models.PlanDeleteUser + " bert@voorbeeld.nl",
+			},
+		},
+		{
+			name: "Level2 units expand to their groups",
+			users: map[string]User{
+				"Anna@Voorbeeld.nl": {Level2: []string{"Regio Noord"}},
+				"bert@voorbeeld.nl": {Groups: []int{1002}, Level2: []string{"Regio Noord", "Holding"}},
+			},
+			want: []string{
+				models.PlanGrant + " anna@voorbeeld.nl 1001",
+				models.PlanGrant + " anna@voorbeeld.nl 1002",
+				models.PlanGrant + " bert@voorbeeld.nl 1001",
+				models.PlanGrant + " bert@voorbeeld.nl 1002",
+				models.PlanGrant + " bert@voorbeeld.nl 1005",
+			},
+		},
+		{
+			name:   "duplicate access record is revoked",
+			access: map[int][]int{1: {1001, 1001}},
+			users: map[string]User{
+				"anna@voorbeeld.nl": {Groups: []int{1001}},
+				"bert@voorbeeld.nl": {},
+			},
+			want: []string{models.PlanRevoke + " anna@voorbeeld.nl 1001"},
+		},
+		{
+			name:    "unknown group",
+			users:   map[string]User{"anna@voorbeeld.nl": {Groups: []int{9999}}},
+			wantErr: "group 9999 does not exist",
+		},
+		{
+			name:    "Level2 without groups",
+			users:   map[string]User{"anna@voorbeeld.nl": {Level2: []string{"Regio West"}}},
+			wantErr: `no groups found under Level2 "Regio West"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			stores := demoStores()
+			for userID, groupBkeys := range tt.access {
+				for _, groupBkey := range groupBkeys {
+					if err := stores.Access.AddGroups(ctx, userID, []int{groupBkey}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			plan, err := Plan(ctx, stores, &File{Users: tt.users}, false)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("plan: %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := planChanges(plan), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("plan:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestPlanBatchesGroupLookups(t *testing.T) {
+	stores := demoStores()
+	catalog := &countingCatalog{GroupCatalog: stores.Groups}
+	stores.Groups = catalog
+
+	f := &File{Users: map[string]User{
+		"anna@voorbeeld.nl":  {Groups: []int{1001, 1003}},
+		"bert@voorbeeld.nl":  {Groups: []int{1003}, Level2: []string{"Regio Noord"}},
+		"carla@voorbeeld.nl": {Level2: []string{"Holding"}},
+	}}
+	plan, err := Plan(context.Background(), stores, f, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range plan.Changes {
+		if c.Action == models.PlanGrant && c.GroupName == "" {
+			t.Errorf("grant of group %d to %s has no group name", c.GroupBkey, c.Email)
+		}
+	}
+	// One lookup for the keys in the file, one for the groups under Level2 units
+	if catalog.single != 0 || catalog.batched != 2 {
+		t.Errorf("plan made %d single and %d batched group lookups, want 2 batched lookups", catalog.single, catalog.batched)
+	}
+}
+
+func TestPlanRefusesToDeleteAllUsers(t *testing.T) {
+	ctx := context.Background()
+	empty := &File{}
+
+	if _, err := Plan(ctx, demoStores(), empty, false); !errors.Is(err, ErrDeletesAllUsers) {
+		t.Fatalf("plan of an empty file: %v, want ErrDeletesAllUsers", err)
+	}
+
+	plan, err := Plan(ctx, demoStores(), empty, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := Summary(plan); counts[models.PlanDeleteUser] != 2 || len(plan.Changes) != 2 {
+		t.Errorf("plan with allowDeleteAll has %v, want the 2 demo users deleted", counts)
+	}
+
+	// Without users there is nothing to protect
+	if _, err := Plan(ctx, repository.NewMemoryStores(repository.NewMemoryDB()), empty, false); err != nil {
+		t.Errorf("plan of an empty file against an empty store: %v", err)
+	}
+}
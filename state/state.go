@@ -0,0 +1,79 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the desired access state, kept in version control. It is authoritative:
+// users in powerbi.Users that are not listed are deleted by apply.
+type File struct {
+	Users map[string]User `json:"users" yaml:"users"`
+}
+
+// User lists the access for one email. Groups are Group_Bkeys; each Level2 name
+// stands for every group below that unit in dim.[Object].
+type User struct {
+	Groups []int    `json:"groups,omitempty" yaml:"groups,omitempty"`
+	Level2 []string `json:"level2,omitempty" yaml:"level2,omitempty"`
+}
+
+// Load reads a desired-state file. Files ending in .yaml or .yml are read as YAML,
+// everything else as JSON.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	default:
+		return ParseJSON(data)
+	}
+}
+
+// ParseJSON decodes a desired-state file, rejecting unknown fields so typos do not go unnoticed
+func ParseJSON(data []byte) (*File, error) {
+	var f File
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return &f, f.validate()
+}
+
+// ParseYAML decodes a desired-state file, rejecting unknown fields so typos do not go unnoticed
+func ParseYAML(data []byte) (*File, error) {
+	var f File
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return &f, f.validate()
+}
+
+// validate rejects empty emails and emails listed twice with different casing
+func (f *File) validate() error {
+	seen := make(map[string]string, len(f.Users))
+	for email := range f.Users {
+		key := strings.ToLower(strings.TrimSpace(email))
+		if key == "" {
+			return fmt.Errorf("state file contains an empty email")
+		}
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("state file lists %s and %s, which are the same user", other, email)
+		}
+		seen[key] = email
+	}
+	return nil
+}
@@ -1,9 +1,15 @@
 // State
 let users = [];
+let usersPage = 0;
+let usersTotal = 0;
+let usersHasMore = false;
+let usersLoading = false;
+let usersGeneration = 0;
 let selectedUserId = null;
 let selectedUserEmail = null;
 let accessList = [];
 let searchResults = [];
+let groupCatalogPage = 1;
 
 // DOM Elements
 const usersList = document.getElementById('users-list');
@@ -12,6 +18,10 @@ const selectedUserName = document.getElementById('selected-user-name');
 const addGroupsBtn = document.getElementById('add-groups-btn');
 const userFilter = document.getElementById('user-filter');
 const userSort = document.getElementById('user-sort');
+const usersCount = document.getElementById('users-count');
+const usersPanelBody = usersList.parentElement;
+
+const USERS_PAGE_SIZE = 50;
 
 // Initialize
 document.addEventListener('DOMContentLoaded', () => {
@@ -23,6 +33,17 @@ document.addEventListener('DOMContentLoaded', () => {
     // Sort users on change
     userSort.addEventListener('change', () => loadUsers());
 
+    // Load the next page when scrolled near the bottom
+    usersPanelBody.addEventListener('scroll', () => {
+        if (usersPanelBody.scrollTop + usersPanelBody.clientHeight >= usersPanelBody.scrollHeight - 100) {
+            loadMoreUsers();
+        }
+    });
+
+    // Group catalog filter and sort
+    document.getElementById('group-catalog-filter').addEventListener('input', debounce(() => loadGroupCatalog(1), 300));
+    document.getElementById('group-catalog-sort').addEventListener('change', () => loadGroupCatalog(1));
+
     // Search on enter
     document.getElementById('group-search-input').addEventListener('keypress', (e) => {
         if (e.key === 'Enter') {
@@ -40,14 +61,17 @@ function debounce(fn, delay) {
     };
 }
 
-// API calls
+// API calls; the server refuses changes without the page's CSRF token
+const csrfToken = document.querySelector('meta[name="csrf-token"]').content;
+
 async function api(url, options = {}) {
     const response = await fetch(url, {
+        ...options,
         headers: {
             'Content-Type': 'application/json',
+            'X-CSRF-Token': csrfToken,
             ...options.headers
-        },
-        ...options
+        }
     });
 
     if (!response.ok) {
@@ -62,18 +86,16 @@ async function api(url, options = {}) {
     return response.json();
 }
 
-// Load users
+// Load users (first page)
 async function loadUsers() {
-    const filter = userFilter.value;
-    const [sortField, sortDir] = userSort.value.split('-');
+    usersGeneration++;
+    usersPage = 0;
+    usersHasMore = false;
+    users = [];
+    usersPanelBody.scrollTop = 0;
 
     try {
-        let url = `/api/users?sort=${sortField}&dir=${sortDir}`;
-        if (filter) {
-            url += `&filter=${encodeURIComponent(filter)}`;
-        }
-
-        users = await api(url);
+        await fetchUsersPage(1);
         renderUsers();
     } catch (error) {
         console.error('Failed to load users:', error);
@@ -81,8 +103,64 @@ async function loadUsers() {
     }
 }
 
+// Load the next page of users for infinite scroll
+async function loadMoreUsers() {
+    if (!usersHasMore || usersLoading) {
+        return;
+    }
+
+    try {
+        await fetchUsersPage(usersPage + 1);
+        renderUsers();
+    } catch (error) {
+        console.error('Failed to load more users:', error);
+    }
+}
+
+async function fetchUsersPage(page) {
+    const filter = userFilter.value;
+    const [sortField, sortDir] = userSort.value.split('-');
+
+    let url = `/api/users?sort=${sortField}&dir=${sortDir}&page=${page}&limit=${USERS_PAGE_SIZE}`;
+    if (filter) {
+        url += `&filter=${encodeURIComponent(filter)}`;
+    }
+
+    const generation = usersGeneration;
+    usersLoading = true;
+    try {
+        const result = await api(url);
+
+        // Ignore responses for a list that was reset in the meantime
+        if (generation !== usersGeneration) {
+            return;
+        }
+
+        users = users.concat(result.items);
+        usersPage = result.page;
+        usersTotal = result.total;
+        usersHasMore = result.hasMore;
+    } finally {
+        usersLoading = false;
+    }
+}
+
+// Download the access of the users matching the current filter and sort order
+function exportAccess(format) {
+    const filter = userFilter.value;
+    const [sortField, sortDir] = userSort.value.split('-');
+
+    let url = `/api/export/access?format=${format}&sort=${sortField}&dir=${sortDir}`;
+    if (filter) {
+        url += `&filter=${encodeURIComponent(filter)}`;
+    }
+    window.location.href = url;
+}
+
 // Render users
 function renderUsers() {
+    usersCount.textContent = usersTotal > 0 ? `(${usersTotal})` : '';
+
     if (!users || users.length === 0) {
         usersList.innerHTML = '<div class="empty-state">Geen gebruikers gevonden</div>';
         return;
@@ -93,8 +171,10 @@ function renderUsers() {
              onclick="selectUser(${user.id}, '${escapeHtml(user.email)}')">
             <span class="user-item-email">${escapeHtml(user.email)}</span>
             <div class="user-item-actions">
-                <button class="btn btn-sm btn-secondary" onclick="event.stopPropagation(); showEditUserModal(${user.id}, '${escapeHtml(user.email)}')">Bewerken</button>
-                <button class="btn btn-sm btn-danger" onclick="event.stopPropagation(); showDeleteUserModal(${user.id}, '${escapeHtml(user.email)}')">Verwijderen</button>
+                <button class="btn btn-sm btn-secondary editor-only unscoped-only" onclick="event.stopPropagation(); showEditUserModal(${user.id}, '${escapeHtml(user.email)}')">Bewerken</button>
+                <button class="btn btn-sm btn-secondary editor-only unscoped-only" onclick="event.stopPropagation(); showCopyModal(${user.id}, '${escapeHtml(user.email)}')">Kopiëren</button>
+                <button class="btn btn-sm btn-secondary unscoped-only" onclick="event.stopPropagation(); showCompareModal(${user.id}, '${escapeHtml(user.email)}')">Vergelijken</button>
+                <button class="btn btn-sm btn-danger editor-only unscoped-only" onclick="event.stopPropagation(); showDeleteUserModal(${user.id}, '${escapeHtml(user.email)}')">Verwijderen</button>
             </div>
         </div>
     `).join('');
@@ -118,7 +198,7 @@ async function selectUser(userId, email) {
 // Load user access
 async function loadUserAccess(userId) {
     try {
-        accessList = await api(`/api/users/${userId}/access`);
+        accessList = await api(`/api/users/${userId}/access?orphaned=1`);
         renderAccessList();
     } catch (error) {
         console.error('Failed to load user access:', error);
@@ -134,12 +214,13 @@ function renderAccessList() {
     }
 
     accessListEl.innerHTML = accessList.map(access => `
-        <div class="access-item">
+        <div class="access-item ${access.orphaned ? 'access-item-orphaned' : ''}">
             <div class="access-item-info">
-                <div class="access-item-name">${escapeHtml(access.groupName)}</div>
+                <div class="access-item-name">${access.orphaned ? `Onbekende groep (${access.groupBkey})` : escapeHtml(access.groupName)}</div>
                 <div class="access-item-date">Toegevoegd: ${formatDate(access.creationDate)}</div>
+                ${access.validUntil ? `<div class="access-item-expiry">${formatCountdown(access.validUntil)}</div>` : ''}
             </div>
-            <button class="btn btn-sm btn-danger" onclick="removeAccess(${access.id})">Verwijderen</button>
+            <button class="btn btn-sm btn-danger editor-only" onclick="removeAccess(${access.id})">Verwijderen</button>
         </div>
     `).join('');
 }
@@ -230,14 +311,111 @@ async function confirmDeleteUser() {
 }
 
 // Search Modal functions
+const matchLabels = {
+    exact: 'Exacte match',
+    prefix: 'Begint met zoekterm',
+    contains: 'Gevonden'
+};
+
 function showSearchModal() {
     document.getElementById('group-search-input').value = '';
+    document.getElementById('group-valid-until').value = '';
     document.getElementById('search-results').innerHTML = '<div class="search-empty">Voer een zoekterm in</div>';
     searchResults = [];
+    document.getElementById('group-tree').innerHTML = '';
+    showSearchTab();
     document.getElementById('search-modal').classList.add('active');
     document.getElementById('group-search-input').focus();
 }
 
+function showSearchTab() {
+    document.getElementById('search-tab').classList.add('active');
+    document.getElementById('browse-tab').classList.remove('active');
+    document.getElementById('search-pane').hidden = false;
+    document.getElementById('browse-pane').hidden = true;
+}
+
+function showBrowseTab() {
+    document.getElementById('browse-tab').classList.add('active');
+    document.getElementById('search-tab').classList.remove('active');
+    document.getElementById('browse-pane').hidden = false;
+    document.getElementById('search-pane').hidden = true;
+
+    const treeEl = document.getElementById('group-tree');
+    if (!treeEl.hasChildNodes()) {
+        loadTreeChildren(treeEl, []);
+    }
+}
+
+// Load one level of the Level1 → Level2 → Level3 → Group tree into a list element
+async function loadTreeChildren(listEl, path) {
+    listEl.innerHTML = '<li class="loading">Laden...</li>';
+
+    const params = path.map(p => `path=${encodeURIComponent(p)}`);
+    params.push(`userId=${selectedUserId}`);
+
+    try {
+        const nodes = await api(`/api/groups/tree?${params.join('&')}`);
+        listEl.innerHTML = '';
+
+        if (nodes.length === 0) {
+            listEl.innerHTML = '<li class="search-empty">Geen items</li>';
+            return;
+        }
+
+        nodes.forEach(node => listEl.appendChild(renderTreeNode(node, path)));
+    } catch (error) {
+        listEl.innerHTML = '<li class="search-empty">Fout bij laden: ' + escapeHtml(error.message) + '</li>';
+    }
+}
+
+function renderTreeNode(node, parentPath) {
+    const item = document.createElement('li');
+
+    if (node.level === 'group') {
+        const granted = node.grantedCount > 0;
+        item.innerHTML = `
+            <label class="tree-node tree-leaf ${granted ? 'tree-granted' : ''}">
+                <input type="checkbox" class="group-select" value="${node.groupBkey}" ${granted ? 'checked disabled' : ''}>
+                ${escapeHtml(node.name)}
+                ${granted ? '<span class="tree-count">toegewezen</span>' : ''}
+            </label>
+        `;
+        return item;
+    }
+
+    const path = parentPath.concat([node.name]);
+    item.innerHTML = `
+        <div class="tree-node">
+            <span class="tree-toggle">&#9656;</span>
+            ${escapeHtml(node.name || '(leeg)')}
+            <span class="tree-count">${node.grantedCount}/${node.groupCount}</span>
+            ${node.level !== 'level1' && node.grantedCount < node.groupCount ? '<button class="btn btn-sm btn-secondary tree-grant">Alles toekennen</button>' : ''}
+        </div>
+        <ul class="tree" hidden></ul>
+    `;
+
+    const grantBtn = item.querySelector('.tree-grant');
+    if (grantBtn) {
+        grantBtn.addEventListener('click', (e) => {
+            e.stopPropagation();
+            grantSubtree(path[1], path[2] || '', node.groupCount - node.grantedCount);
+        });
+    }
+
+    const toggle = item.querySelector('.tree-node');
+    const children = item.querySelector('ul');
+    toggle.addEventListener('click', () => {
+        children.hidden = !children.hidden;
+        toggle.querySelector('.tree-toggle').innerHTML = children.hidden ? '&#9656;' : '&#9662;';
+        if (!children.hidden && !children.hasChildNodes()) {
+            loadTreeChildren(children, path);
+        }
+    });
+
+    return item;
+}
+
 function hideSearchModal() {
     document.getElementById('search-modal').classList.remove('active');
 }
@@ -272,11 +450,13 @@ async function searchGroups() {
 
         resultsEl.innerHTML = filteredResults.map(result => `
             <label class="search-result-item">
-                <input type="checkbox" value="${result.groupBkey}">
+                <input type="checkbox" class="group-select" value="${result.groupBkey}">
                 <div class="search-result-info">
                     <div class="search-result-name">${escapeHtml(result.groupName)}</div>
-                    <div class="search-result-match">Gevonden in: ${result.matchedOn}</div>
+                    <div class="search-result-match">${matchLabels[result.match]} in: ${result.matchedOn.join(', ')}</div>
+                    ${result.matchedObjects.length > 0 ? `<div class="search-result-match">Objecten: ${escapeHtml(result.matchedObjects.join(', '))}</div>` : ''}
                 </div>
+                <button class="btn btn-sm btn-secondary unscoped-only" onclick="event.preventDefault(); showGroupUsersModal(${result.groupBkey}, '${escapeHtml(result.groupName)}')">Gebruikers</button>
             </label>
         `).join('');
     } catch (error) {
@@ -285,22 +465,820 @@ async function searchGroups() {
 }
 
 async function addSelectedGroups() {
-    const checkboxes = document.querySelectorAll('#search-results input[type="checkbox"]:checked');
-    const groupBkeys = Array.from(checkboxes).map(cb => parseInt(cb.value));
+    // Selections from both the search results and the tree
+    const checkboxes = document.querySelectorAll('#search-modal .group-select:checked:not(:disabled)');
+    const groupBkeys = [...new Set(Array.from(checkboxes).map(cb => parseInt(cb.value)))];
 
     if (groupBkeys.length === 0) {
         alert('Selecteer minimaal één groep');
         return;
     }
 
+    // A date grants access until the end of that day
+    const validUntilDate = document.getElementById('group-valid-until').value;
+    const validUntil = validUntilDate ? new Date(validUntilDate + 'T23:59:59').toISOString() : undefined;
+
+    try {
+        const result = await api(`/api/users/${selectedUserId}/access`, {
+            method: 'POST',
+            body: JSON.stringify({ groupBkeys, validUntil })
+        });
+
+        hideSearchModal();
+        await loadUserAccess(selectedUserId);
+
+        if (result && result.unknown.length > 0) {
+            alert('Onbekende groepen overgeslagen: ' + result.unknown.join(', '));
+        }
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+// Group Catalog Modal functions
+function showGroupCatalogModal() {
+    document.getElementById('group-catalog-modal').classList.add('active');
+    loadGroupCatalog(1);
+}
+
+function hideGroupCatalogModal() {
+    document.getElementById('group-catalog-modal').classList.remove('active');
+}
+
+async function loadGroupCatalog(page) {
+    const rowsEl = document.getElementById('group-catalog-rows');
+    const filter = document.getElementById('group-catalog-filter').value.trim();
+    const [sortField, sortDir] = document.getElementById('group-catalog-sort').value.split('-');
+
+    let url = `/api/groups?sort=${sortField}&dir=${sortDir}&page=${page}`;
+    if (filter) {
+        url += `&filter=${encodeURIComponent(filter)}`;
+    }
+
+    try {
+        const result = await api(url);
+        groupCatalogPage = result.page;
+
+        document.getElementById('group-catalog-count').textContent = `(${result.total})`;
+        document.getElementById('group-catalog-page').textContent = `Pagina ${result.page} van ${Math.max(1, Math.ceil(result.total / result.limit))}`;
+        document.getElementById('group-catalog-prev').disabled = result.page <= 1;
+        document.getElementById('group-catalog-next').disabled = !result.hasMore;
+
+        if (result.items.length === 0) {
+            rowsEl.innerHTML = '<tr><td colspan="4" class="search-empty">Geen groepen gevonden</td></tr>';
+            return;
+        }
+
+        rowsEl.innerHTML = result.items.map(group => `
+            <tr>
+                <td>${group.groupBkey}</td>
+                <td>${escapeHtml(group.groupName)}</td>
+                <td class="numeric"><a href="#" onclick="showGroupUsersModal(${group.groupBkey}, '${escapeHtml(group.groupName)}'); return false;">${group.memberCount}</a></td>
+                <td class="numeric">${group.objectCount}</td>
+            </tr>
+        `).join('');
+    } catch (error) {
+        rowsEl.innerHTML = '<tr><td colspan="4" class="search-empty">Fout bij laden: ' + escapeHtml(error.message) + '</td></tr>';
+    }
+}
+
+// Orphaned Access Modal functions
+async function showOrphanedModal() {
+    document.getElementById('orphaned-modal').classList.add('active');
+    await loadOrphaned();
+}
+
+function hideOrphanedModal() {
+    document.getElementById('orphaned-modal').classList.remove('active');
+}
+
+async function loadOrphaned() {
+    const rowsEl = document.getElementById('orphaned-rows');
+    document.getElementById('orphaned-select-all').checked = false;
+
+    try {
+        const orphaned = await api('/api/access/orphaned');
+
+        if (orphaned.length === 0) {
+            rowsEl.innerHTML = '<tr><td colspan="4" class="search-empty">Geen verweesde rechten gevonden</td></tr>';
+            return;
+        }
+
+        rowsEl.innerHTML = orphaned.map(o => `
+            <tr>
+                <td><input type="checkbox" class="orphaned-select" value="${o.id}"></td>
+                <td>${escapeHtml(o.userEmail || ('#' + o.userId))}</td>
+                <td>${o.groupBkey}</td>
+                <td>${formatDate(o.creationDate)}</td>
+            </tr>
+        `).join('');
+    } catch (error) {
+        rowsEl.innerHTML = '<tr><td colspan="4" class="search-empty">Fout bij laden: ' + escapeHtml(error.message) + '</td></tr>';
+    }
+}
+
+function toggleAllOrphaned(checked) {
+    document.querySelectorAll('.orphaned-select').forEach(cb => cb.checked = checked);
+}
+
+async function cleanupOrphaned() {
+    const checkboxes = document.querySelectorAll('.orphaned-select:checked');
+    const accessIds = Array.from(checkboxes).map(cb => parseInt(cb.value));
+
+    if (accessIds.length === 0) {
+        alert('Selecteer minimaal één regel');
+        return;
+    }
+
+    if (!confirm(`${accessIds.length} verweesde regel(s) verwijderen?`)) {
+        return;
+    }
+
+    try {
+        const result = await api('/api/access/orphaned/cleanup', {
+            method: 'POST',
+            body: JSON.stringify({ accessIds })
+        });
+        alert(`${result.removed.length} regel(s) verwijderd`);
+        await loadOrphaned();
+
+        if (selectedUserId) {
+            await loadUserAccess(selectedUserId);
+        }
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+// Access Rules Modal functions
+async function showRulesModal() {
+    document.getElementById('rules-user-name').textContent = selectedUserEmail || 'geselecteerde gebruiker';
+    document.getElementById('rules-report').innerHTML = '';
+    document.getElementById('rules-modal').classList.add('active');
+    await loadRules();
+}
+
+function hideRulesModal() {
+    document.getElementById('rules-modal').classList.remove('active');
+}
+
+async function loadRules() {
+    const rowsEl = document.getElementById('rules-rows');
+
+    try {
+        const rules = await api('/api/rules');
+
+        if (rules.length === 0) {
+            rowsEl.innerHTML = '<tr><td colspan="6" class="search-empty">Geen regels</td></tr>';
+            return;
+        }
+
+        rowsEl.innerHTML = rules.map(rule => `
+            <tr>
+                <td>${escapeHtml(rule.userEmail || ('#' + rule.userId))}</td>
+                <td>${escapeHtml(rule.level2Name || '-')}</td>
+                <td>${escapeHtml(rule.level3Name || '-')}</td>
+                <td>${rule.removeStale ? 'Ja' : 'Nee'}</td>
+                <td>${formatDate(rule.creationDate)}</td>
+                <td><button class="btn btn-danger btn-sm editor-only" onclick="deleteRule(${rule.id})">Verwijderen</button></td>
+            </tr>
+        `).join('');
+    } catch (error) {
+        rowsEl.innerHTML = '<tr><td colspan="6" class="search-empty">Fout bij laden: ' + escapeHtml(error.message) + '</td></tr>';
+    }
+}
+
+async function createRule() {
+    if (!selectedUserId) {
+        alert('Selecteer eerst een gebruiker');
+        return;
+    }
+
+    const level2Name = document.getElementById('rule-level2').value.trim();
+    const level3Name = document.getElementById('rule-level3').value.trim();
+    const removeStale = document.getElementById('rule-remove-stale').checked;
+
+    if (!level2Name && !level3Name) {
+        alert('Vul een Level2- of Level3-naam in');
+        return;
+    }
+
+    try {
+        await api('/api/rules', {
+            method: 'POST',
+            body: JSON.stringify({ userId: selectedUserId, level2Name, level3Name, removeStale })
+        });
+        document.getElementById('rule-level2').value = '';
+        document.getElementById('rule-level3').value = '';
+        document.getElementById('rule-remove-stale').checked = false;
+        await loadRules();
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+async function deleteRule(ruleId) {
+    if (!confirm('Regel verwijderen? Toegekende rechten blijven behouden.')) {
+        return;
+    }
+
+    try {
+        await api(`/api/rules/${ruleId}`, { method: 'DELETE' });
+        await loadRules();
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+async function reconcileRules(dryRun) {
+    const reportEl = document.getElementById('rules-report');
+    reportEl.innerHTML = '<div class="loading">Bezig...</div>';
+
+    try {
+        const report = await api(`/api/rules/reconcile${dryRun ? '?dryRun=1' : ''}`, { method: 'POST' });
+        const rows = [
+            ...report.added.map(c => ({ ...c, change: dryRun ? 'Wordt toegekend' : 'Toegekend' })),
+            ...report.removed.map(c => ({ ...c, change: dryRun ? 'Wordt ingetrokken' : 'Ingetrokken' }))
+        ];
+
+        if (rows.length === 0) {
+            reportEl.innerHTML = '<div class="search-empty">Alle regels zijn bijgewerkt, geen wijzigingen</div>';
+            return;
+        }
+
+        reportEl.innerHTML = `
+            <table class="data-table">
+                <thead><tr><th>Wijziging</th><th>Gebruiker</th><th>Groep</th><th>Regel</th></tr></thead>
+                <tbody>
+                    ${rows.map(c => `
+                        <tr>
+                            <td>${c.change}</td>
+                            <td>${escapeHtml(c.userEmail || ('#' + c.userId))}</td>
+                            <td>${escapeHtml(c.groupName || String(c.groupBkey))}</td>
+                            <td>${c.ruleId}</td>
+                        </tr>
+                    `).join('')}
+                </tbody>
+            </table>
+        `;
+
+        if (!dryRun && selectedUserId) {
+            await loadUserAccess(selectedUserId);
+        }
+    } catch (error) {
+        reportEl.innerHTML = '<div class="search-empty">Fout: ' + escapeHtml(error.message) + '</div>';
+    }
+}
+
+// Roles Modal functions
+let roles = [];
+let selectedUserRoleIds = new Set();
+let editingRoleId = null;
+
+async function showRolesModal() {
+    resetRoleForm();
+    document.getElementById('roles-report').innerHTML = '';
+    document.getElementById('roles-modal').classList.add('active');
+    await loadRoles();
+}
+
+function hideRolesModal() {
+    document.getElementById('roles-modal').classList.remove('active');
+}
+
+async function loadRoles() {
+    const rowsEl = document.getElementById('roles-rows');
+
+    try {
+        roles = await api('/api/roles');
+        selectedUserRoleIds = new Set();
+        if (selectedUserId) {
+            const userRoles = await api(`/api/users/${selectedUserId}/roles`);
+            selectedUserRoleIds = new Set(userRoles.map(role => role.id));
+        }
+
+        if (roles.length === 0) {
+            rowsEl.innerHTML = '<tr><td colspan="5" class="search-empty">Geen rollen</td></tr>';
+            return;
+        }
+
+        rowsEl.innerHTML = roles.map(role => `
+            <tr>
+                <td>${escapeHtml(role.name)}</td>
+                <td>${escapeHtml(role.description || '-')}</td>
+                <td class="numeric" title="${escapeHtml(role.groups.map(g => g.groupName || g.groupBkey).join(', '))}">${role.groups.length}</td>
+                <td class="numeric">${role.memberCount}</td>
+                <td>
+                    ${selectedUserId ? (selectedUserRoleIds.has(role.id)
+                        ? `<button class="btn btn-secondary btn-sm editor-only" onclick="unassignRole(${role.id})">Intrekken</button>`
+                        : `<button class="btn btn-primary btn-sm editor-only" onclick="assignRole(${role.id})">Toewijzen</button>`) : ''}
+                    <button class="btn btn-secondary btn-sm editor-only" onclick="editRole(${role.id})">Bewerken</button>
+                    <button class="btn btn-danger btn-sm editor-only" onclick="deleteRole(${role.id})">Verwijderen</button>
+                </td>
+            </tr>
+        `).join('');
+    } catch (error) {
+        rowsEl.innerHTML = '<tr><td colspan="5" class="search-empty">Fout bij laden: ' + escapeHtml(error.message) + '</td></tr>';
+    }
+}
+
+function resetRoleForm() {
+    editingRoleId = null;
+    document.getElementById('role-form-title').textContent = 'Nieuwe rol';
+    document.getElementById('role-name').value = '';
+    document.getElementById('role-description').value = '';
+    document.getElementById('role-groups').value = '';
+    document.getElementById('role-cancel-btn').hidden = true;
+}
+
+function editRole(roleId) {
+    const role = roles.find(r => r.id === roleId);
+    if (!role) {
+        return;
+    }
+
+    editingRoleId = roleId;
+    document.getElementById('role-form-title').textContent = 'Rol bewerken: ' + role.name;
+    document.getElementById('role-name').value = role.name;
+    document.getElementById('role-description').value = role.description;
+    document.getElementById('role-groups').value = role.groups.map(g => g.groupBkey).join(', ');
+    document.getElementById('role-cancel-btn').hidden = false;
+}
+
+async function saveRole() {
+    const name = document.getElementById('role-name').value.trim();
+    const description = document.getElementById('role-description').value.trim();
+    const groupBkeys = document.getElementById('role-groups').value
+        .split(/[\s,;]+/)
+        .filter(Boolean)
+        .map(Number);
+
+    if (!name || groupBkeys.length === 0 || groupBkeys.some(isNaN)) {
+        alert('Vul een naam en een of meer geldige group keys in');
+        return;
+    }
+
+    try {
+        const body = JSON.stringify({ name, description, groupBkeys });
+        if (editingRoleId) {
+            const sync = await api(`/api/roles/${editingRoleId}`, { method: 'PUT', body });
+            await renderRoleSync(sync);
+        } else {
+            await api('/api/roles', { method: 'POST', body });
+            document.getElementById('roles-report').innerHTML = '';
+        }
+        resetRoleForm();
+        await loadRoles();
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+async function deleteRole(roleId) {
+    if (!confirm('Rol verwijderen? De rechten die deze rol heeft toegekend worden bij alle leden ingetrokken.')) {
+        return;
+    }
+
+    try {
+        const sync = await api(`/api/roles/${roleId}`, { method: 'DELETE' });
+        await renderRoleSync(sync);
+        await loadRoles();
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+async function assignRole(roleId) {
+    try {
+        const sync = await api(`/api/roles/${roleId}/members`, {
+            method: 'POST',
+            body: JSON.stringify({ userId: selectedUserId })
+        });
+        await renderRoleSync(sync);
+        await loadRoles();
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+async function unassignRole(roleId) {
+    try {
+        const sync = await api(`/api/roles/${roleId}/members/${selectedUserId}`, { method: 'DELETE' });
+        await renderRoleSync(sync);
+        await loadRoles();
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+// Show the access a role change granted and revoked, and refresh the selected user's access
+async function renderRoleSync(sync) {
+    const reportEl = document.getElementById('roles-report');
+    const rows = [
+        ...sync.added.map(c => ({ ...c, change: 'Toegekend' })),
+        ...sync.removed.map(c => ({ ...c, change: 'Ingetrokken' }))
+    ];
+
+    if (rows.length === 0) {
+        reportEl.innerHTML = '<div class="search-empty">Geen wijzigingen in toegang</div>';
+    } else {
+        reportEl.innerHTML = `
+            <table class="data-table">
+                <thead><tr><th>Wijziging</th><th>Gebruiker</th><th>Groep</th></tr></thead>
+                <tbody>
+                    ${rows.map(c => `
+                        <tr>
+                            <td>${c.change}</td>
+                            <td>${escapeHtml(c.userEmail || ('#' + c.userId))}</td>
+                            <td>${escapeHtml(c.groupName || String(c.groupBkey))}</td>
+                        </tr>
+                    `).join('')}
+                </tbody>
+            </table>
+        `;
+    }
+
+    if (selectedUserId) {
+        await loadUserAccess(selectedUserId);
+    }
+}
+
+// CSV Import Modal functions
+let importCsv = null;
+
+function showImportModal() {
+    importCsv = null;
+    document.getElementById('import-file').value = '';
+    document.getElementById('import-summary').innerHTML = '';
+    document.getElementById('import-rows').innerHTML = '';
+    document.getElementById('import-apply-btn').disabled = true;
+    document.getElementById('import-modal').classList.add('active');
+}
+
+function hideImportModal() {
+    document.getElementById('import-modal').classList.remove('active');
+}
+
+async function previewImport() {
+    const file = document.getElementById('import-file').files[0];
+    document.getElementById('import-apply-btn').disabled = true;
+    if (!file) {
+        return;
+    }
+
+    importCsv = await file.text();
+
+    try {
+        const preview = await api('/api/import?dryRun=1', {
+            method: 'POST',
+            headers: { 'Content-Type': 'text/csv' },
+            body: importCsv
+        });
+        renderImportPreview(preview);
+        document.getElementById('import-apply-btn').disabled =
+            preview.errorCount > 0 || (preview.creates === 0 && preview.grants === 0);
+    } catch (error) {
+        document.getElementById('import-summary').innerHTML = '<div class="alert alert-danger">' + escapeHtml(error.message) + '</div>';
+        document.getElementById('import-rows').innerHTML = '';
+    }
+}
+
+function renderImportPreview(preview) {
+    const summaryEl = document.getElementById('import-summary');
+    if (preview.applied) {
+        summaryEl.innerHTML = `<div class="alert alert-success">${preview.creates} gebruiker(s) aangemaakt, ${preview.grants} groep(en) toegekend</div>`;
+    } else if (preview.errorCount > 0) {
+        summaryEl.innerHTML = `<div class="alert alert-danger">${preview.errorCount} regel(s) met fouten. Pas het bestand aan en upload opnieuw.</div>`;
+    } else {
+        summaryEl.innerHTML = `<p>${preview.creates} nieuwe gebruiker(s), ${preview.grants} groep(en) toe te kennen.</p>`;
+    }
+
+    document.getElementById('import-rows').innerHTML = preview.rows.map(row => `
+        <tr>
+            <td>${row.line}</td>
+            <td>${escapeHtml(row.email)}</td>
+            <td>${row.errors.length > 0 ? '-' : (row.createUser ? 'Nieuwe gebruiker' : 'Bestaande gebruiker')}</td>
+            <td class="numeric">${row.grants.length}</td>
+            <td class="numeric">${row.existing.length}</td>
+            <td class="import-errors">${row.errors.map(escapeHtml).join('<br>')}</td>
+        </tr>
+    `).join('');
+}
+
+async function applyImport() {
+    if (!importCsv) {
+        return;
+    }
+
+    const applyBtn = document.getElementById('import-apply-btn');
+    applyBtn.disabled = true;
+
+    try {
+        const preview = await api('/api/import', {
+            method: 'POST',
+            headers: { 'Content-Type': 'text/csv' },
+            body: importCsv
+        });
+        renderImportPreview(preview);
+        importCsv = null;
+        await loadUsers();
+        if (selectedUserId) {
+            await loadUserAccess(selectedUserId);
+        }
+    } catch (error) {
+        document.getElementById('import-summary').innerHTML = '<div class="alert alert-danger">' + escapeHtml(error.message) + '</div>';
+    }
+}
+
+// Copy Access Modal functions
+let copyTargetId = null;
+let copySources = [];
+
+function showCopyModal(userId, email) {
+    copyTargetId = userId;
+    copySources = [];
+    document.getElementById('copy-target-name').textContent = email;
+    document.getElementById('copy-source').value = '';
+    document.getElementById('copy-source-options').innerHTML = '';
+    document.getElementById('copy-mode').value = 'merge';
+    document.getElementById('copy-summary').innerHTML = '';
+    document.getElementById('copy-rows').innerHTML = '';
+    document.getElementById('copy-apply-btn').disabled = true;
+    document.getElementById('copy-modal').classList.add('active');
+}
+
+function hideCopyModal() {
+    document.getElementById('copy-modal').classList.remove('active');
+}
+
+async function searchCopySource() {
+    const filter = document.getElementById('copy-source').value.trim();
+    document.getElementById('copy-apply-btn').disabled = true;
+    if (filter.length < 2) {
+        return;
+    }
+
+    try {
+        copySources = await suggestUsers(filter, copyTargetId, 'copy-source-options');
+        if (copySources.some(user => user.email === filter)) {
+            await previewCopy();
+        }
+    } catch (error) {
+        console.error('Failed to search users:', error);
+    }
+}
+
+// Fill a datalist with the users matching filter, except excludeId, and return them
+async function suggestUsers(filter, excludeId, datalistId) {
+    const result = await api(`/api/users?filter=${encodeURIComponent(filter)}&limit=20`);
+    const matches = result.items.filter(user => user.id !== excludeId);
+    document.getElementById(datalistId).innerHTML = matches
+        .map(user => `<option value="${escapeHtml(user.email)}"></option>`)
+        .join('');
+    return matches;
+}
+
+// copySourceId returns the ID of the user whose email was picked, or null
+function copySourceId() {
+    const email = document.getElementById('copy-source').value.trim();
+    const source = copySources.find(user => user.email === email);
+    return source ? source.id : null;
+}
+
+function copyUrl(sourceId, dryRun) {
+    const mode = document.getElementById('copy-mode').value;
+    return `/api/users/${copyTargetId}/access/copy-from/${sourceId}?mode=${mode}${dryRun ? '&dryRun=1' : ''}`;
+}
+
+async function previewCopy() {
+    const sourceId = copySourceId();
+    document.getElementById('copy-apply-btn').disabled = true;
+    if (!sourceId) {
+        document.getElementById('copy-summary').innerHTML = '<div class="alert alert-danger">Kies een bestaande gebruiker om van te kopiëren</div>';
+        document.getElementById('copy-rows').innerHTML = '';
+        return;
+    }
+
+    try {
+        const diff = await api(copyUrl(sourceId, true), { method: 'POST' });
+        renderCopyDiff(diff);
+        document.getElementById('copy-apply-btn').disabled = diff.add.length === 0 && diff.remove.length === 0;
+    } catch (error) {
+        document.getElementById('copy-summary').innerHTML = '<div class="alert alert-danger">' + escapeHtml(error.message) + '</div>';
+        document.getElementById('copy-rows').innerHTML = '';
+    }
+}
+
+function renderCopyDiff(diff) {
+    const counts = `${diff.add.length} toe te kennen, ${diff.remove.length} in te trekken, ${diff.unchanged.length} ongewijzigd`;
+    document.getElementById('copy-summary').innerHTML = diff.dryRun
+        ? `<p>${counts}.</p>`
+        : `<div class="alert alert-success">Gekopieerd: ${counts}</div>`;
+
+    const rows = [
+        ...diff.add.map(access => ({ label: 'Toevoegen', access })),
+        ...diff.remove.map(access => ({ label: 'Intrekken', access })),
+        ...diff.unchanged.map(access => ({ label: 'Ongewijzigd', access }))
+    ];
+    document.getElementById('copy-rows').innerHTML = rows.map(({ label, access }) => `
+        <tr>
+            <td>${label}</td>
+            <td class="numeric">${access.groupBkey}</td>
+            <td>${escapeHtml(access.groupName)}</td>
+            <td>${access.validUntil ? formatDate(access.validUntil) : '-'}</td>
+        </tr>
+    `).join('');
+}
+
+async function applyCopy() {
+    const sourceId = copySourceId();
+    if (!sourceId) {
+        return;
+    }
+
+    const applyBtn = document.getElementById('copy-apply-btn');
+    applyBtn.disabled = true;
+
+    try {
+        const diff = await api(copyUrl(sourceId, false), { method: 'POST' });
+        renderCopyDiff(diff);
+        if (selectedUserId === copyTargetId) {
+            await loadUserAccess(selectedUserId);
+        }
+    } catch (error) {
+        document.getElementById('copy-summary').innerHTML = '<div class="alert alert-danger">' + escapeHtml(error.message) + '</div>';
+    }
+}
+
+// Compare Access Modal functions
+let compareAId = null;
+let compareCandidates = [];
+let comparison = null;
+
+function showCompareModal(userId, email) {
+    compareAId = userId;
+    compareCandidates = [];
+    comparison = null;
+    document.getElementById('compare-a-name').textContent = email;
+    document.getElementById('compare-b').value = '';
+    document.getElementById('compare-b-options').innerHTML = '';
+    renderComparison();
+    document.getElementById('compare-modal').classList.add('active');
+}
+
+function hideCompareModal() {
+    document.getElementById('compare-modal').classList.remove('active');
+}
+
+async function searchCompareUser() {
+    const filter = document.getElementById('compare-b').value.trim();
+    if (filter.length < 2) {
+        return;
+    }
+
+    try {
+        compareCandidates = await suggestUsers(filter, compareAId, 'compare-b-options');
+        const match = compareCandidates.find(user => user.email === filter);
+        if (match) {
+            await loadComparison(match.id);
+        }
+    } catch (error) {
+        console.error('Failed to search users:', error);
+    }
+}
+
+async function loadComparison(userBId) {
+    try {
+        comparison = await api(`/api/users/compare?a=${compareAId}&b=${userBId}`);
+        renderComparison();
+    } catch (error) {
+        comparison = null;
+        renderComparison();
+        document.getElementById('compare-summary').innerHTML = '<div class="alert alert-danger">' + escapeHtml(error.message) + '</div>';
+    }
+}
+
+function renderComparison() {
+    const c = comparison;
+    document.getElementById('compare-only-a-title').textContent = c ? c.a.email : 'A';
+    document.getElementById('compare-only-b-title').textContent = c ? c.b.email : 'B';
+    document.getElementById('compare-to-b-btn').disabled = !c || c.onlyA.length === 0;
+    document.getElementById('compare-to-a-btn').disabled = !c || c.onlyB.length === 0;
+    document.getElementById('compare-summary').innerHTML = c
+        ? `<p>${c.onlyA.length} alleen bij A, ${c.both.length} bij beide, ${c.onlyB.length} alleen bij B.</p>`
+        : '';
+
+    document.getElementById('compare-only-a').innerHTML = c ? renderComparedGroups(c.onlyA, 'a', 'b', 'Naar B &rarr;') : '';
+    document.getElementById('compare-both').innerHTML = c ? renderComparedGroups(c.both) : '';
+    document.getElementById('compare-only-b').innerHTML = c ? renderComparedGroups(c.onlyB, 'b', 'a', '&larr; Naar A') : '';
+}
+
+function renderComparedGroups(groups, from, to, label) {
+    if (groups.length === 0) {
+        return '<div class="search-empty">Geen groepen</div>';
+    }
+
+    return groups.map(group => `
+        <div class="compare-item">
+            <div>
+                <div>${escapeHtml(group.groupName || String(group.groupBkey))}</div>
+                <div class="compare-units">${group.units.map(u => escapeHtml([u.level2Name, u.level3Name].filter(Boolean).join(' / '))).join('<br>') || '-'}</div>
+            </div>
+            ${from ? `<button class="btn btn-secondary btn-sm editor-only" onclick="grantCompared('${from}', '${to}', ${group.groupBkey})">${label}</button>` : ''}
+        </div>
+    `).join('');
+}
+
+// Grant one group the 'from' user has to the 'to' user, keeping its expiry
+async function grantCompared(from, to, groupBkey) {
+    const group = comparison[from === 'a' ? 'onlyA' : 'onlyB'].find(g => g.groupBkey === groupBkey);
+    const validUntil = from === 'a' ? group.validUntilA : group.validUntilB;
+
+    try {
+        await api(`/api/users/${comparison[to].id}/access`, {
+            method: 'POST',
+            body: JSON.stringify({ grants: [{ groupBkey, validUntil }] })
+        });
+        await refreshComparison();
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+// Give the 'to' user every group only the 'from' user has
+async function copyCompared(from, to) {
+    const source = comparison[from];
+    const target = comparison[to];
+    if (!confirm(`Alle groepen van ${source.email} die ${target.email} mist toekennen?`)) {
+        return;
+    }
+
+    try {
+        await api(`/api/users/${target.id}/access/copy-from/${source.id}?mode=merge`, { method: 'POST' });
+        await refreshComparison();
+    } catch (error) {
+        alert('Fout: ' + error.message);
+    }
+}
+
+async function refreshComparison() {
+    await loadComparison(comparison.b.id);
+    if (selectedUserId === comparison.a.id || selectedUserId === comparison.b.id) {
+        await loadUserAccess(selectedUserId);
+    }
+}
+
+// Group Users Modal functions
+async function showGroupUsersModal(groupBkey, groupName) {
+    const listEl = document.getElementById('group-users-list');
+    document.getElementById('group-users-name').textContent = groupName;
+    listEl.innerHTML = '<div class="loading">Laden...</div>';
+    document.getElementById('group-users-modal').classList.add('active');
+
     try {
-        await api(`/api/users/${selectedUserId}/access`, {
+        const members = await api(`/api/groups/${groupBkey}/users`);
+
+        if (members.length === 0) {
+            listEl.innerHTML = '<div class="search-empty">Geen gebruikers met toegang</div>';
+            return;
+        }
+
+        listEl.innerHTML = members.map(member => `
+            <div class="search-result-item">
+                <div class="search-result-info">
+                    <div class="search-result-name">${escapeHtml(member.email)}</div>
+                    <div class="search-result-match">Toegevoegd: ${formatDate(member.creationDate)}${member.validUntil ? ' &middot; ' + formatCountdown(member.validUntil) : ''}</div>
+                </div>
+            </div>
+        `).join('');
+    } catch (error) {
+        listEl.innerHTML = '<div class="search-empty">Fout bij laden: ' + escapeHtml(error.message) + '</div>';
+    }
+}
+
+function hideGroupUsersModal() {
+    document.getElementById('group-users-modal').classList.remove('active');
+}
+
+// Grant every group below a Level2 or Level3 unit
+async function grantSubtree(level2Name, level3Name, newCount) {
+    const unit = level3Name ? `${level2Name} / ${level3Name}` : level2Name;
+    if (!confirm(`${newCount} groep(en) onder ${unit} toekennen aan ${selectedUserEmail}?`)) {
+        return;
+    }
+
+    const validUntilDate = document.getElementById('group-valid-until').value;
+    const validUntil = validUntilDate ? new Date(validUntilDate + 'T23:59:59').toISOString() : undefined;
+
+    try {
+        const result = await api(`/api/users/${selectedUserId}/access/subtree`, {
             method: 'POST',
-            body: JSON.stringify({ groupBkeys })
+            body: JSON.stringify({ level2Name, level3Name, validUntil })
         });
 
         hideSearchModal();
         await loadUserAccess(selectedUserId);
+        alert(`${result.addedCount} nieuwe groep(en) toegekend, ${result.existingCount} al aanwezig`);
     } catch (error) {
         alert('Fout: ' + error.message);
     }
@@ -331,3 +1309,18 @@ function formatDate(dateString) {
         day: '2-digit'
     });
 }
+
+function formatCountdown(dateString) {
+    const remaining = new Date(dateString) - new Date();
+    if (remaining <= 0) {
+        return 'Verlopen';
+    }
+
+    const hours = Math.floor(remaining / (60 * 60 * 1000));
+    if (hours < 24) {
+        return `Verloopt over ${hours} uur`;
+    }
+
+    const days = Math.floor(hours / 24);
+    return `Verloopt over ${days} ${days === 1 ? 'dag' : 'dagen'} (${formatDate(dateString)})`;
+}
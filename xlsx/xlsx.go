@@ -0,0 +1,267 @@
+// Package xlsx writes simple Office Open XML workbooks as a stream: rows go
+// straight into the zip archive, so large sheets are never held in memory.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cell styles defined in styles.xml
+const (
+	styleDefault = 0
+	styleDate    = 1
+	styleHeader  = 2
+)
+
+// maxSheetName is the longest sheet name Excel accepts
+const maxSheetName = 31
+
+// excelEpoch is day zero of the 1900 date system as Excel counts it
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// Writer writes a workbook sheet by sheet. Call AddSheet before writing rows
+// and Close to finish the archive.
+type Writer struct {
+	zw     *zip.Writer
+	sheet  *bufio.Writer
+	sheets []string
+	names  map[string]bool
+	row    int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w), names: make(map[string]bool)}
+}
+
+// AddSheet finishes the current sheet and starts a new one. The name is cleaned
+// up and shortened to what Excel accepts and made unique within the workbook.
+func (w *Writer) AddSheet(name string) error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+
+	name = w.uniqueName(sheetName(name))
+	w.sheets = append(w.sheets, name)
+	w.names[strings.ToLower(name)] = true
+
+	f, err := w.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.sheets)))
+	if err != nil {
+		return err
+	}
+	w.sheet = bufio.NewWriter(f)
+	w.row = 0
+
+	_, err = w.sheet.WriteString(xml.Header +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return err
+}
+
+// WriteHeader writes a row of bold column titles
+func (w *Writer) WriteHeader(titles ...string) error {
+	cells := make([]interface{}, len(titles))
+	for i, t := range titles {
+		cells[i] = t
+	}
+	return w.writeRow(styleHeader, cells)
+}
+
+// WriteRow writes one row. Cells may be strings, ints, time.Time, *time.Time or nil.
+func (w *Writer) WriteRow(cells ...interface{}) error {
+	return w.writeRow(styleDefault, cells)
+}
+
+func (w *Writer) writeRow(style int, cells []interface{}) error {
+	if w.sheet == nil {
+		return fmt.Errorf("xlsx: AddSheet must be called before writing rows")
+	}
+
+	w.row++
+	fmt.Fprintf(w.sheet, `<row r="%d">`, w.row)
+	for i, v := range cells {
+		ref := columnName(i) + strconv.Itoa(w.row)
+		switch v := v.(type) {
+		case nil:
+		case int:
+			fmt.Fprintf(w.sheet, `<c r="%s" s="%d"><v>%d</v></c>`, ref, style, v)
+		case time.Time:
+			w.writeDate(ref, v)
+		case *time.Time:
+			if v != nil {
+				w.writeDate(ref, *v)
+			}
+		case string:
+			fmt.Fprintf(w.sheet, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+			xml.EscapeText(w.sheet, []byte(v))
+			w.sheet.WriteString(`</t></is></c>`)
+		default:
+			return fmt.Errorf("xlsx: unsupported cell type %T", v)
+		}
+	}
+	_, err := w.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (w *Writer) writeDate(ref string, t time.Time) {
+	local := t.Local()
+	wall := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), 0, time.UTC)
+	serial := wall.Sub(excelEpoch).Hours() / 24
+	fmt.Fprintf(w.sheet, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleDate, strconv.FormatFloat(serial, 'f', -1, 64))
+}
+
+func (w *Writer) endSheet() error {
+	if w.sheet == nil {
+		return nil
+	}
+	if _, err := w.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	err := w.sheet.Flush()
+	w.sheet = nil
+	return err
+}
+
+// Close finishes the last sheet, writes the workbook parts and closes the archive.
+// A workbook needs at least one sheet, so an empty one is added when none was.
+func (w *Writer) Close() error {
+	if len(w.sheets) == 0 {
+		if err := w.AddSheet("Sheet1"); err != nil {
+			return err
+		}
+	}
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+
+	var contentTypes, workbook, workbookRels strings.Builder
+
+	contentTypes.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+
+	workbook.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+
+	workbookRels.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+
+	for i, name := range w.sheets {
+		n := i + 1
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escapeAttr(name), n, n)
+		fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&workbookRels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(w.sheets)+1)
+
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", rootRels},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", workbookRels.String()},
+		{"xl/styles.xml", styles},
+	}
+	for _, part := range parts {
+		f, err := w.zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+
+	return w.zw.Close()
+}
+
+func (w *Writer) uniqueName(name string) string {
+	if !w.names[strings.ToLower(name)] {
+		return name
+	}
+	for i := 2; ; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		candidate := name
+		if len(candidate)+len(suffix) > maxSheetName {
+			candidate = truncate(candidate, maxSheetName-len(suffix))
+		}
+		candidate += suffix
+		if !w.names[strings.ToLower(candidate)] {
+			return candidate
+		}
+	}
+}
+
+// sheetName removes the characters Excel does not allow in sheet names
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), "'")
+	if name == "" {
+		name = "Sheet"
+	}
+	return truncate(name, maxSheetName)
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !isRuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// columnName converts a zero-based column index to its letters: 0 → A, 26 → AA
+func columnName(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
+
+func escapeAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return strings.ReplaceAll(b.String(), `"`, "&quot;")
+}
+
+const rootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// styles defines the default style, a date-time format and bold header text,
+// in the order of the style constants
+const styles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm"/></numFmts>` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="3">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
@@ -0,0 +1,177 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readPart returns one part of a written workbook
+func readPart(t *testing.T, data []byte, name string) string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := zr.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestSheetNames(t *testing.T) {
+	long := strings.Repeat("x", 40)
+
+	tests := []struct {
+		name  string
+		added []string
+		want  []string
+	}{
+		{
+			name:  "characters Excel refuses",
+			added: []string{"Noord/Zuid: [1]?", "'Holding'"},
+			want:  []string{"Noord-Zuid- -1--", "Holding"},
+		},
+		{
+			name:  "empty name",
+			added: []string{"  ", "''"},
+			want:  []string{"Sheet", "Sheet (2)"},
+		},
+		{
+			name:  "duplicates ignore case",
+			added: []string{"Regio Noord", "regio noord", "REGIO NOORD"},
+			want:  []string{"Regio Noord", "regio noord (2)", "REGIO NOORD (3)"},
+		},
+		{
+			name:  "long names are shortened",
+			added: []string{long, long},
+			want:  []string{long[:31], long[:27] + " (2)"},
+		},
+		{
+			name:  "shortening keeps whole characters",
+			added: []string{strings.Repeat("x", 30) + "é"},
+			want:  []string{strings.Repeat("x", 30)},
+		},
+		{
+			name: "workbook without sheets",
+			want: []string{"Sheet1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWriter(io.Discard)
+			for _, name := range tt.added {
+				if err := w.AddSheet(name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.Join(w.sheets, "|"), strings.Join(tt.want, "|"); got != want {
+				t.Errorf("sheets %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"},
+		{25, "Z"},
+		{26, "AA"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+	for _, tt := range tests {
+		if got := columnName(tt.index); got != tt.want {
+			t.Errorf("columnName(%d) = %q, want %q", tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestWriteRowCells(t *testing.T) {
+	noon := time.Date(2024, 1, 2, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name string
+		cell interface{}
+		want string
+	}{
+		{"int", 1001, `<c r="A2" s="0"><v>1001</v></c>`},
+		{"string", "Noord & Zuid <1>", `<c r="A2" s="0" t="inlineStr"><is><t xml:space="preserve">Noord &amp; Zuid &lt;1&gt;</t></is></c>`},
+		{"formula-like string", "=1+1", `<c r="A2" s="0" t="inlineStr"><is><t xml:space="preserve">=1+1</t></is></c>`},
+		{"time", noon, `<c r="A2" s="1"><v>45293.5</v></c>`},
+		{"time pointer", &noon, `<c r="A2" s="1"><v>45293.5</v></c>`},
+		{"nil time pointer", (*time.Time)(nil), `<row r="2"></row>`},
+		{"nil", nil, `<row r="2"></row>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			if err := w.AddSheet("Access"); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteHeader("Value"); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteRow(tt.cell); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			sheet := readPart(t, buf.Bytes(), "xl/worksheets/sheet1.xml")
+			if !strings.Contains(sheet, `<c r="A1" s="2" t="inlineStr"><is><t xml:space="preserve">Value</t></is></c>`) {
+				t.Errorf("sheet has no bold header: %s", sheet)
+			}
+			if !strings.Contains(sheet, tt.want) {
+				t.Errorf("sheet does not contain %s: %s", tt.want, sheet)
+			}
+		})
+	}
+}
+
+func TestWriteRowErrors(t *testing.T) {
+	w := NewWriter(io.Discard)
+	if err := w.WriteRow("anna@voorbeeld.nl"); err == nil {
+		t.Error("WriteRow before AddSheet succeeded")
+	}
+	if err := w.AddSheet("Access"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow(1.5); err == nil || !strings.Contains(err.Error(), "unsupported cell type float64") {
+		t.Errorf("WriteRow with a float: %v, want an unsupported cell type error", err)
+	}
+}
+
+func TestWorkbookEscapesSheetNames(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if err := w.AddSheet(`Noord & "Zuid"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	workbook := readPart(t, buf.Bytes(), "xl/workbook.xml")
+	if !strings.Contains(workbook, `<sheet name="Noord &amp; &#34;Zuid&#34;" sheetId="1" r:id="rId1"/>`) {
+		t.Errorf("workbook does not escape the sheet name: %s", workbook)
+	}
+}